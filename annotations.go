@@ -0,0 +1,161 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/unitedtraders/proto-filter/internal/annotation"
+	"github.com/unitedtraders/proto-filter/internal/config"
+	"github.com/unitedtraders/proto-filter/internal/diag"
+	"github.com/unitedtraders/proto-filter/internal/filter"
+	"github.com/unitedtraders/proto-filter/internal/parser"
+)
+
+// runAnnotations implements `proto-filter annotations`, which lists every
+// annotation found in the input tree instead of writing output.
+func runAnnotations(args []string) int {
+	fs := flag.NewFlagSet("proto-filter annotations", flag.ContinueOnError)
+	inputDir := fs.String("input", "", "path to directory containing source .proto files")
+	configFile := fs.String("config", "", "path to YAML filter configuration file (annotation syntax, aliases and substitutions)")
+	format := fs.String("format", "text", "output format: text or json")
+	skeleton := fs.Bool("skeleton", false, "print a substitutions: YAML block for annotations without a substitution")
+	if err := fs.Parse(args); err != nil {
+		return 1
+	}
+
+	if *inputDir == "" {
+		fmt.Fprintln(os.Stderr, "proto-filter: error: --input flag is required")
+		fs.Usage()
+		return 1
+	}
+	if *format != "text" && *format != "json" {
+		fmt.Fprintf(os.Stderr, "proto-filter: error: unknown format %q, want text or json\n", *format)
+		return 1
+	}
+	absInput, err := filepath.Abs(*inputDir)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "proto-filter: error: %v\n", err)
+		return 1
+	}
+	if info, err := os.Stat(absInput); err != nil || !info.IsDir() {
+		fmt.Fprintf(os.Stderr, "proto-filter: error: input directory not found: %s\n", absInput)
+		return 1
+	}
+
+	var cfg *config.FilterConfig
+	if *configFile != "" {
+		cfg, err = config.LoadConfig(*configFile)
+		if err == nil {
+			err = cfg.Validate()
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "proto-filter: error: %v\n", err)
+			return 2
+		}
+	}
+	opts := filter.NewOptions(cfg)
+
+	files, err := parser.DiscoverProtoFiles(absInput)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "proto-filter: error: discovering proto files: %v\n", err)
+		return 1
+	}
+	defs, parseErrs := parser.ParseFiles(absInput, files)
+	if len(parseErrs) > 0 {
+		diags, _ := diag.NewReporter("text", os.Stderr, nil)
+		reportParseErrors(diags, *inputDir, parseErrs)
+		return 1
+	}
+	var locations []filter.AnnotationLocation
+	for i, rel := range files {
+		filter.ConvertBlockComments(defs[i])
+		locations = append(locations, filter.CollectAnnotationLocations(defs[i], rel, opts)...)
+	}
+	summaries := filter.SummarizeAnnotations(locations)
+
+	switch {
+	case *skeleton:
+		writeSubstitutionSkeleton(os.Stdout, unmappedAnnotations(cfg, summaries))
+	case *format == "json":
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(struct {
+			Files       int                        `json:"files"`
+			Occurrences int                        `json:"occurrences"`
+			Annotations []filter.AnnotationSummary `json:"annotations"`
+		}{len(files), len(locations), summaries}); err != nil {
+			fmt.Fprintf(os.Stderr, "proto-filter: error: %v\n", err)
+			return 1
+		}
+	default:
+		writeAnnotationInventory(os.Stdout, summaries, len(files), len(locations))
+	}
+	return 0
+}
+
+// writeAnnotationInventory prints one block per annotation name: the
+// occurrence count and element kinds, example arguments and locations.
+func writeAnnotationInventory(w io.Writer, summaries []filter.AnnotationSummary, files, occurrences int) {
+	for _, s := range summaries {
+		fmt.Fprintf(w, "%s  %s on %s\n", s.Name, plural(s.Count, "occurrence"), strings.Join(s.Kinds, ", "))
+		for _, example := range s.Examples {
+			fmt.Fprintf(w, "  example: (%s)\n", example)
+		}
+		for _, loc := range s.Locations {
+			fmt.Fprintf(w, "  %s:%d\n", loc.File, loc.Line)
+		}
+	}
+	if len(summaries) > 0 {
+		fmt.Fprintln(w)
+	}
+	fmt.Fprintf(w, "%s, %s in %s\n", plural(len(summaries), "annotation name"), plural(occurrences, "occurrence"), plural(files, "file"))
+}
+
+// unmappedAnnotations returns the summaries of annotations that have no
+// substitution and are not converted into options by the config.
+func unmappedAnnotations(cfg *config.FilterConfig, summaries []filter.AnnotationSummary) []filter.AnnotationSummary {
+	if cfg == nil {
+		return summaries
+	}
+	var unmapped []filter.AnnotationSummary
+	for _, s := range summaries {
+		if _, ok := filter.LookupSubstitution(cfg.Substitutions, s.Name); ok {
+			continue
+		}
+		if _, ok := annotation.Lookup(cfg.AnnotationOptions, s.Name); ok {
+			continue
+		}
+		unmapped = append(unmapped, s)
+	}
+	return unmapped
+}
+
+// writeSubstitutionSkeleton prints a substitutions: block with an empty
+// entry, which strips the annotation, for each summary. A comment after
+// each entry says where the annotation is used.
+func writeSubstitutionSkeleton(w io.Writer, summaries []filter.AnnotationSummary) {
+	if len(summaries) == 0 {
+		fmt.Fprintln(os.Stderr, "proto-filter: all annotations have a substitution")
+		return
+	}
+	fmt.Fprintln(w, "substitutions:")
+	for _, s := range summaries {
+		comment := fmt.Sprintf("%s on %s", plural(s.Count, "occurrence"), strings.Join(s.Kinds, ", "))
+		if len(s.Examples) > 0 {
+			comment += fmt.Sprintf(", e.g. (%s)", s.Examples[0])
+		}
+		fmt.Fprintf(w, "  %s: \"\" # %s\n", s.Name, comment)
+	}
+}
+
+func plural(n int, noun string) string {
+	if n == 1 {
+		return fmt.Sprintf("%d %s", n, noun)
+	}
+	return fmt.Sprintf("%d %ss", n, noun)
+}
@@ -0,0 +1,242 @@
+// Package annotation parses the arguments of comment annotations such as
+// @HasAnyRole({"ADMIN", "PARTNER"}) and the rules used in filter
+// configuration to select annotations by name and argument.
+package annotation
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Annotation is a single annotation occurrence with its parsed arguments.
+type Annotation struct {
+	Name string // annotation name, e.g. "HasAnyRole"
+	Raw  string // argument text between the parentheses, unparsed
+	Args []Arg  // parsed arguments; nil if there are none or Raw is malformed
+}
+
+// Arg is one annotation argument. Positional arguments have an empty Key;
+// key=value arguments carry the key name.
+type Arg struct {
+	Key   string
+	Value Value
+}
+
+// Value is an argument value: either a scalar (quoted string, identifier
+// or number) or a list written as {a, b} or [a, b].
+type Value struct {
+	Text   string  // scalar text with surrounding quotes removed
+	List   []Value // list elements when IsList is true
+	IsList bool
+}
+
+// Strings returns the scalar texts of the value: the text itself for a
+// scalar, or the flattened element texts for a list.
+func (v Value) Strings() []string {
+	if !v.IsList {
+		return []string{v.Text}
+	}
+	var out []string
+	for _, item := range v.List {
+		out = append(out, item.Strings()...)
+	}
+	return out
+}
+
+// Values returns the flattened scalar texts of all positional arguments.
+// For @HasAnyRole({"ADMIN", "PARTNER"}) it returns [ADMIN PARTNER].
+func (a Annotation) Values() []string {
+	var out []string
+	for _, arg := range a.Args {
+		if arg.Key == "" {
+			out = append(out, arg.Value.Strings()...)
+		}
+	}
+	return out
+}
+
+// Get returns the value of the key=value argument with the given key.
+func (a Annotation) Get(key string) (Value, bool) {
+	for _, arg := range a.Args {
+		if arg.Key == key {
+			return arg.Value, true
+		}
+	}
+	return Value{}, false
+}
+
+// New builds an Annotation from a name and its raw argument text. Malformed
+// arguments are tolerated: the annotation keeps its name and Raw text but
+// has no parsed Args, so only name-based rules can match it.
+func New(name, raw string) Annotation {
+	a := Annotation{Name: name, Raw: raw}
+	if args, err := ParseArgs(raw); err == nil {
+		a.Args = args
+	}
+	return a
+}
+
+// ParseArgs parses the text between an annotation's parentheses. The
+// grammar is a comma-separated list of arguments, each either a value or
+// key=value. A value is a double- or single-quoted string (with backslash
+// escapes), a bare identifier or number (letters, digits, '.', '-', '_',
+// '/', '+', ':'), or a list of values enclosed in {} or [].
+func ParseArgs(raw string) ([]Arg, error) {
+	p := &argParser{src: raw}
+	p.skipSpace()
+	if p.eof() {
+		return nil, nil
+	}
+	var args []Arg
+	for {
+		arg, err := p.parseArg()
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, arg)
+		p.skipSpace()
+		if p.eof() {
+			return args, nil
+		}
+		if p.peek() != ',' {
+			return nil, p.errorf("expected ',' but found %q", p.peek())
+		}
+		p.pos++
+	}
+}
+
+type argParser struct {
+	src string
+	pos int
+}
+
+func (p *argParser) eof() bool  { return p.pos >= len(p.src) }
+func (p *argParser) peek() byte { return p.src[p.pos] }
+
+func (p *argParser) skipSpace() {
+	for !p.eof() && isSpace(p.peek()) {
+		p.pos++
+	}
+}
+
+func (p *argParser) errorf(format string, args ...any) error {
+	return fmt.Errorf("annotation arguments %q: offset %d: %s", p.src, p.pos, fmt.Sprintf(format, args...))
+}
+
+func (p *argParser) parseArg() (Arg, error) {
+	p.skipSpace()
+	start := p.pos
+	if !p.eof() && isBareChar(p.peek()) {
+		word := p.bareWord()
+		p.skipSpace()
+		if !p.eof() && p.peek() == '=' {
+			p.pos++
+			v, err := p.parseValue()
+			if err != nil {
+				return Arg{}, err
+			}
+			return Arg{Key: word, Value: v}, nil
+		}
+		p.pos = start
+	}
+	v, err := p.parseValue()
+	if err != nil {
+		return Arg{}, err
+	}
+	return Arg{Value: v}, nil
+}
+
+func (p *argParser) parseValue() (Value, error) {
+	p.skipSpace()
+	if p.eof() {
+		return Value{}, p.errorf("expected a value")
+	}
+	switch c := p.peek(); {
+	case c == '"' || c == '\'':
+		s, err := p.quoted()
+		if err != nil {
+			return Value{}, err
+		}
+		return Value{Text: s}, nil
+	case c == '{' || c == '[':
+		return p.list()
+	case isBareChar(c):
+		return Value{Text: p.bareWord()}, nil
+	default:
+		return Value{}, p.errorf("unexpected %q", c)
+	}
+}
+
+func (p *argParser) list() (Value, error) {
+	closing := byte('}')
+	if p.peek() == '[' {
+		closing = ']'
+	}
+	p.pos++
+	v := Value{IsList: true}
+	p.skipSpace()
+	if !p.eof() && p.peek() == closing {
+		p.pos++
+		return v, nil
+	}
+	for {
+		item, err := p.parseValue()
+		if err != nil {
+			return Value{}, err
+		}
+		v.List = append(v.List, item)
+		p.skipSpace()
+		if p.eof() {
+			return Value{}, p.errorf("unterminated list, expected %q", closing)
+		}
+		switch p.peek() {
+		case ',':
+			p.pos++
+		case closing:
+			p.pos++
+			return v, nil
+		default:
+			return Value{}, p.errorf("expected ',' or %q but found %q", closing, p.peek())
+		}
+	}
+}
+
+func (p *argParser) quoted() (string, error) {
+	quote := p.peek()
+	p.pos++
+	var b strings.Builder
+	for !p.eof() {
+		c := p.peek()
+		p.pos++
+		switch {
+		case c == '\\' && !p.eof():
+			b.WriteByte(p.peek())
+			p.pos++
+		case c == quote:
+			return b.String(), nil
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return "", p.errorf("unterminated string")
+}
+
+func (p *argParser) bareWord() string {
+	start := p.pos
+	for !p.eof() && isBareChar(p.peek()) {
+		p.pos++
+	}
+	return p.src[start:p.pos]
+}
+
+func isSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\n' || c == '\r'
+}
+
+func isBareChar(c byte) bool {
+	switch {
+	case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9':
+		return true
+	}
+	return strings.IndexByte("._-/+:", c) >= 0
+}
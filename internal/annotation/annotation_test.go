@@ -0,0 +1,429 @@
+package annotation
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseArgs(t *testing.T) {
+	tests := []struct {
+		raw        string
+		wantValues []string
+		wantKeys   map[string][]string
+	}{
+		{``, nil, nil},
+		{`"ADMIN"`, []string{"ADMIN"}, nil},
+		{`partner`, []string{"partner"}, nil},
+		{`2.3`, []string{"2.3"}, nil},
+		{`{"ADMIN", "PARTNER"}`, []string{"ADMIN", "PARTNER"}, nil},
+		{`["a", b, 'c']`, []string{"a", "b", "c"}, nil},
+		{`{}`, nil, nil},
+		{`level=partner`, nil, map[string][]string{"level": {"partner"}}},
+		{`"x", roles = {A, B}, since="2.0"`, []string{"x"}, map[string][]string{"roles": {"A", "B"}, "since": {"2.0"}}},
+		{`"with \"escaped\" quote"`, []string{`with "escaped" quote`}, nil},
+		{`instant-payouts`, []string{"instant-payouts"}, nil},
+	}
+	for _, tc := range tests {
+		t.Run(tc.raw, func(t *testing.T) {
+			args, err := ParseArgs(tc.raw)
+			if err != nil {
+				t.Fatalf("ParseArgs(%q): %v", tc.raw, err)
+			}
+			a := Annotation{Name: "X", Raw: tc.raw, Args: args}
+			if got := a.Values(); !reflect.DeepEqual(got, tc.wantValues) {
+				t.Errorf("Values() = %q, want %q", got, tc.wantValues)
+			}
+			for key, want := range tc.wantKeys {
+				v, ok := a.Get(key)
+				if !ok {
+					t.Fatalf("Get(%q): not found", key)
+				}
+				if got := v.Strings(); !reflect.DeepEqual(got, want) {
+					t.Errorf("Get(%q) = %q, want %q", key, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestParseArgsErrors(t *testing.T) {
+	for _, raw := range []string{`"unterminated`, `{a, b`, `a b`, `a,`, `=x`} {
+		if _, err := ParseArgs(raw); err == nil {
+			t.Errorf("ParseArgs(%q): expected error", raw)
+		}
+	}
+}
+
+func TestNewToleratesMalformedArgs(t *testing.T) {
+	a := New("HasAnyRole", `{"ADMIN"`)
+	if a.Name != "HasAnyRole" || a.Raw != `{"ADMIN"` || a.Args != nil {
+		t.Errorf("unexpected annotation: %+v", a)
+	}
+}
+
+func TestParseRule(t *testing.T) {
+	tests := []struct {
+		in   string
+		want Rule
+	}{
+		{"Internal", Rule{Name: "Internal"}},
+		{"auth.Public", Rule{Name: "auth.Public"}},
+		{"Visibility == partner", Rule{Name: "Visibility", Op: OpEquals, Value: "partner"}},
+		{`HasAnyRole contains "PARTNER"`, Rule{Name: "HasAnyRole", Op: OpContains, Value: "PARTNER"}},
+		{`Visibility != "internal"`, Rule{Name: "Visibility", Op: OpNotEqual, Value: "internal"}},
+		{"Access[level] == partner", Rule{Name: "Access", Key: "level", Op: OpEquals, Value: "partner"}},
+		{`Note contains "a == b"`, Rule{Name: "Note", Op: OpContains, Value: "a == b"}},
+		{`Note == "x != y"`, Rule{Name: "Note", Op: OpEquals, Value: "x != y"}},
+		{`Note[text] != "uncontains it"`, Rule{Name: "Note", Key: "text", Op: OpNotEqual, Value: "uncontains it"}},
+		{`Access[level]=="a contains b"`, Rule{Name: "Access", Key: "level", Op: OpEquals, Value: "a contains b"}},
+	}
+	for _, tc := range tests {
+		t.Run(tc.in, func(t *testing.T) {
+			got, err := ParseRule(tc.in)
+			if err != nil {
+				t.Fatalf("ParseRule: %v", err)
+			}
+			if got != tc.want {
+				t.Errorf("ParseRule(%q) = %+v, want %+v", tc.in, got, tc.want)
+			}
+		})
+	}
+}
+
+func TestParseRuleErrors(t *testing.T) {
+	for _, in := range []string{"", "Has Any", "Name ==", "Name == {a}", "Name[key]", "Name[] == x", "@Name", "Name = x", "Name containsx"} {
+		if _, err := ParseRule(in); err == nil {
+			t.Errorf("ParseRule(%q): expected error", in)
+		}
+	}
+}
+
+func TestRuleMatches(t *testing.T) {
+	roles := New("HasAnyRole", `{"ADMIN", "PARTNER"}`)
+	vis := New("Visibility", `partner`)
+	keyed := New("Access", `level=partner, roles={A, B}`)
+	bare := New("Visibility", ``)
+
+	tests := []struct {
+		rule string
+		a    Annotation
+		want bool
+	}{
+		{"HasAnyRole", roles, true},
+		{"Internal", roles, false},
+		{`HasAnyRole contains "PARTNER"`, roles, true},
+		{`HasAnyRole contains "GUEST"`, roles, false},
+		{"Visibility == partner", vis, true},
+		{"Visibility == internal", vis, false},
+		{"Visibility != internal", vis, true},
+		{"Visibility != partner", vis, false},
+		{"Visibility == partner", bare, false},
+		{"Visibility != partner", bare, true},
+		{"Access[level] == partner", keyed, true},
+		{"Access[roles] contains B", keyed, true},
+		{"Access[missing] == partner", keyed, false},
+	}
+	for _, tc := range tests {
+		t.Run(tc.rule, func(t *testing.T) {
+			r, err := ParseRule(tc.rule)
+			if err != nil {
+				t.Fatalf("ParseRule: %v", err)
+			}
+			if got := r.Matches(tc.a); got != tc.want {
+				t.Errorf("%q.Matches(%+v) = %v, want %v", tc.rule, tc.a, got, tc.want)
+			}
+		})
+	}
+}
+
+func TestParseExpr(t *testing.T) {
+	tests := []struct {
+		expr  string
+		names []string
+		want  bool
+	}{
+		{"Public", []string{"Public"}, true},
+		{"Public", nil, false},
+		{"!Internal", nil, true},
+		{"!Internal", []string{"Internal"}, false},
+		{"Public && !Deprecated || Partner", []string{"Public"}, true},
+		{"Public && !Deprecated || Partner", []string{"Public", "Deprecated"}, false},
+		{"Public && !Deprecated || Partner", []string{"Deprecated", "Partner"}, true},
+		{"Public && (!Deprecated || Partner)", []string{"Deprecated", "Partner"}, false},
+		{"!!auth.Public", []string{"auth.Public"}, true},
+	}
+	for _, tc := range tests {
+		t.Run(tc.expr, func(t *testing.T) {
+			e, err := ParseExpr(tc.expr)
+			if err != nil {
+				t.Fatalf("ParseExpr: %v", err)
+			}
+			has := func(name string) bool {
+				for _, n := range tc.names {
+					if n == name {
+						return true
+					}
+				}
+				return false
+			}
+			if got := e.Eval(has); got != tc.want {
+				t.Errorf("%s with %v = %v, want %v", e, tc.names, got, tc.want)
+			}
+		})
+	}
+}
+
+func TestParseExprErrors(t *testing.T) {
+	for _, in := range []string{"", "Public &&", "(Public", "Public)", "Public Partner", "&& Public", "Public & Partner", "@Public"} {
+		if _, err := ParseExpr(in); err == nil {
+			t.Errorf("ParseExpr(%q): expected error", in)
+		}
+	}
+}
+
+func TestVersionCompare(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want int
+	}{
+		{"2.3", "2.3.0", 0},
+		{"v2.3", "2.3", 0},
+		{"2.10", "2.9", 1},
+		{"2.5", "3.0", -1},
+		{"3.0.0-rc.1", "3.0.0", -1},
+		{"3.0.0-rc.2", "3.0.0-rc.10", -1},
+		{"3.0.0-alpha", "3.0.0-1", 1},
+		{"3.0.0-rc", "3.0.0-rc.1", -1},
+		{"1.0.0+build.5", "1.0.0", 0},
+	}
+	for _, tc := range tests {
+		t.Run(tc.a+"_"+tc.b, func(t *testing.T) {
+			a, err := ParseVersion(tc.a)
+			if err != nil {
+				t.Fatalf("ParseVersion(%q): %v", tc.a, err)
+			}
+			b, err := ParseVersion(tc.b)
+			if err != nil {
+				t.Fatalf("ParseVersion(%q): %v", tc.b, err)
+			}
+			if got := a.Compare(b); got != tc.want {
+				t.Errorf("Compare(%s, %s) = %d, want %d", tc.a, tc.b, got, tc.want)
+			}
+		})
+	}
+}
+
+func TestParseVersionErrors(t *testing.T) {
+	for _, in := range []string{"", "two", "1.2.3.4", "1.-2", "1.0-"} {
+		if _, err := ParseVersion(in); err == nil {
+			t.Errorf("ParseVersion(%q): expected error", in)
+		}
+	}
+}
+
+func TestSyntaxesFindAll(t *testing.T) {
+	mustSyntax := func(s *Syntax, err error) *Syntax {
+		t.Helper()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return s
+	}
+	tests := []struct {
+		name     string
+		syntaxes Syntaxes
+		line     string
+		want     []Match
+	}{
+		{
+			"default",
+			DefaultSyntaxes,
+			` @HasAnyRole({"ADMIN"}) and [Internal]`,
+			[]Match{
+				{Name: "HasAnyRole", Args: `{"ADMIN"}`, Token: `@HasAnyRole({"ADMIN"})`, Start: 1, End: 23},
+				{Name: "Internal", Token: "[Internal]", Start: 28, End: 38},
+			},
+		},
+		{
+			"hash prefix",
+			Syntaxes{mustSyntax(NewDelimitedSyntax("#", "", "", ""))},
+			" #internal #beta",
+			[]Match{
+				{Name: "internal", Token: "#internal", Start: 1, End: 10},
+				{Name: "beta", Token: "#beta", Start: 11, End: 16},
+			},
+		},
+		{
+			"colon delimited",
+			Syntaxes{mustSyntax(NewDelimitedSyntax(":", ":", "(", ")"))},
+			" :since(2.1): only",
+			[]Match{{Name: "since", Args: "2.1", Token: ":since(2.1):", Start: 1, End: 13}},
+		},
+		{
+			"double at wins over single at",
+			append(Syntaxes{mustSyntax(NewDelimitedSyntax("@@", "", "(", ")"))}, DefaultSyntaxes...),
+			" @@Internal",
+			[]Match{{Name: "Internal", Token: "@@Internal", Start: 1, End: 11}},
+		},
+		{
+			"custom pattern listed first wins on tie",
+			append(Syntaxes{mustSyntax(NewSyntax(`@visibility\s+(?P<name>\w+)`))}, DefaultSyntaxes...),
+			" @visibility internal @Deprecated",
+			[]Match{
+				{Name: "internal", Token: "@visibility internal", Start: 1, End: 21},
+				{Name: "Deprecated", Token: "@Deprecated", Start: 22, End: 33},
+			},
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			got := tc.syntaxes.FindAll(tc.line)
+			if !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("FindAll(%q) =\n  %+v\nwant\n  %+v", tc.line, got, tc.want)
+			}
+		})
+	}
+}
+
+func TestSyntaxesReplaceAll(t *testing.T) {
+	got := DefaultSyntaxes.ReplaceAll(" @A(x) keep [B]", func(m Match) string {
+		return "<" + m.Name + ":" + m.Args + ">"
+	})
+	if want := " <A:x> keep <B:>"; got != want {
+		t.Errorf("ReplaceAll = %q, want %q", got, want)
+	}
+}
+
+func TestNewSyntaxErrors(t *testing.T) {
+	if _, err := NewSyntax(`@(\w+)`); err == nil {
+		t.Error("expected error for pattern without name group")
+	}
+	if _, err := NewSyntax(`@(?P<name>\w+`); err == nil {
+		t.Error("expected error for invalid pattern")
+	}
+	if _, err := NewDelimitedSyntax("", ":", "", ""); err == nil {
+		t.Error("expected error for empty prefix")
+	}
+}
+
+func TestMatchName(t *testing.T) {
+	tests := []struct {
+		pattern, name string
+		want          bool
+	}{
+		{"Internal", "Internal", true},
+		{"Internal", "InternalOnly", false},
+		{"Internal*", "InternalOnly", true},
+		{"Internal*", "Internal", true},
+		{"auth.*", "auth.HasAnyRole", true},
+		{"auth.*", "auth.roles.Admin", true},
+		{"auth.*", "authz.Public", false},
+		{"*.Deprecated", "ops.Deprecated", true},
+		{"*Role*", "auth.HasAnyRole", true},
+		{"a*b*c", "abbc", true},
+		{"a*b*c", "acb", false},
+		{"*", "Anything", true},
+	}
+	for _, tc := range tests {
+		if got := MatchName(tc.pattern, tc.name); got != tc.want {
+			t.Errorf("MatchName(%q, %q) = %v, want %v", tc.pattern, tc.name, got, tc.want)
+		}
+	}
+}
+
+func TestLookup(t *testing.T) {
+	m := map[string]string{
+		"auth.*":          "namespace",
+		"auth.Has*":       "prefix",
+		"auth.HasAnyRole": "exact",
+		"*":               "fallback",
+	}
+	tests := map[string]string{
+		"auth.HasAnyRole": "exact",
+		"auth.HasRole":    "prefix",
+		"auth.Public":     "namespace",
+		"Internal":        "fallback",
+	}
+	for name, want := range tests {
+		got, ok := Lookup(m, name)
+		if !ok || got != want {
+			t.Errorf("Lookup(%q) = %q, %v; want %q", name, got, ok, want)
+		}
+	}
+	if _, ok := Lookup(map[string]int{"auth.*": 1}, "ops.Deprecated"); ok {
+		t.Error("Lookup should not match outside the pattern")
+	}
+}
+
+func TestWildcardRuleAndExpr(t *testing.T) {
+	r, err := ParseRule(`auth.* contains "ADMIN"`)
+	if err != nil {
+		t.Fatalf("ParseRule: %v", err)
+	}
+	if !r.Matches(New("auth.HasAnyRole", `{"ADMIN"}`)) {
+		t.Error("wildcard rule should match auth.HasAnyRole")
+	}
+	if r.Matches(New("ops.HasAnyRole", `{"ADMIN"}`)) {
+		t.Error("wildcard rule should not match ops.HasAnyRole")
+	}
+
+	e, err := ParseExpr("auth.* && !Internal*")
+	if err != nil {
+		t.Fatalf("ParseExpr: %v", err)
+	}
+	has := func(names ...string) func(string) bool {
+		return func(pattern string) bool {
+			for _, n := range names {
+				if MatchName(pattern, n) {
+					return true
+				}
+			}
+			return false
+		}
+	}
+	if !e.Eval(has("auth.Public")) {
+		t.Error("expected auth.Public to satisfy the expression")
+	}
+	if e.Eval(has("auth.Public", "InternalOnly")) {
+		t.Error("expected InternalOnly to fail the expression")
+	}
+}
+
+func TestTemplate(t *testing.T) {
+	tests := []struct {
+		text string
+		want string
+	}{
+		{`Requires one of: {{.Args | join ", "}}`, "Requires one of: ADMIN, MANAGER"},
+		{`{{.Name}} on {{.Kind}} {{.FQN}}`, "HasAnyRole on rpc orders.OrderService.GetOrder"},
+		{`{{range .Args}}{{lower .}} {{end}}`, "admin manager "},
+		{`{{index .Args 0 | quote}}`, `"ADMIN"`},
+		{`scope={{.Keys.scope}} raw={{.Raw}}`, `scope=orders raw={"ADMIN", "MANAGER"}, scope=orders`},
+	}
+	a := New("HasAnyRole", `{"ADMIN", "MANAGER"}, scope=orders`)
+	data := NewTemplateData(a, "rpc", "orders.OrderService.GetOrder")
+	for _, tc := range tests {
+		if !IsTemplate(tc.text) {
+			t.Errorf("IsTemplate(%q) = false", tc.text)
+		}
+		tmpl, err := ParseTemplate(tc.text)
+		if err != nil {
+			t.Fatalf("ParseTemplate(%q): %v", tc.text, err)
+		}
+		var b strings.Builder
+		if err := tmpl.Execute(&b, data); err != nil {
+			t.Fatalf("Execute(%q): %v", tc.text, err)
+		}
+		if b.String() != tc.want {
+			t.Errorf("%q rendered %q, want %q", tc.text, b.String(), tc.want)
+		}
+	}
+
+	if IsTemplate("Requires %s") {
+		t.Error("plain text should not be a template")
+	}
+	if _, err := ParseTemplate("{{.Args | join"); err == nil {
+		t.Error("expected parse error")
+	}
+}
@@ -0,0 +1,164 @@
+package annotation
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Expr is a boolean expression over annotation names, such as
+// `Public && !Deprecated || Partner`.
+type Expr interface {
+	// Eval evaluates the expression; has reports whether the element
+	// being tested carries an annotation matching the name or pattern.
+	Eval(has func(name string) bool) bool
+	// Names returns the annotation names referenced by the expression.
+	Names() []string
+	String() string
+}
+
+// ParseExpr parses a boolean annotation expression. Operators are `!`
+// (not), `&&` (and) and `||` (or), in decreasing order of precedence;
+// parentheses group sub-expressions. Operands are annotation names or name
+// patterns such as `auth.*` (see MatchName).
+func ParseExpr(s string) (Expr, error) {
+	p := &exprParser{src: s}
+	p.next()
+	if p.tok == "" {
+		return nil, fmt.Errorf("annotation expression %q: empty expression", s)
+	}
+	e, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if p.tok != "" {
+		return nil, p.errorf("unexpected %q", p.tok)
+	}
+	return e, nil
+}
+
+type nameExpr string
+type notExpr struct{ x Expr }
+type andExpr struct{ l, r Expr }
+type orExpr struct{ l, r Expr }
+
+func (e nameExpr) Eval(has func(string) bool) bool { return has(string(e)) }
+func (e notExpr) Eval(has func(string) bool) bool  { return !e.x.Eval(has) }
+func (e andExpr) Eval(has func(string) bool) bool  { return e.l.Eval(has) && e.r.Eval(has) }
+func (e orExpr) Eval(has func(string) bool) bool   { return e.l.Eval(has) || e.r.Eval(has) }
+
+func (e nameExpr) Names() []string { return []string{string(e)} }
+func (e notExpr) Names() []string  { return e.x.Names() }
+func (e andExpr) Names() []string  { return append(e.l.Names(), e.r.Names()...) }
+func (e orExpr) Names() []string   { return append(e.l.Names(), e.r.Names()...) }
+
+func (e nameExpr) String() string { return string(e) }
+func (e notExpr) String() string  { return "!" + e.x.String() }
+func (e andExpr) String() string  { return "(" + e.l.String() + " && " + e.r.String() + ")" }
+func (e orExpr) String() string   { return "(" + e.l.String() + " || " + e.r.String() + ")" }
+
+type exprParser struct {
+	src string
+	pos int    // offset just past the current token
+	tok string // current token; empty at end of input
+	at  int    // offset of the current token
+}
+
+func (p *exprParser) errorf(format string, args ...any) error {
+	return fmt.Errorf("annotation expression %q: offset %d: %s", p.src, p.at, fmt.Sprintf(format, args...))
+}
+
+// next advances to the following token: an operator, a parenthesis or a name.
+func (p *exprParser) next() {
+	for p.pos < len(p.src) && isSpace(p.src[p.pos]) {
+		p.pos++
+	}
+	p.at = p.pos
+	if p.pos >= len(p.src) {
+		p.tok = ""
+		return
+	}
+	rest := p.src[p.pos:]
+	for _, op := range []string{"&&", "||", "!", "(", ")"} {
+		if strings.HasPrefix(rest, op) {
+			p.tok = op
+			p.pos += len(op)
+			return
+		}
+	}
+	end := p.pos
+	for end < len(p.src) && isNameChar(p.src[end]) {
+		end++
+	}
+	if end == p.pos {
+		end++ // single unexpected character
+	}
+	p.tok = p.src[p.pos:end]
+	p.pos = end
+}
+
+func (p *exprParser) parseOr() (Expr, error) {
+	l, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for p.tok == "||" {
+		p.next()
+		r, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		l = orExpr{l, r}
+	}
+	return l, nil
+}
+
+func (p *exprParser) parseAnd() (Expr, error) {
+	l, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	for p.tok == "&&" {
+		p.next()
+		r, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		l = andExpr{l, r}
+	}
+	return l, nil
+}
+
+func (p *exprParser) parseUnary() (Expr, error) {
+	switch {
+	case p.tok == "!":
+		p.next()
+		x, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return notExpr{x}, nil
+	case p.tok == "(":
+		p.next()
+		x, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if p.tok != ")" {
+			return nil, p.errorf("expected ')'")
+		}
+		p.next()
+		return x, nil
+	case p.tok == "":
+		return nil, p.errorf("unexpected end of expression")
+	case isName(p.tok):
+		name := nameExpr(p.tok)
+		p.next()
+		return name, nil
+	default:
+		return nil, p.errorf("unexpected %q", p.tok)
+	}
+}
+
+func isNameChar(c byte) bool {
+	return c == '_' || c == '.' || c == '*' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9'
+}
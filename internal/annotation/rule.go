@@ -0,0 +1,243 @@
+package annotation
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Rule operators.
+const (
+	OpNone     = ""
+	OpEquals   = "=="
+	OpNotEqual = "!="
+	OpContains = "contains"
+)
+
+// Rule selects annotations by name and optionally by argument. Rules are
+// written in configuration as one of:
+//
+//	Name                     any occurrence of @Name
+//	Name == value            first positional argument equals value
+//	Name != value            @Name whose first positional argument is not value
+//	Name contains value      any positional argument or list element equals value
+//	Name[key] == value       key=value argument equals value (also !=, contains)
+//
+// Values may be bare words or quoted strings. Name may contain `*`
+// wildcards (see MatchName).
+type Rule struct {
+	Name  string
+	Key   string
+	Op    string
+	Value string
+}
+
+// ParseRule parses a rule from its configuration form.
+func ParseRule(s string) (Rule, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return Rule{}, fmt.Errorf("empty annotation rule")
+	}
+
+	// The name and optional [key] come first; the operator, if any,
+	// follows them, so an operator inside the value is part of the value.
+	var r Rule
+	end := 0
+	for end < len(s) && isNameChar(s[end]) {
+		end++
+	}
+	head, rest := s[:end], strings.TrimSpace(s[end:])
+	if strings.HasPrefix(rest, "[") {
+		i := strings.IndexByte(rest, ']')
+		if i < 0 {
+			return Rule{}, fmt.Errorf("annotation rule %q: unterminated argument key", s)
+		}
+		r.Key = strings.TrimSpace(rest[1:i])
+		rest = strings.TrimSpace(rest[i+1:])
+		if r.Key == "" {
+			return Rule{}, fmt.Errorf("annotation rule %q: empty argument key", s)
+		}
+	}
+	if !isName(head) {
+		return Rule{}, fmt.Errorf("annotation rule %q: invalid annotation name %q", s, head)
+	}
+	r.Name = head
+
+	switch {
+	case rest == "":
+	case strings.HasPrefix(rest, OpEquals), strings.HasPrefix(rest, OpNotEqual):
+		r.Op, rest = rest[:2], rest[2:]
+	case strings.HasPrefix(rest, OpContains+" "), strings.HasPrefix(rest, OpContains+`"`):
+		r.Op, rest = OpContains, rest[len(OpContains):]
+	default:
+		return Rule{}, fmt.Errorf("annotation rule %q: expected ==, != or contains after %q", s, s[:len(s)-len(rest)])
+	}
+	if r.Key != "" && r.Op == OpNone {
+		return Rule{}, fmt.Errorf("annotation rule %q: argument key requires an operator", s)
+	}
+
+	if r.Op != OpNone {
+		args, err := ParseArgs(strings.TrimSpace(rest))
+		if err != nil || len(args) != 1 || args[0].Key != "" || args[0].Value.IsList {
+			return Rule{}, fmt.Errorf("annotation rule %q: expected a single value after %q", s, r.Op)
+		}
+		r.Value = args[0].Value.Text
+	}
+	return r, nil
+}
+
+// MustParseRule is like ParseRule but treats an unparsable rule as a plain
+// name that matches nothing but itself. It is meant for callers that have
+// already validated their rules.
+func MustParseRule(s string) Rule {
+	r, err := ParseRule(s)
+	if err != nil {
+		return Rule{Name: s}
+	}
+	return r
+}
+
+// String returns the configuration form of the rule.
+func (r Rule) String() string {
+	s := r.Name
+	if r.Key != "" {
+		s += "[" + r.Key + "]"
+	}
+	if r.Op != OpNone {
+		s += " " + r.Op + " " + fmt.Sprintf("%q", r.Value)
+	}
+	return s
+}
+
+// FoldRule returns the rule with its annotation name in lower case and
+// the rest, such as the value an argument is compared with, as written.
+func FoldRule(s string) string {
+	s = strings.TrimSpace(s)
+	end := 0
+	for end < len(s) && isNameChar(s[end]) {
+		end++
+	}
+	return strings.ToLower(s[:end]) + s[end:]
+}
+
+// Matches reports whether the annotation satisfies the rule.
+func (r Rule) Matches(a Annotation) bool {
+	if !MatchName(r.Name, a.Name) {
+		return false
+	}
+	if r.Op == OpNone {
+		return true
+	}
+
+	var first string
+	var all []string
+	if r.Key != "" {
+		v, ok := a.Get(r.Key)
+		if ok {
+			all = v.Strings()
+			if !v.IsList {
+				first = v.Text
+			}
+		}
+	} else {
+		all = a.Values()
+		for _, arg := range a.Args {
+			if arg.Key == "" {
+				if !arg.Value.IsList {
+					first = arg.Value.Text
+				}
+				break
+			}
+		}
+	}
+
+	switch r.Op {
+	case OpEquals:
+		return len(all) > 0 && first == r.Value
+	case OpNotEqual:
+		return len(all) == 0 || first != r.Value
+	case OpContains:
+		for _, v := range all {
+			if v == r.Value {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// IsPattern reports whether name contains a `*` wildcard.
+func IsPattern(name string) bool {
+	return strings.Contains(name, "*")
+}
+
+// MatchName reports whether an annotation name matches pattern. A `*`
+// matches any run of characters, dots included, so `auth.*` matches every
+// annotation in the auth namespace and `Internal*` matches InternalOnly.
+// A pattern without wildcards matches only the identical name.
+func MatchName(pattern, name string) bool {
+	if !IsPattern(pattern) {
+		return pattern == name
+	}
+	parts := strings.Split(pattern, "*")
+	if !strings.HasPrefix(name, parts[0]) {
+		return false
+	}
+	name = name[len(parts[0]):]
+	last := parts[len(parts)-1]
+	for _, part := range parts[1 : len(parts)-1] {
+		i := strings.Index(name, part)
+		if i < 0 {
+			return false
+		}
+		name = name[i+len(part):]
+	}
+	return strings.HasSuffix(name, last)
+}
+
+// Lookup returns the value keyed by an annotation name in a map whose keys
+// may be name patterns. An exact key takes precedence, then the most
+// specific matching pattern (the one with the most literal characters;
+// ties go to the alphabetically first key).
+func Lookup[V any](m map[string]V, name string) (V, bool) {
+	if v, ok := m[name]; ok {
+		return v, true
+	}
+	best, found := "", false
+	for key := range m {
+		if MatchName(key, name) && (!found || preferKey(key, best)) {
+			best, found = key, true
+		}
+	}
+	return m[best], found
+}
+
+// preferKey reports whether lookup key a is a better match than b: names
+// beat patterns, then more literal characters win, then alphabetical order.
+func preferKey(a, b string) bool {
+	if IsPattern(a) != IsPattern(b) {
+		return !IsPattern(a)
+	}
+	if specificity(a) != specificity(b) {
+		return specificity(a) > specificity(b)
+	}
+	return a < b
+}
+
+func specificity(pattern string) int {
+	return len(pattern) - strings.Count(pattern, "*")
+}
+
+// isName reports whether s is a valid annotation name or name pattern: a
+// word character or wildcard followed by word characters, dots and
+// wildcards.
+func isName(s string) bool {
+	if s == "" || s[0] == '.' {
+		return false
+	}
+	for i := 0; i < len(s); i++ {
+		if !isNameChar(s[i]) {
+			return false
+		}
+	}
+	return true
+}
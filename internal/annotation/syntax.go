@@ -0,0 +1,137 @@
+package annotation
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+)
+
+// Syntax recognises one way of writing annotations in comments, such as
+// `@Name(args)` or `#name`. It is a regular expression with a named group
+// "name" and an optional named group "args".
+type Syntax struct {
+	re   *regexp.Regexp
+	name int
+	args int // -1 if the syntax takes no arguments
+}
+
+// NewSyntax compiles a custom annotation syntax. The pattern must contain a
+// named group "name"; a named group "args" captures the argument text.
+func NewSyntax(pattern string) (*Syntax, error) {
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, fmt.Errorf("invalid annotation pattern %q: %w", pattern, err)
+	}
+	s := &Syntax{re: re, name: re.SubexpIndex("name"), args: re.SubexpIndex("args")}
+	if s.name < 0 {
+		return nil, fmt.Errorf("annotation pattern %q has no (?P<name>...) group", pattern)
+	}
+	return s, nil
+}
+
+// NewDelimitedSyntax builds a syntax from its delimiters: the annotation
+// name follows prefix and precedes suffix, and arguments, when present, sit
+// between argsOpen and argsClose right after the name. Arguments are not
+// recognised if either argument delimiter is empty.
+func NewDelimitedSyntax(prefix, suffix, argsOpen, argsClose string) (*Syntax, error) {
+	if prefix == "" {
+		return nil, fmt.Errorf("annotation syntax requires a prefix")
+	}
+	pattern := regexp.QuoteMeta(prefix) + `(?P<name>\w[\w.]*)`
+	if argsOpen != "" && argsClose != "" {
+		pattern += `(?:` + regexp.QuoteMeta(argsOpen) + `(?P<args>.*?)` + regexp.QuoteMeta(argsClose) + `)?`
+	}
+	pattern += regexp.QuoteMeta(suffix)
+	return NewSyntax(pattern)
+}
+
+// Match is a single annotation occurrence within a line of text.
+type Match struct {
+	Name  string
+	Args  string
+	Token string // full annotation token as it appears in the text
+	Start int    // byte offset of Token in the line
+	End   int
+}
+
+// Syntaxes is an ordered set of annotation syntaxes.
+type Syntaxes []*Syntax
+
+// DefaultSyntaxes recognises `@Name`, `@Name(args)`, `[Name]` and
+// `[Name(args)]`.
+var DefaultSyntaxes = Syntaxes{
+	mustDelimited("@", "", "(", ")"),
+	mustDelimited("[", "]", "(", ")"),
+}
+
+func mustDelimited(prefix, suffix, argsOpen, argsClose string) *Syntax {
+	s, err := NewDelimitedSyntax(prefix, suffix, argsOpen, argsClose)
+	if err != nil {
+		panic(err)
+	}
+	return s
+}
+
+// FindAll returns the annotations in line, ordered by position. Where
+// matches of different syntaxes overlap, the leftmost one wins, and among
+// those starting at the same offset the syntax listed first wins.
+func (ss Syntaxes) FindAll(line string) []Match {
+	var all []Match
+	for _, s := range ss {
+		for _, loc := range s.re.FindAllStringSubmatchIndex(line, -1) {
+			if loc[0] == loc[1] {
+				continue
+			}
+			m := Match{
+				Name:  group(line, loc, s.name),
+				Token: line[loc[0]:loc[1]],
+				Start: loc[0],
+				End:   loc[1],
+			}
+			if s.args >= 0 {
+				m.Args = group(line, loc, s.args)
+			}
+			if m.Name == "" {
+				continue
+			}
+			all = append(all, m)
+		}
+	}
+	sort.SliceStable(all, func(a, b int) bool { return all[a].Start < all[b].Start })
+
+	var matches []Match
+	end := 0
+	for _, m := range all {
+		if m.Start < end {
+			continue
+		}
+		matches = append(matches, m)
+		end = m.End
+	}
+	return matches
+}
+
+// ReplaceAll returns a copy of line with each annotation token replaced by
+// the result of repl.
+func (ss Syntaxes) ReplaceAll(line string, repl func(Match) string) string {
+	matches := ss.FindAll(line)
+	if len(matches) == 0 {
+		return line
+	}
+	var b []byte
+	last := 0
+	for _, m := range matches {
+		b = append(b, line[last:m.Start]...)
+		b = append(b, repl(m)...)
+		last = m.End
+	}
+	b = append(b, line[last:]...)
+	return string(b)
+}
+
+func group(line string, loc []int, i int) string {
+	if loc[2*i] < 0 {
+		return ""
+	}
+	return line[loc[2*i]:loc[2*i+1]]
+}
@@ -0,0 +1,50 @@
+package annotation
+
+import (
+	"strconv"
+	"strings"
+	"text/template"
+)
+
+// TemplateData is the data a substitution template is executed with.
+type TemplateData struct {
+	Name string            // annotation name
+	Args []string          // positional argument values, lists flattened
+	Keys map[string]string // key=value arguments; list values joined with ", "
+	Raw  string            // argument text as written
+	Kind string            // kind of the annotated element, e.g. "rpc" or "field"
+	FQN  string            // fully qualified name of the annotated element
+}
+
+// NewTemplateData returns the template data for an annotation on the given
+// element.
+func NewTemplateData(a Annotation, kind, fqn string) TemplateData {
+	d := TemplateData{Name: a.Name, Args: a.Values(), Keys: make(map[string]string), Raw: a.Raw, Kind: kind, FQN: fqn}
+	for _, arg := range a.Args {
+		if arg.Key != "" {
+			d.Keys[arg.Key] = strings.Join(arg.Value.Strings(), ", ")
+		}
+	}
+	return d
+}
+
+// templateFuncs are the helper functions available to substitution
+// templates. join takes the separator first so it can end a pipeline:
+// {{.Args | join ", "}}.
+var templateFuncs = template.FuncMap{
+	"join":  func(sep string, items []string) string { return strings.Join(items, sep) },
+	"lower": strings.ToLower,
+	"upper": strings.ToUpper,
+	"quote": strconv.Quote,
+}
+
+// IsTemplate reports whether substitution text uses template actions.
+func IsTemplate(text string) bool {
+	return strings.Contains(text, "{{")
+}
+
+// ParseTemplate parses substitution text as a text/template with the
+// helper functions join, lower, upper and quote.
+func ParseTemplate(text string) (*template.Template, error) {
+	return template.New("substitution").Funcs(templateFuncs).Option("missingkey=zero").Parse(text)
+}
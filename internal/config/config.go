@@ -2,17 +2,30 @@ package config
 
 import (
 	"fmt"
+	"io"
 	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"text/template"
 
 	"gopkg.in/yaml.v3"
+
+	"github.com/unitedtraders/proto-filter/internal/annotation"
 )
 
-// AnnotationConfig holds include/exclude annotation filter lists.
+// AnnotationConfig holds include/exclude annotation filter lists. Entries
+// are annotation names or argument rules such as `HasAnyRole contains "ADMIN"`
+// (see annotation.ParseRule).
 // Supports both the old flat format (annotations: [list]) and the new
 // structured format (annotations: {include: [...], exclude: [...]}).
+// Expr is a boolean expression over annotation names (see
+// annotation.ParseExpr) and cannot be combined with the lists.
 type AnnotationConfig struct {
 	Include []string `yaml:"include"`
 	Exclude []string `yaml:"exclude"`
+	Expr    string   `yaml:"expr"`
 }
 
 // UnmarshalYAML implements custom YAML unmarshaling for AnnotationConfig.
@@ -45,11 +58,358 @@ func (a *AnnotationConfig) UnmarshalYAML(value *yaml.Node) error {
 
 // FilterConfig holds include/exclude glob patterns and annotation filters.
 type FilterConfig struct {
-	Include              []string          `yaml:"include"`
-	Exclude              []string          `yaml:"exclude"`
-	Annotations          AnnotationConfig  `yaml:"annotations"`
-	Substitutions        map[string]string `yaml:"substitutions"`
-	StrictSubstitutions  bool              `yaml:"strict_substitutions"`
+	Include             []string                `yaml:"include"`
+	Exclude             []string                `yaml:"exclude"`
+	Annotations         AnnotationConfig        `yaml:"annotations"`
+	Substitutions       map[string]Substitution `yaml:"substitutions"`
+	StrictSubstitutions bool                    `yaml:"strict_substitutions"`
+	APIVersion          string                  `yaml:"api_version"`
+	Features            *FeatureConfig          `yaml:"features"`
+	Tiers               *TierConfig             `yaml:"tiers"`
+	AnnotationSyntax    []SyntaxConfig          `yaml:"annotation_syntax"`
+
+	// AnnotationAliases maps alternative annotation names (or name
+	// patterns) to the canonical name used by filters and substitutions.
+	AnnotationAliases map[string]string `yaml:"annotation_aliases"`
+	// CaseInsensitiveAnnotations makes annotation names match regardless
+	// of case, in comments and in configuration alike.
+	CaseInsensitiveAnnotations bool `yaml:"case_insensitive_annotations"`
+	// OptionAnnotations lets proto options act as annotations.
+	OptionAnnotations []OptionAnnotation `yaml:"option_annotations"`
+	// AnnotationOptions converts annotations, keyed by name or pattern,
+	// into proto options in the output.
+	AnnotationOptions map[string]OptionConversion `yaml:"annotation_options"`
+	// SubstitutionWrap wraps lines produced by substitutions to this many
+	// characters of comment text; 0 disables wrapping.
+	SubstitutionWrap int `yaml:"substitution_wrap"`
+	// StrictUnused fails the run when substitution keys or annotation
+	// filter names match no annotation in the input.
+	StrictUnused bool `yaml:"strict_unused"`
+	// Format controls how output files are rendered.
+	Format FormatConfig `yaml:"format"`
+	// Header is a text/template prepended to every output file as a
+	// comment, executed with HeaderData.
+	Header string `yaml:"header"`
+	// Profile names this configuration in headers. It defaults to the
+	// config file name without its extension.
+	Profile string `yaml:"profile"`
+
+	// templates holds the substitution templates parsed by LoadConfig.
+	templates map[string]*template.Template
+}
+
+// HeaderData is the data the header template is executed with.
+type HeaderData struct {
+	Source  string // path of the source file, relative to the input directory
+	Config  string // path of the config file
+	Profile string // profile name
+	Commit  string // git commit of the input directory, if it is in a repository
+	Hash    string // SHA-256 of the file content below the header, in hex
+}
+
+// HeaderTemplate parses the header template. It returns nil if no header
+// is configured.
+func (c *FilterConfig) HeaderTemplate() (*template.Template, error) {
+	if c.Header == "" {
+		return nil, nil
+	}
+	tmpl, err := template.New("header").Option("missingkey=error").Parse(c.Header)
+	if err != nil {
+		return nil, fmt.Errorf("header: %w", err)
+	}
+	if err := tmpl.Execute(io.Discard, HeaderData{}); err != nil {
+		return nil, fmt.Errorf("header: %w", err)
+	}
+	return tmpl, nil
+}
+
+// ProfileName returns Profile, or the base name of configFile without its
+// extension.
+func (c *FilterConfig) ProfileName(configFile string) string {
+	if c.Profile != "" {
+		return c.Profile
+	}
+	base := filepath.Base(configFile)
+	return strings.TrimSuffix(base, filepath.Ext(base))
+}
+
+// FormatConfig controls how output files are rendered. The zero value is
+// the default formatting: two-space indentation, aligned fields, one blank
+// line between definitions, block comments converted, no comment wrapping
+// and imports in source order.
+type FormatConfig struct {
+	// IndentWidth is the number of IndentChar per indentation level;
+	// 0 means 2 for spaces and 1 for tabs.
+	IndentWidth int `yaml:"indent_width"`
+	// IndentChar is "space" (the default) or "tab".
+	IndentChar string `yaml:"indent_char"`
+	// AlignFields aligns fields, enum values and RPCs in columns; true if
+	// unset.
+	AlignFields *bool `yaml:"align_fields"`
+	// BlankLines is the number of blank lines before each top-level
+	// message, enum and service; 1 if unset.
+	BlankLines *int `yaml:"blank_lines"`
+	// ConvertBlockComments rewrites /* */ comments as // comments; true if
+	// unset.
+	ConvertBlockComments *bool `yaml:"convert_block_comments"`
+	// CommentWidth wraps comment lines longer than this many characters of
+	// comment text; 0 disables wrapping.
+	CommentWidth int `yaml:"comment_width"`
+	// ImportOrder is "source" (the default) or "sorted".
+	ImportOrder string `yaml:"import_order"`
+}
+
+// Indent values and import orders of FormatConfig.
+const (
+	IndentSpace       = "space"
+	IndentTab         = "tab"
+	ImportOrderSource = "source"
+	ImportOrderSorted = "sorted"
+)
+
+const defaultIndentWidth = 2
+
+// Indent returns the text of one indentation level.
+func (f FormatConfig) Indent() string {
+	char, width := " ", defaultIndentWidth
+	if f.IndentChar == IndentTab {
+		char, width = "\t", 1
+	}
+	if f.IndentWidth > 0 {
+		width = f.IndentWidth
+	}
+	return strings.Repeat(char, width)
+}
+
+// Aligned reports whether fields are aligned in columns.
+func (f FormatConfig) Aligned() bool {
+	return f.AlignFields == nil || *f.AlignFields
+}
+
+// BlankLineCount returns the number of blank lines before each top-level
+// definition.
+func (f FormatConfig) BlankLineCount() int {
+	if f.BlankLines == nil {
+		return 1
+	}
+	return *f.BlankLines
+}
+
+// ConvertsBlockComments reports whether block comments are converted to
+// single-line comments.
+func (f FormatConfig) ConvertsBlockComments() bool {
+	return f.ConvertBlockComments == nil || *f.ConvertBlockComments
+}
+
+func (f FormatConfig) validate() error {
+	if f.IndentWidth < 0 {
+		return fmt.Errorf("format.indent_width must not be negative, got %d", f.IndentWidth)
+	}
+	if f.IndentChar != "" && f.IndentChar != IndentSpace && f.IndentChar != IndentTab {
+		return fmt.Errorf("format.indent_char must be %q or %q, got %q", IndentSpace, IndentTab, f.IndentChar)
+	}
+	if f.BlankLines != nil && *f.BlankLines < 0 {
+		return fmt.Errorf("format.blank_lines must not be negative, got %d", *f.BlankLines)
+	}
+	if f.CommentWidth < 0 {
+		return fmt.Errorf("format.comment_width must not be negative, got %d", f.CommentWidth)
+	}
+	if f.ImportOrder != "" && f.ImportOrder != ImportOrderSource && f.ImportOrder != ImportOrderSorted {
+		return fmt.Errorf("format.import_order must be %q or %q, got %q", ImportOrderSource, ImportOrderSorted, f.ImportOrder)
+	}
+	return nil
+}
+
+// OptionConversion describes the proto option an annotation becomes. Option
+// is the option name, e.g. `deprecated` or `(myapp.auth).roles`. Value is
+// the constant as it should appear in the proto file, e.g. `true`, `INTERNAL`
+// or `"ADMIN"`, and defaults to true. A `%s` in Value is replaced by each
+// argument of the annotation in turn, producing one option per argument.
+type OptionConversion struct {
+	Option string `yaml:"option"`
+	Value  string `yaml:"value"`
+}
+
+// OptionAnnotation maps a proto option to an annotation name. Option is the
+// option name as written in the proto file, e.g. `(myapp.visibility)` or
+// `deprecated`; when Value is set, only options with that value (enum
+// identifier, literal or unquoted string) are mapped.
+type OptionAnnotation struct {
+	Option     string `yaml:"option"`
+	Value      string `yaml:"value"`
+	Annotation string `yaml:"annotation"`
+}
+
+// SyntaxConfig declares one way annotations are written in comments.
+// Exactly one of Preset, Prefix or Pattern is set:
+//
+//   - Preset "default" is the built-in @Name(args) and [Name(args)] syntax.
+//   - Prefix, Suffix, ArgsOpen and ArgsClose give the delimiters around the
+//     name and arguments, e.g. prefix "#" for #internal. Arguments default
+//     to parentheses.
+//   - Pattern is a regular expression with a (?P<name>...) group and an
+//     optional (?P<args>...) group, e.g. `@visibility\s+(?P<name>\w+)`.
+type SyntaxConfig struct {
+	Preset    string `yaml:"preset"`
+	Prefix    string `yaml:"prefix"`
+	Suffix    string `yaml:"suffix"`
+	ArgsOpen  string `yaml:"args_open"`
+	ArgsClose string `yaml:"args_close"`
+	Pattern   string `yaml:"pattern"`
+}
+
+func (s SyntaxConfig) compile() (annotation.Syntaxes, error) {
+	set := 0
+	for _, v := range []string{s.Preset, s.Prefix, s.Pattern} {
+		if v != "" {
+			set++
+		}
+	}
+	if set != 1 {
+		return nil, fmt.Errorf("exactly one of preset, prefix or pattern is required")
+	}
+	switch {
+	case s.Preset == "default":
+		return annotation.DefaultSyntaxes, nil
+	case s.Preset != "":
+		return nil, fmt.Errorf("unknown preset %q", s.Preset)
+	case s.Pattern != "":
+		syntax, err := annotation.NewSyntax(s.Pattern)
+		if err != nil {
+			return nil, err
+		}
+		return annotation.Syntaxes{syntax}, nil
+	}
+	argsOpen, argsClose := s.ArgsOpen, s.ArgsClose
+	if argsOpen == "" && argsClose == "" {
+		argsOpen, argsClose = "(", ")"
+	}
+	syntax, err := annotation.NewDelimitedSyntax(s.Prefix, s.Suffix, argsOpen, argsClose)
+	if err != nil {
+		return nil, err
+	}
+	return annotation.Syntaxes{syntax}, nil
+}
+
+// DefaultKind is the Substitution key used for elements whose kind has no
+// entry of its own.
+const DefaultKind = "default"
+
+// elementKinds are the element kinds a Substitution may be keyed by.
+var elementKinds = []string{"service", "rpc", "message", "field", "oneof", "enum", "enum_value"}
+
+// Substitution holds the replacement text for an annotation, keyed by the
+// kind of element the annotation is attached to, with DefaultKind as the
+// fallback. In YAML it is either a string, used for every kind:
+//
+//	Internal: "For internal use only"
+//
+// or a mapping by kind:
+//
+//	Internal:
+//	  service: "Internal service - not available externally"
+//	  field: "Reserved for internal use"
+//	  default: "For internal use only"
+type Substitution map[string]string
+
+// UnmarshalYAML accepts a string or a mapping by element kind.
+func (s *Substitution) UnmarshalYAML(value *yaml.Node) error {
+	switch value.Kind {
+	case yaml.ScalarNode:
+		var text string
+		if err := value.Decode(&text); err != nil {
+			return err
+		}
+		*s = Substitution{DefaultKind: text}
+		return nil
+	case yaml.MappingNode:
+		var kinds map[string]string
+		if err := value.Decode(&kinds); err != nil {
+			return err
+		}
+		*s = Substitution(kinds)
+		return nil
+	default:
+		return fmt.Errorf("substitution must be a string or a mapping by element kind, got %v", value.Kind)
+	}
+}
+
+// Text returns the replacement text for an element kind, falling back to
+// the default entry. It returns false if neither is present.
+func (s Substitution) Text(kind string) (string, bool) {
+	if text, ok := s[kind]; ok {
+		return text, true
+	}
+	text, ok := s[DefaultKind]
+	return text, ok
+}
+
+// TierConfig declares ordered visibility tiers, from most to least public,
+// and which tier the output is generated for.
+type TierConfig struct {
+	Levels  []TierLevel `yaml:"levels"`
+	Default string      `yaml:"default"` // tier of elements without a tier annotation
+	Select  string      `yaml:"select"`  // tier to generate; overridden by --tier
+}
+
+// TierLevel names a tier and the annotations that place an element in it.
+type TierLevel struct {
+	Name        string   `yaml:"name"`
+	Annotations []string `yaml:"annotations"`
+}
+
+// Rank returns the position of the named tier in Levels, or -1 if there is
+// no such tier.
+func (t TierConfig) Rank(name string) int {
+	for i, level := range t.Levels {
+		if level.Name == name {
+			return i
+		}
+	}
+	return -1
+}
+
+func (t TierConfig) validate() error {
+	if len(t.Levels) == 0 {
+		return fmt.Errorf("tiers.levels must declare at least one tier")
+	}
+	seen := make(map[string]bool)
+	owner := make(map[string]string)
+	for _, level := range t.Levels {
+		if level.Name == "" {
+			return fmt.Errorf("tiers.levels: tier name is required")
+		}
+		if seen[level.Name] {
+			return fmt.Errorf("tiers.levels: duplicate tier %q", level.Name)
+		}
+		seen[level.Name] = true
+		for _, a := range level.Annotations {
+			if prev, ok := owner[a]; ok {
+				return fmt.Errorf("tiers.levels: annotation %q is mapped to both %q and %q", a, prev, level.Name)
+			}
+			owner[a] = level.Name
+		}
+	}
+	if t.Default == "" {
+		return fmt.Errorf("tiers.default is required")
+	}
+	if t.Rank(t.Default) < 0 {
+		return fmt.Errorf("tiers.default: unknown tier %q", t.Default)
+	}
+	if t.Select == "" {
+		return fmt.Errorf("no tier selected: set tiers.select or pass --tier")
+	}
+	if t.Rank(t.Select) < 0 {
+		return fmt.Errorf("unknown tier %q", t.Select)
+	}
+	return nil
+}
+
+// FeatureConfig lists the feature flags enabled for this output. When the
+// section is present, elements tagged @Feature("name") with a flag that is
+// not listed are removed.
+type FeatureConfig struct {
+	Enabled []string `yaml:"enabled"`
 }
 
 // LoadConfig reads and parses a YAML filter configuration file.
@@ -63,24 +423,238 @@ func LoadConfig(path string) (*FilterConfig, error) {
 	if err := yaml.Unmarshal(data, &cfg); err != nil {
 		return nil, fmt.Errorf("parsing config YAML: %w", err)
 	}
+	if cfg.CaseInsensitiveAnnotations {
+		if err := cfg.foldNames(); err != nil {
+			return nil, err
+		}
+	}
+	if cfg.templates, err = cfg.SubstitutionTemplates(); err != nil {
+		return nil, err
+	}
 
 	return &cfg, nil
 }
 
-// Validate checks the configuration for invalid combinations.
+// foldNames lowercases the annotation names and patterns in the
+// configuration, so that they compare equal to annotation names read with
+// case_insensitive_annotations, which are folded the same way. Map keys
+// that differ only in case are an error.
+func (c *FilterConfig) foldNames() error {
+	c.Annotations.Include = foldRules(c.Annotations.Include)
+	c.Annotations.Exclude = foldRules(c.Annotations.Exclude)
+	c.Annotations.Expr = strings.ToLower(c.Annotations.Expr)
+	var err error
+	if c.Substitutions, err = foldKeys("substitutions", c.Substitutions); err != nil {
+		return err
+	}
+	if c.AnnotationOptions, err = foldKeys("annotation_options", c.AnnotationOptions); err != nil {
+		return err
+	}
+	if c.AnnotationAliases, err = foldKeys("annotation_aliases", c.AnnotationAliases); err != nil {
+		return err
+	}
+	for alias, canonical := range c.AnnotationAliases {
+		c.AnnotationAliases[alias] = strings.ToLower(canonical)
+	}
+	for i := range c.OptionAnnotations {
+		c.OptionAnnotations[i].Annotation = strings.ToLower(c.OptionAnnotations[i].Annotation)
+	}
+	if c.Tiers != nil {
+		for _, level := range c.Tiers.Levels {
+			for i, name := range level.Annotations {
+				level.Annotations[i] = strings.ToLower(name)
+			}
+		}
+	}
+	return nil
+}
+
+func foldRules(rules []string) []string {
+	folded := make([]string, len(rules))
+	for i, rule := range rules {
+		folded[i] = annotation.FoldRule(rule)
+	}
+	return folded
+}
+
+// foldKeys returns m with lowercase keys.
+func foldKeys[V any](section string, m map[string]V) (map[string]V, error) {
+	if m == nil {
+		return nil, nil
+	}
+	folded := make(map[string]V, len(m))
+	spelling := make(map[string]string, len(m))
+	for key, v := range m {
+		lower := strings.ToLower(key)
+		if other, ok := spelling[lower]; ok {
+			first, second := min(key, other), max(key, other)
+			return nil, fmt.Errorf("%s: %q and %q are the same name with case_insensitive_annotations", section, first, second)
+		}
+		spelling[lower] = key
+		folded[lower] = v
+	}
+	return folded, nil
+}
+
+// Map returns the configuration as a generic map keyed like the YAML file,
+// for embedding the effective configuration, command line overrides
+// included, in reports.
+func (c *FilterConfig) Map() (map[string]any, error) {
+	data, err := yaml.Marshal(c)
+	if err != nil {
+		return nil, err
+	}
+	var m map[string]any
+	if err := yaml.Unmarshal(data, &m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// Validate checks the configuration for invalid combinations and
+// malformed annotation rules.
 func (c *FilterConfig) Validate() error {
+	for _, list := range [][]string{c.Annotations.Include, c.Annotations.Exclude} {
+		for _, rule := range list {
+			if _, err := annotation.ParseRule(rule); err != nil {
+				return fmt.Errorf("invalid annotation filter: %w", err)
+			}
+		}
+	}
+	if _, err := c.Syntaxes(); err != nil {
+		return err
+	}
+	if err := c.validateAliases(); err != nil {
+		return err
+	}
+	for name, sub := range c.Substitutions {
+		for kind := range sub {
+			if kind != DefaultKind && !slices.Contains(elementKinds, kind) {
+				return fmt.Errorf("substitutions: %q: unknown element kind %q", name, kind)
+			}
+		}
+	}
+	if _, err := c.SubstitutionTemplates(); err != nil {
+		return err
+	}
+	if c.SubstitutionWrap < 0 {
+		return fmt.Errorf("substitution_wrap must not be negative, got %d", c.SubstitutionWrap)
+	}
+	for name, o := range c.AnnotationOptions {
+		if o.Option == "" {
+			return fmt.Errorf("annotation_options: %q: option is required", name)
+		}
+	}
+	for i, o := range c.OptionAnnotations {
+		if o.Option == "" || o.Annotation == "" {
+			return fmt.Errorf("option_annotations[%d]: option and annotation are required", i)
+		}
+		if annotation.IsPattern(o.Annotation) {
+			return fmt.Errorf("option_annotations[%d]: annotation must be a name, got %q", i, o.Annotation)
+		}
+	}
+	if err := c.Format.validate(); err != nil {
+		return err
+	}
+	if _, err := c.HeaderTemplate(); err != nil {
+		return err
+	}
+	if c.APIVersion != "" {
+		if _, err := annotation.ParseVersion(c.APIVersion); err != nil {
+			return fmt.Errorf("invalid api_version: %w", err)
+		}
+	}
+	if c.Tiers != nil {
+		if err := c.Tiers.validate(); err != nil {
+			return err
+		}
+	}
+	if c.Annotations.Expr != "" {
+		if len(c.Annotations.Include) > 0 || len(c.Annotations.Exclude) > 0 {
+			return fmt.Errorf("annotations.expr cannot be combined with annotations.include or annotations.exclude")
+		}
+		if _, err := annotation.ParseExpr(c.Annotations.Expr); err != nil {
+			return fmt.Errorf("invalid annotation filter: %w", err)
+		}
+	}
 	return nil
 }
 
+func (c *FilterConfig) validateAliases() error {
+	same := func(a, b string) bool {
+		if c.CaseInsensitiveAnnotations {
+			return strings.EqualFold(a, b)
+		}
+		return a == b
+	}
+	for alias, canonical := range c.AnnotationAliases {
+		if canonical == "" || annotation.IsPattern(canonical) {
+			return fmt.Errorf("annotation_aliases: %q must map to an annotation name, got %q", alias, canonical)
+		}
+		for other := range c.AnnotationAliases {
+			if other != alias && same(other, canonical) {
+				return fmt.Errorf("annotation_aliases: %q maps to %q, which is itself an alias", alias, canonical)
+			}
+		}
+	}
+	return nil
+}
+
+// Syntaxes compiles the configured annotation syntaxes in order, or returns
+// annotation.DefaultSyntaxes if none are configured.
+func (c *FilterConfig) Syntaxes() (annotation.Syntaxes, error) {
+	if len(c.AnnotationSyntax) == 0 {
+		return annotation.DefaultSyntaxes, nil
+	}
+	var syntaxes annotation.Syntaxes
+	for i, s := range c.AnnotationSyntax {
+		compiled, err := s.compile()
+		if err != nil {
+			return nil, fmt.Errorf("annotation_syntax[%d]: %w", i, err)
+		}
+		syntaxes = append(syntaxes, compiled...)
+	}
+	return syntaxes, nil
+}
+
+// SubstitutionTemplates returns the substitution texts that are templates,
+// parsed and keyed by their text. A config read by LoadConfig returns the
+// templates parsed when it was loaded.
+func (c *FilterConfig) SubstitutionTemplates() (map[string]*template.Template, error) {
+	if c.templates != nil {
+		return c.templates, nil
+	}
+	names := make([]string, 0, len(c.Substitutions))
+	for name := range c.Substitutions {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	templates := make(map[string]*template.Template)
+	for _, name := range names {
+		for _, text := range c.Substitutions[name] {
+			if _, ok := templates[text]; ok || !annotation.IsTemplate(text) {
+				continue
+			}
+			tmpl, err := annotation.ParseTemplate(text)
+			if err != nil {
+				return nil, fmt.Errorf("substitutions: %q: %w", name, err)
+			}
+			templates[text] = tmpl
+		}
+	}
+	return templates, nil
+}
+
 // IsPassThrough returns true if no filter rules are defined.
 func (c *FilterConfig) IsPassThrough() bool {
-	return len(c.Include) == 0 && len(c.Exclude) == 0 &&
-		len(c.Annotations.Include) == 0 && len(c.Annotations.Exclude) == 0
+	return len(c.Include) == 0 && len(c.Exclude) == 0 && !c.HasAnnotations() &&
+		!c.HasAPIVersion() && !c.HasFeatures() && !c.HasTiers()
 }
 
 // HasAnnotations returns true if annotation-based filtering is configured.
 func (c *FilterConfig) HasAnnotations() bool {
-	return len(c.Annotations.Include) > 0 || len(c.Annotations.Exclude) > 0
+	return len(c.Annotations.Include) > 0 || len(c.Annotations.Exclude) > 0 ||
+		c.HasAnnotationExpr()
 }
 
 // HasAnnotationInclude returns true if annotation include mode is configured.
@@ -93,7 +667,65 @@ func (c *FilterConfig) HasAnnotationExclude() bool {
 	return len(c.Annotations.Exclude) > 0
 }
 
+// HasAnnotationExpr returns true if an annotation expression is configured.
+func (c *FilterConfig) HasAnnotationExpr() bool {
+	return c.Annotations.Expr != ""
+}
+
+// HasAPIVersion returns true if output is restricted to an API version.
+func (c *FilterConfig) HasAPIVersion() bool {
+	return c.APIVersion != ""
+}
+
+// HasFeatures returns true if feature-flag filtering is configured.
+func (c *FilterConfig) HasFeatures() bool {
+	return c.Features != nil
+}
+
+// HasTiers returns true if tier-based filtering is configured.
+func (c *FilterConfig) HasTiers() bool {
+	return c.Tiers != nil
+}
+
+// HasAnnotationOptions returns true if annotations are converted into
+// proto options.
+func (c *FilterConfig) HasAnnotationOptions() bool {
+	return len(c.AnnotationOptions) > 0
+}
+
 // HasSubstitutions returns true if annotation substitutions are configured.
 func (c *FilterConfig) HasSubstitutions() bool {
 	return len(c.Substitutions) > 0
 }
+
+// SubstitutionNames returns the substitution keys, names and patterns
+// alike, sorted.
+func (c *FilterConfig) SubstitutionNames() []string {
+	names := make([]string, 0, len(c.Substitutions))
+	for name := range c.Substitutions {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	return names
+}
+
+// AnnotationFilterNames returns the annotation names and patterns used by
+// the include and exclude rules or the filter expression, sorted and
+// without duplicates. Invalid rules are skipped; Validate reports them.
+func (c *FilterConfig) AnnotationFilterNames() []string {
+	var names []string
+	for _, list := range [][]string{c.Annotations.Include, c.Annotations.Exclude} {
+		for _, s := range list {
+			if rule, err := annotation.ParseRule(s); err == nil {
+				names = append(names, rule.Name)
+			}
+		}
+	}
+	if c.Annotations.Expr != "" {
+		if expr, err := annotation.ParseExpr(c.Annotations.Expr); err == nil {
+			names = append(names, expr.Names()...)
+		}
+	}
+	slices.Sort(names)
+	return slices.Compact(names)
+}
@@ -3,7 +3,11 @@ package config
 import (
 	"os"
 	"path/filepath"
+	"reflect"
+	"strings"
 	"testing"
+
+	"github.com/unitedtraders/proto-filter/internal/annotation"
 )
 
 // T020: Test YAML config loading
@@ -266,11 +270,11 @@ strict_substitutions: true
 	if len(cfg.Substitutions) != 2 {
 		t.Errorf("expected 2 substitutions, got %d", len(cfg.Substitutions))
 	}
-	if cfg.Substitutions["HasAnyRole"] != "Auth" {
-		t.Errorf("HasAnyRole: expected 'Auth', got %q", cfg.Substitutions["HasAnyRole"])
+	if cfg.Substitutions["HasAnyRole"][DefaultKind] != "Auth" {
+		t.Errorf("HasAnyRole: expected 'Auth', got %q", cfg.Substitutions["HasAnyRole"][DefaultKind])
 	}
-	if cfg.Substitutions["Internal"] != "" {
-		t.Errorf("Internal: expected empty string, got %q", cfg.Substitutions["Internal"])
+	if text, ok := cfg.Substitutions["Internal"][DefaultKind]; !ok || text != "" {
+		t.Errorf("Internal: expected empty string, got %q", text)
 	}
 	if !cfg.StrictSubstitutions {
 		t.Error("StrictSubstitutions should be true")
@@ -308,9 +312,658 @@ func TestLoadConfigNoSubstitutions(t *testing.T) {
 // Test IsPassThrough is not affected by substitutions
 func TestIsPassThroughNotAffectedBySubstitutions(t *testing.T) {
 	cfg := FilterConfig{
-		Substitutions: map[string]string{"HasAnyRole": "Auth"},
+		Substitutions: map[string]Substitution{"HasAnyRole": {DefaultKind: "Auth"}},
 	}
 	if !cfg.IsPassThrough() {
 		t.Error("substitution-only config should be pass-through (writes all files)")
 	}
 }
+
+func TestValidateAnnotationRules(t *testing.T) {
+	cfg := &FilterConfig{Annotations: AnnotationConfig{
+		Include: []string{`HasAnyRole contains "PARTNER"`, "Public"},
+		Exclude: []string{"Visibility == internal"},
+	}}
+	if err := cfg.Validate(); err != nil {
+		t.Errorf("expected valid rules, got %v", err)
+	}
+
+	cfg.Annotations.Exclude = []string{"Visibility =="}
+	if err := cfg.Validate(); err == nil {
+		t.Error("expected error for malformed annotation rule")
+	}
+}
+
+func TestLoadConfigAnnotationExpr(t *testing.T) {
+	tmp := t.TempDir()
+	cfgPath := filepath.Join(tmp, "filter.yaml")
+	os.WriteFile(cfgPath, []byte("annotations:\n  expr: \"Public && !Deprecated || Partner\"\n"), 0o644)
+
+	cfg, err := LoadConfig(cfgPath)
+	if err != nil {
+		t.Fatalf("LoadConfig: %v", err)
+	}
+	if !cfg.HasAnnotationExpr() || !cfg.HasAnnotations() || cfg.IsPassThrough() {
+		t.Error("expression config should enable annotation filtering")
+	}
+	if err := cfg.Validate(); err != nil {
+		t.Errorf("Validate: %v", err)
+	}
+}
+
+func TestValidateAnnotationExpr(t *testing.T) {
+	cfg := &FilterConfig{Annotations: AnnotationConfig{Expr: "Public &&"}}
+	if err := cfg.Validate(); err == nil {
+		t.Error("expected parse error for incomplete expression")
+	}
+
+	cfg = &FilterConfig{Annotations: AnnotationConfig{Expr: "Public", Exclude: []string{"Internal"}}}
+	if err := cfg.Validate(); err == nil {
+		t.Error("expected error when expr is combined with lists")
+	}
+}
+
+func TestValidateAPIVersion(t *testing.T) {
+	cfg := &FilterConfig{APIVersion: "2.5"}
+	if err := cfg.Validate(); err != nil {
+		t.Errorf("Validate: %v", err)
+	}
+	if cfg.IsPassThrough() || !cfg.HasAPIVersion() {
+		t.Error("api_version should disable pass-through")
+	}
+
+	cfg.APIVersion = "two"
+	if err := cfg.Validate(); err == nil {
+		t.Error("expected error for invalid api_version")
+	}
+}
+
+func TestLoadConfigFeatures(t *testing.T) {
+	tmp := t.TempDir()
+	cfgPath := filepath.Join(tmp, "filter.yaml")
+	os.WriteFile(cfgPath, []byte("features:\n  enabled:\n    - instant-payouts\n"), 0o644)
+
+	cfg, err := LoadConfig(cfgPath)
+	if err != nil {
+		t.Fatalf("LoadConfig: %v", err)
+	}
+	if !cfg.HasFeatures() || cfg.IsPassThrough() {
+		t.Error("features section should enable feature filtering")
+	}
+	if len(cfg.Features.Enabled) != 1 || cfg.Features.Enabled[0] != "instant-payouts" {
+		t.Errorf("unexpected enabled features: %v", cfg.Features.Enabled)
+	}
+
+	empty := &FilterConfig{}
+	if empty.HasFeatures() {
+		t.Error("config without features section should not filter by feature")
+	}
+}
+
+func TestLoadConfigTiers(t *testing.T) {
+	tmp := t.TempDir()
+	cfgPath := filepath.Join(tmp, "filter.yaml")
+	content := `tiers:
+  levels:
+    - name: public
+      annotations: [Public]
+    - name: partner
+      annotations: [Partner]
+    - name: internal
+      annotations: [Internal, Private]
+  default: internal
+  select: partner
+`
+	os.WriteFile(cfgPath, []byte(content), 0o644)
+
+	cfg, err := LoadConfig(cfgPath)
+	if err != nil {
+		t.Fatalf("LoadConfig: %v", err)
+	}
+	if err := cfg.Validate(); err != nil {
+		t.Fatalf("Validate: %v", err)
+	}
+	if !cfg.HasTiers() || cfg.IsPassThrough() {
+		t.Error("tiers section should enable tier filtering")
+	}
+	if got := cfg.Tiers.Rank("partner"); got != 1 {
+		t.Errorf("Rank(partner) = %d, want 1", got)
+	}
+	if got := cfg.Tiers.Rank("unknown"); got != -1 {
+		t.Errorf("Rank(unknown) = %d, want -1", got)
+	}
+}
+
+func TestValidateTiers(t *testing.T) {
+	levels := []TierLevel{
+		{Name: "public", Annotations: []string{"Public"}},
+		{Name: "internal", Annotations: []string{"Internal"}},
+	}
+	tests := []struct {
+		name  string
+		tiers TierConfig
+	}{
+		{"no levels", TierConfig{Default: "public", Select: "public"}},
+		{"missing default", TierConfig{Levels: levels, Select: "public"}},
+		{"unknown default", TierConfig{Levels: levels, Default: "partner", Select: "public"}},
+		{"missing select", TierConfig{Levels: levels, Default: "public"}},
+		{"unknown select", TierConfig{Levels: levels, Default: "public", Select: "partner"}},
+		{"duplicate tier", TierConfig{Levels: append(levels, TierLevel{Name: "public"}), Default: "public", Select: "public"}},
+		{"annotation in two tiers", TierConfig{Levels: append(levels, TierLevel{Name: "partner", Annotations: []string{"Public"}}), Default: "public", Select: "public"}},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			tiers := tc.tiers
+			cfg := &FilterConfig{Tiers: &tiers}
+			if err := cfg.Validate(); err == nil {
+				t.Error("expected validation error")
+			}
+		})
+	}
+}
+
+func TestLoadConfigAnnotationSyntax(t *testing.T) {
+	tmp := t.TempDir()
+	cfgPath := filepath.Join(tmp, "filter.yaml")
+	content := `annotation_syntax:
+  - prefix: "#"
+  - prefix: ":"
+    suffix: ":"
+  - pattern: '@visibility\s+(?P<name>\w+)'
+  - preset: default
+`
+	os.WriteFile(cfgPath, []byte(content), 0o644)
+
+	cfg, err := LoadConfig(cfgPath)
+	if err != nil {
+		t.Fatalf("LoadConfig: %v", err)
+	}
+	if err := cfg.Validate(); err != nil {
+		t.Fatalf("Validate: %v", err)
+	}
+	syntaxes, err := cfg.Syntaxes()
+	if err != nil {
+		t.Fatalf("Syntaxes: %v", err)
+	}
+	if len(syntaxes) != 5 {
+		t.Errorf("expected 3 configured syntaxes plus 2 default ones, got %d", len(syntaxes))
+	}
+	var names []string
+	for _, m := range syntaxes.FindAll(" #a :b: @visibility c @D [E]") {
+		names = append(names, m.Name)
+	}
+	if strings.Join(names, ",") != "a,b,c,D,E" {
+		t.Errorf("unexpected annotations %v", names)
+	}
+}
+
+func TestSyntaxesDefault(t *testing.T) {
+	cfg := &FilterConfig{}
+	syntaxes, err := cfg.Syntaxes()
+	if err != nil {
+		t.Fatalf("Syntaxes: %v", err)
+	}
+	if len(syntaxes) != len(annotation.DefaultSyntaxes) {
+		t.Errorf("expected default syntaxes, got %d", len(syntaxes))
+	}
+}
+
+func TestValidateAnnotationSyntax(t *testing.T) {
+	tests := []struct {
+		name   string
+		syntax SyntaxConfig
+	}{
+		{"empty", SyntaxConfig{}},
+		{"prefix and pattern", SyntaxConfig{Prefix: "#", Pattern: `#(?P<name>\w+)`}},
+		{"unknown preset", SyntaxConfig{Preset: "jsdoc"}},
+		{"pattern without name group", SyntaxConfig{Pattern: `#(\w+)`}},
+		{"invalid pattern", SyntaxConfig{Pattern: `#(?P<name>\w+`}},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			cfg := &FilterConfig{AnnotationSyntax: []SyntaxConfig{tc.syntax}}
+			err := cfg.Validate()
+			if err == nil {
+				t.Fatal("expected validation error")
+			}
+			if !strings.Contains(err.Error(), "annotation_syntax[0]") {
+				t.Errorf("error should name the syntax entry, got: %v", err)
+			}
+		})
+	}
+}
+
+func TestLoadConfigAnnotationAliases(t *testing.T) {
+	tmp := t.TempDir()
+	cfgPath := filepath.Join(tmp, "filter.yaml")
+	content := `case_insensitive_annotations: true
+annotation_aliases:
+  Private: Internal
+  "Hidden*": Internal
+`
+	os.WriteFile(cfgPath, []byte(content), 0o644)
+
+	cfg, err := LoadConfig(cfgPath)
+	if err != nil {
+		t.Fatalf("LoadConfig: %v", err)
+	}
+	if err := cfg.Validate(); err != nil {
+		t.Fatalf("Validate: %v", err)
+	}
+	if !cfg.CaseInsensitiveAnnotations {
+		t.Error("expected case-insensitive mode")
+	}
+	if cfg.AnnotationAliases["hidden*"] != "internal" {
+		t.Errorf("aliases should be folded to lower case, got %v", cfg.AnnotationAliases)
+	}
+}
+
+func TestLoadConfigFoldsNames(t *testing.T) {
+	tmp := t.TempDir()
+	cfgPath := filepath.Join(tmp, "filter.yaml")
+	content := `case_insensitive_annotations: true
+annotations:
+  exclude:
+    - Internal
+    - 'HasAnyRole contains "ADMIN"'
+substitutions:
+  PII: "Contains personal data."
+option_annotations:
+  - option: deprecated
+    annotation: Deprecated
+`
+	os.WriteFile(cfgPath, []byte(content), 0o644)
+
+	cfg, err := LoadConfig(cfgPath)
+	if err != nil {
+		t.Fatalf("LoadConfig: %v", err)
+	}
+	if got := cfg.Annotations.Exclude; !reflect.DeepEqual(got, []string{"internal", `hasanyrole contains "ADMIN"`}) {
+		t.Errorf("exclude = %q", got)
+	}
+	if _, ok := cfg.Substitutions["pii"]; !ok {
+		t.Errorf("substitution keys should be folded, got %v", cfg.Substitutions)
+	}
+	if got := cfg.OptionAnnotations[0].Annotation; got != "deprecated" {
+		t.Errorf("option annotation = %q", got)
+	}
+}
+
+func TestLoadConfigRejectsNamesDifferingInCase(t *testing.T) {
+	tmp := t.TempDir()
+	cfgPath := filepath.Join(tmp, "filter.yaml")
+	content := `case_insensitive_annotations: true
+substitutions:
+  Internal: "Internal use only."
+  internal: "Not for partners."
+`
+	os.WriteFile(cfgPath, []byte(content), 0o644)
+
+	_, err := LoadConfig(cfgPath)
+	if err == nil || !strings.Contains(err.Error(), `substitutions: "Internal" and "internal"`) {
+		t.Errorf("expected an error for keys differing in case, got %v", err)
+	}
+}
+
+func TestValidateTiersIgnoringCase(t *testing.T) {
+	tmp := t.TempDir()
+	cfgPath := filepath.Join(tmp, "filter.yaml")
+	content := `case_insensitive_annotations: true
+tiers:
+  levels:
+    - name: public
+      annotations: [Public]
+    - name: partner
+      annotations: [Partner]
+    - name: internal
+      annotations: [partner]
+  default: internal
+  select: public
+`
+	os.WriteFile(cfgPath, []byte(content), 0o644)
+
+	cfg, err := LoadConfig(cfgPath)
+	if err != nil {
+		t.Fatalf("LoadConfig: %v", err)
+	}
+	err = cfg.Validate()
+	if err == nil || !strings.Contains(err.Error(), `annotation "partner" is mapped to both "partner" and "internal"`) {
+		t.Errorf("expected Partner and partner to be the same annotation, got %v", err)
+	}
+}
+
+func TestValidateAnnotationAliases(t *testing.T) {
+	tests := []struct {
+		name            string
+		aliases         map[string]string
+		caseInsensitive bool
+		wantErr         bool
+	}{
+		{"simple", map[string]string{"Private": "Internal"}, false, false},
+		{"empty target", map[string]string{"Private": ""}, false, true},
+		{"pattern target", map[string]string{"Private": "Internal*"}, false, true},
+		{"chain", map[string]string{"Private": "Hidden", "Hidden": "Internal"}, false, true},
+		{"chain ignoring case", map[string]string{"Private": "hidden", "Hidden": "Internal"}, true, true},
+		{"case variants", map[string]string{"Private": "hidden", "Hidden": "Internal"}, false, false},
+		{"self by case", map[string]string{"INTERNAL": "Internal"}, true, false},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			cfg := &FilterConfig{AnnotationAliases: tc.aliases, CaseInsensitiveAnnotations: tc.caseInsensitive}
+			err := cfg.Validate()
+			if (err != nil) != tc.wantErr {
+				t.Errorf("Validate() error = %v, wantErr %v", err, tc.wantErr)
+			}
+		})
+	}
+}
+
+func TestLoadConfigOptionAnnotations(t *testing.T) {
+	tmp := t.TempDir()
+	cfgPath := filepath.Join(tmp, "filter.yaml")
+	content := `option_annotations:
+  - option: "(myapp.visibility)"
+    value: INTERNAL
+    annotation: Internal
+  - option: deprecated
+    value: "true"
+    annotation: Deprecated
+annotations:
+  exclude: [Internal]
+`
+	os.WriteFile(cfgPath, []byte(content), 0o644)
+
+	cfg, err := LoadConfig(cfgPath)
+	if err != nil {
+		t.Fatalf("LoadConfig: %v", err)
+	}
+	if err := cfg.Validate(); err != nil {
+		t.Fatalf("Validate: %v", err)
+	}
+	want := OptionAnnotation{Option: "(myapp.visibility)", Value: "INTERNAL", Annotation: "Internal"}
+	if len(cfg.OptionAnnotations) != 2 || cfg.OptionAnnotations[0] != want {
+		t.Errorf("unexpected option annotations %+v", cfg.OptionAnnotations)
+	}
+
+	cfg.OptionAnnotations = append(cfg.OptionAnnotations, OptionAnnotation{Option: "deprecated"})
+	if err := cfg.Validate(); err == nil || !strings.Contains(err.Error(), "option_annotations[2]") {
+		t.Errorf("expected error for mapping without annotation, got %v", err)
+	}
+}
+
+func TestLoadConfigAnnotationOptions(t *testing.T) {
+	tmp := t.TempDir()
+	cfgPath := filepath.Join(tmp, "filter.yaml")
+	content := `annotation_options:
+  Deprecated:
+    option: deprecated
+  HasAnyRole:
+    option: (myapp.auth).roles
+    value: '"%s"'
+`
+	os.WriteFile(cfgPath, []byte(content), 0o644)
+
+	cfg, err := LoadConfig(cfgPath)
+	if err != nil {
+		t.Fatalf("LoadConfig: %v", err)
+	}
+	if err := cfg.Validate(); err != nil {
+		t.Fatalf("Validate: %v", err)
+	}
+	if !cfg.HasAnnotationOptions() {
+		t.Error("expected annotation options")
+	}
+	if got := cfg.AnnotationOptions["HasAnyRole"]; got.Option != "(myapp.auth).roles" || got.Value != `"%s"` {
+		t.Errorf("unexpected conversion %+v", got)
+	}
+
+	cfg.AnnotationOptions["Internal"] = OptionConversion{Value: "INTERNAL"}
+	if err := cfg.Validate(); err == nil {
+		t.Error("expected error for conversion without option name")
+	}
+}
+
+func TestValidateSubstitutionTemplates(t *testing.T) {
+	cfg := &FilterConfig{Substitutions: map[string]Substitution{
+		"HasAnyRole": {DefaultKind: `Requires one of: {{.Args | join ", "}}`},
+		"Internal":   {DefaultKind: "Internal use only", "field": "Reserved for {{.Kind}}s"},
+	}}
+	if err := cfg.Validate(); err != nil {
+		t.Fatalf("Validate: %v", err)
+	}
+
+	cfg.Substitutions["Broken"] = Substitution{"service": "{{.Args | join"}
+	err := cfg.Validate()
+	if err == nil || !strings.Contains(err.Error(), `"Broken"`) {
+		t.Errorf("expected template error naming the key, got %v", err)
+	}
+}
+
+func TestLoadConfigParsesTemplates(t *testing.T) {
+	tmp := t.TempDir()
+	cfgPath := filepath.Join(tmp, "filter.yaml")
+	os.WriteFile(cfgPath, []byte("substitutions:\n  Since: 'Since {{index .Args 0}}.'\n  Internal: Internal use only.\n"), 0o644)
+
+	cfg, err := LoadConfig(cfgPath)
+	if err != nil {
+		t.Fatalf("LoadConfig: %v", err)
+	}
+	templates, err := cfg.SubstitutionTemplates()
+	if err != nil {
+		t.Fatalf("SubstitutionTemplates: %v", err)
+	}
+	if len(templates) != 1 || templates["Since {{index .Args 0}}."] == nil {
+		t.Errorf("expected only the Since template, got %v", templates)
+	}
+
+	os.WriteFile(cfgPath, []byte("substitutions:\n  Broken: '{{.Args | join'\n"), 0o644)
+	if _, err := LoadConfig(cfgPath); err == nil || !strings.Contains(err.Error(), `substitutions: "Broken"`) {
+		t.Errorf("expected LoadConfig to report the template error, got %v", err)
+	}
+}
+
+func TestLoadConfigKindSubstitutions(t *testing.T) {
+	tmp := t.TempDir()
+	cfgPath := filepath.Join(tmp, "filter.yaml")
+	content := `substitutions:
+  Internal:
+    service: "Internal service - not available externally"
+    field: "Reserved for internal use"
+    default: "For internal use only"
+  Beta:
+    rpc: "Beta method"
+  HasAnyRole: "Requires authentication"
+`
+	os.WriteFile(cfgPath, []byte(content), 0o644)
+
+	cfg, err := LoadConfig(cfgPath)
+	if err != nil {
+		t.Fatalf("LoadConfig: %v", err)
+	}
+	if err := cfg.Validate(); err != nil {
+		t.Fatalf("Validate: %v", err)
+	}
+	tests := []struct {
+		name, kind string
+		want       string
+		wantOK     bool
+	}{
+		{"Internal", "service", "Internal service - not available externally", true},
+		{"Internal", "field", "Reserved for internal use", true},
+		{"Internal", "enum_value", "For internal use only", true},
+		{"Beta", "rpc", "Beta method", true},
+		{"Beta", "message", "", false},
+		{"HasAnyRole", "rpc", "Requires authentication", true},
+	}
+	for _, tc := range tests {
+		got, ok := cfg.Substitutions[tc.name].Text(tc.kind)
+		if got != tc.want || ok != tc.wantOK {
+			t.Errorf("%s on %s: got %q, %v; want %q, %v", tc.name, tc.kind, got, ok, tc.want, tc.wantOK)
+		}
+	}
+
+	cfg.Substitutions["Internal"]["method"] = "typo"
+	if err := cfg.Validate(); err == nil || !strings.Contains(err.Error(), `unknown element kind "method"`) {
+		t.Errorf("expected unknown kind error, got %v", err)
+	}
+}
+
+func TestLoadConfigSubstitutionWrap(t *testing.T) {
+	tmp := t.TempDir()
+	cfgPath := filepath.Join(tmp, "filter.yaml")
+	os.WriteFile(cfgPath, []byte(`substitutions:
+  Audit: |
+    Audited operation.
+
+    - caller
+substitution_wrap: 72
+`), 0o644)
+
+	cfg, err := LoadConfig(cfgPath)
+	if err != nil {
+		t.Fatalf("LoadConfig: %v", err)
+	}
+	if cfg.SubstitutionWrap != 72 {
+		t.Errorf("SubstitutionWrap = %d, want 72", cfg.SubstitutionWrap)
+	}
+	if got, _ := cfg.Substitutions["Audit"].Text("rpc"); got != "Audited operation.\n\n- caller\n" {
+		t.Errorf("Audit text = %q", got)
+	}
+	if err := cfg.Validate(); err != nil {
+		t.Fatalf("Validate: %v", err)
+	}
+
+	cfg.SubstitutionWrap = -1
+	if err := cfg.Validate(); err == nil || !strings.Contains(err.Error(), "substitution_wrap") {
+		t.Errorf("expected substitution_wrap error, got %v", err)
+	}
+}
+
+func TestLoadConfigFormat(t *testing.T) {
+	tmp := t.TempDir()
+	cfgPath := filepath.Join(tmp, "filter.yaml")
+	os.WriteFile(cfgPath, []byte(`format:
+  indent_width: 4
+  indent_char: tab
+  align_fields: false
+  blank_lines: 0
+  convert_block_comments: false
+  comment_width: 80
+  import_order: sorted
+`), 0o644)
+
+	cfg, err := LoadConfig(cfgPath)
+	if err != nil {
+		t.Fatalf("LoadConfig: %v", err)
+	}
+	if err := cfg.Validate(); err != nil {
+		t.Fatalf("Validate: %v", err)
+	}
+	f := cfg.Format
+	if f.Indent() != "\t\t\t\t" {
+		t.Errorf("Indent() = %q, want four tabs", f.Indent())
+	}
+	if f.Aligned() || f.BlankLineCount() != 0 || f.ConvertsBlockComments() {
+		t.Errorf("Aligned, BlankLineCount, ConvertsBlockComments = %v, %d, %v", f.Aligned(), f.BlankLineCount(), f.ConvertsBlockComments())
+	}
+	if f.CommentWidth != 80 || f.ImportOrder != ImportOrderSorted {
+		t.Errorf("CommentWidth, ImportOrder = %d, %q", f.CommentWidth, f.ImportOrder)
+	}
+}
+
+func TestFormatConfigDefaults(t *testing.T) {
+	var f FormatConfig
+	if f.Indent() != "  " {
+		t.Errorf("Indent() = %q, want two spaces", f.Indent())
+	}
+	if !f.Aligned() || f.BlankLineCount() != 1 || !f.ConvertsBlockComments() {
+		t.Errorf("Aligned, BlankLineCount, ConvertsBlockComments = %v, %d, %v", f.Aligned(), f.BlankLineCount(), f.ConvertsBlockComments())
+	}
+	if got := (FormatConfig{IndentChar: IndentTab}).Indent(); got != "\t" {
+		t.Errorf("tab Indent() = %q, want one tab", got)
+	}
+}
+
+func TestValidateFormat(t *testing.T) {
+	negative := -1
+	tests := []struct {
+		name   string
+		format FormatConfig
+		want   string
+	}{
+		{"indent width", FormatConfig{IndentWidth: -2}, "format.indent_width"},
+		{"indent char", FormatConfig{IndentChar: "nbsp"}, "format.indent_char"},
+		{"blank lines", FormatConfig{BlankLines: &negative}, "format.blank_lines"},
+		{"comment width", FormatConfig{CommentWidth: -1}, "format.comment_width"},
+		{"import order", FormatConfig{ImportOrder: "reverse"}, "format.import_order"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := &FilterConfig{Format: tt.format}
+			if err := cfg.Validate(); err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("expected %s error, got %v", tt.want, err)
+			}
+		})
+	}
+}
+
+func TestHeaderTemplate(t *testing.T) {
+	cfg := &FilterConfig{Header: "Generated from {{.Source}} by {{.Profile}}."}
+	tmpl, err := cfg.HeaderTemplate()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	var b strings.Builder
+	if err := tmpl.Execute(&b, HeaderData{Source: "a/b.proto", Profile: "public"}); err != nil {
+		t.Fatalf("executing: %v", err)
+	}
+	if got, want := b.String(), "Generated from a/b.proto by public."; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	if tmpl, err := (&FilterConfig{}).HeaderTemplate(); tmpl != nil || err != nil {
+		t.Errorf("expected no template without a header, got %v, %v", tmpl, err)
+	}
+}
+
+func TestValidateHeader(t *testing.T) {
+	for _, header := range []string{"{{.Source", "{{.Branch}}"} {
+		cfg := &FilterConfig{Header: header}
+		if err := cfg.Validate(); err == nil || !strings.HasPrefix(err.Error(), "header:") {
+			t.Errorf("%q: expected header error, got %v", header, err)
+		}
+	}
+}
+
+func TestProfileName(t *testing.T) {
+	if got := (&FilterConfig{}).ProfileName("configs/public.yaml"); got != "public" {
+		t.Errorf("ProfileName() = %q, want %q", got, "public")
+	}
+	if got := (&FilterConfig{Profile: "partner"}).ProfileName("configs/public.yaml"); got != "partner" {
+		t.Errorf("ProfileName() = %q, want %q", got, "partner")
+	}
+}
+
+func TestReferencedAnnotationNames(t *testing.T) {
+	cfg := &FilterConfig{
+		Annotations: AnnotationConfig{
+			Include: []string{"Public", `HasAnyRole contains "ADMIN"`},
+			Exclude: []string{"Internal", "auth.*", "Public"},
+		},
+		Substitutions: map[string]Substitution{
+			"Since":      {DefaultKind: "Since %s"},
+			"HasAnyRole": {DefaultKind: ""},
+		},
+	}
+	if got, want := cfg.AnnotationFilterNames(), []string{"HasAnyRole", "Internal", "Public", "auth.*"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("AnnotationFilterNames() = %v, want %v", got, want)
+	}
+	if got, want := cfg.SubstitutionNames(), []string{"HasAnyRole", "Since"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("SubstitutionNames() = %v, want %v", got, want)
+	}
+
+	cfg.Annotations = AnnotationConfig{Expr: "Public && !(Beta || Internal)"}
+	if got, want := cfg.AnnotationFilterNames(), []string{"Beta", "Internal", "Public"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("AnnotationFilterNames() with expr = %v, want %v", got, want)
+	}
+}
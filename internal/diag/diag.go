@@ -0,0 +1,178 @@
+// Package diag reports errors and warnings, either as text on stderr or
+// collected and written as JSON or SARIF for CI systems and code scanning.
+package diag
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// Severity is the severity of a diagnostic.
+type Severity string
+
+const (
+	SeverityError   Severity = "error"
+	SeverityWarning Severity = "warning"
+)
+
+// Rule IDs identify the kind of problem a diagnostic reports.
+const (
+	RuleUsage                   = "usage"
+	RuleConfig                  = "config"
+	RuleIO                      = "io"
+	RuleParse                   = "parse"
+	RuleFilter                  = "filter"
+	RuleNoInput                 = "no-input"
+	RuleUnsubstitutedAnnotation = "unsubstituted-annotation"
+	RuleUnusedSubstitution      = "unused-substitution"
+	RuleUnusedAnnotationFilter  = "unused-annotation-filter"
+	RuleUnmatchedPattern        = "unmatched-pattern"
+	RuleSubstitutionTemplate    = "substitution-template"
+	RuleModifiedOutput          = "modified-output"
+	RuleOutdatedOutput          = "outdated-output"
+)
+
+// Rules describes every rule ID, in the order they are listed in SARIF
+// output.
+var Rules = []struct{ ID, Description string }{
+	{RuleUsage, "Invalid command line arguments or input directory"},
+	{RuleConfig, "Invalid filter configuration"},
+	{RuleIO, "Reading or writing files failed"},
+	{RuleParse, "Proto file could not be parsed"},
+	{RuleFilter, "Filter rules could not be applied"},
+	{RuleNoInput, "No .proto files found in the input directory"},
+	{RuleUnsubstitutedAnnotation, "Annotation has no substitution (strict_substitutions)"},
+	{RuleUnusedSubstitution, "Substitution key matches no annotation in the input"},
+	{RuleUnusedAnnotationFilter, "Annotation filter name matches no annotation in the input"},
+	{RuleUnmatchedPattern, "Include or exclude pattern matches no definition in the input"},
+	{RuleSubstitutionTemplate, "Substitution template failed to render for an annotation"},
+	{RuleModifiedOutput, "Stale output file was modified after it was written and is not removed"},
+	{RuleOutdatedOutput, "Output directory differs from the output of this run (--check)"},
+}
+
+// Diagnostic is a single error or warning. File, Line and Column are
+// optional; Line and Column are 1-based. Source is the source line the
+// diagnostic refers to, if known.
+type Diagnostic struct {
+	Rule     string   `json:"rule"`
+	Severity Severity `json:"severity"`
+	Message  string   `json:"message"`
+	File     string   `json:"file,omitempty"`
+	Line     int      `json:"line,omitempty"`
+	Column   int      `json:"column,omitempty"`
+	Source   string   `json:"source,omitempty"`
+}
+
+// Errorf returns an error diagnostic for rule.
+func Errorf(rule, format string, args ...any) Diagnostic {
+	return Diagnostic{Rule: rule, Severity: SeverityError, Message: fmt.Sprintf(format, args...)}
+}
+
+// Warningf returns a warning diagnostic for rule.
+func Warningf(rule, format string, args ...any) Diagnostic {
+	return Diagnostic{Rule: rule, Severity: SeverityWarning, Message: fmt.Sprintf(format, args...)}
+}
+
+// At returns d located at the given file, line and column.
+func (d Diagnostic) At(file string, line, column int) Diagnostic {
+	d.File, d.Line, d.Column = file, line, column
+	return d
+}
+
+// WithSource returns d with the source line it refers to.
+func (d Diagnostic) WithSource(line string) Diagnostic {
+	d.Source = line
+	return d
+}
+
+// Snippet returns the source line followed by a line with a caret under
+// the column, both indented by two spaces, or "" without a source line.
+// Tabs before the column are kept so the caret lines up.
+func (d Diagnostic) Snippet() string {
+	if d.Source == "" {
+		return ""
+	}
+	snippet := "  " + d.Source + "\n"
+	if d.Column < 1 {
+		return snippet
+	}
+	var caret strings.Builder
+	for i, c := range d.Source {
+		if i >= d.Column-1 {
+			break
+		}
+		if c == '\t' {
+			caret.WriteRune('\t')
+		} else {
+			caret.WriteRune(' ')
+		}
+	}
+	return snippet + "  " + caret.String() + "^\n"
+}
+
+// Reporter prints or collects diagnostics.
+type Reporter struct {
+	format string
+	text   io.Writer // destination of text diagnostics
+	out    io.Writer // destination of JSON and SARIF output
+	diags  []Diagnostic
+}
+
+// NewReporter returns a reporter for the given format. Text diagnostics
+// are written to text as they are reported, as `proto-filter: error: ...`
+// lines; JSON and SARIF are written to out by Flush.
+func NewReporter(format string, text, out io.Writer) (*Reporter, error) {
+	switch format {
+	case "text", "json", "sarif":
+	default:
+		return nil, fmt.Errorf("unknown diagnostics format %q, want text, json or sarif", format)
+	}
+	return &Reporter{format: format, text: text, out: out}, nil
+}
+
+// Structured reports whether diagnostics are collected as JSON or SARIF
+// rather than printed as text.
+func (r *Reporter) Structured() bool {
+	return r.format != "text"
+}
+
+// Report prints or collects a diagnostic.
+func (r *Reporter) Report(d Diagnostic) {
+	if !r.Structured() {
+		fmt.Fprintf(r.text, "proto-filter: %s: %s\n%s", d.Severity, d.Message, d.Snippet())
+		return
+	}
+	r.diags = append(r.diags, d)
+}
+
+// Diagnostics returns the collected diagnostics.
+func (r *Reporter) Diagnostics() []Diagnostic {
+	return r.diags
+}
+
+// Flush writes the collected diagnostics in JSON or SARIF format. It does
+// nothing for text, and writes an empty list when there were no problems
+// so consumers can always parse the output.
+func (r *Reporter) Flush() error {
+	var v any
+	switch r.format {
+	case "json":
+		diags := r.diags
+		if diags == nil {
+			diags = []Diagnostic{}
+		}
+		v = struct {
+			Diagnostics []Diagnostic `json:"diagnostics"`
+		}{diags}
+	case "sarif":
+		v = newSARIFLog(r.diags)
+	default:
+		return nil
+	}
+	enc := json.NewEncoder(r.out)
+	enc.SetEscapeHTML(false)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
@@ -0,0 +1,126 @@
+package diag
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestReporterText(t *testing.T) {
+	var text, out strings.Builder
+	r, err := NewReporter("text", &text, &out)
+	if err != nil {
+		t.Fatalf("NewReporter: %v", err)
+	}
+	r.Report(Errorf(RuleParse, "parsing %s: bad", "a.proto").At("protos/a.proto", 4, 15))
+	r.Report(Warningf(RuleNoInput, "no .proto files found in input directory"))
+	if err := r.Flush(); err != nil {
+		t.Fatalf("Flush: %v", err)
+	}
+	want := "proto-filter: error: parsing a.proto: bad\nproto-filter: warning: no .proto files found in input directory\n"
+	if text.String() != want {
+		t.Errorf("text output:\n got %q\nwant %q", text.String(), want)
+	}
+	if out.Len() != 0 || r.Structured() {
+		t.Errorf("text reporter should not write structured output, got %q", out.String())
+	}
+}
+
+func TestReporterTextSnippet(t *testing.T) {
+	var text strings.Builder
+	r, _ := NewReporter("text", &text, nil)
+	r.Report(Errorf(RuleParse, "parsing a.proto:3:14: bad").At("protos/a.proto", 3, 14).WithSource("\tstring id = ;"))
+	want := "proto-filter: error: parsing a.proto:3:14: bad\n  \tstring id = ;\n  \t            ^\n"
+	if text.String() != want {
+		t.Errorf("text output:\n got %q\nwant %q", text.String(), want)
+	}
+}
+
+func TestDiagnosticSnippet(t *testing.T) {
+	tests := []struct {
+		name string
+		d    Diagnostic
+		want string
+	}{
+		{"no source", Errorf(RuleParse, "bad").At("a.proto", 3, 5), ""},
+		{"caret", Errorf(RuleParse, "bad").At("a.proto", 1, 5).WithSource("abc def"), "  abc def\n      ^\n"},
+		{"first column", Errorf(RuleParse, "bad").At("a.proto", 1, 1).WithSource("x"), "  x\n  ^\n"},
+		{"no column", Errorf(RuleParse, "bad").At("a.proto", 1, 0).WithSource("x"), "  x\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.d.Snippet(); got != tt.want {
+				t.Errorf("Snippet() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestReporterJSON(t *testing.T) {
+	var text, out strings.Builder
+	r, _ := NewReporter("json", &text, &out)
+	if err := r.Flush(); err != nil {
+		t.Fatalf("Flush: %v", err)
+	}
+	if got := out.String(); got != "{\n  \"diagnostics\": []\n}\n" {
+		t.Errorf("empty JSON output = %q", got)
+	}
+
+	out.Reset()
+	r.Report(Errorf(RuleUnsubstitutedAnnotation, "unsubstituted annotation @Internal").At("protos/a.proto", 7, 6))
+	r.Flush()
+	var got struct{ Diagnostics []Diagnostic }
+	if err := json.Unmarshal([]byte(out.String()), &got); err != nil {
+		t.Fatalf("invalid JSON: %v\n%s", err, out.String())
+	}
+	want := Diagnostic{Rule: RuleUnsubstitutedAnnotation, Severity: SeverityError, Message: "unsubstituted annotation @Internal", File: "protos/a.proto", Line: 7, Column: 6}
+	if len(got.Diagnostics) != 1 || got.Diagnostics[0] != want {
+		t.Errorf("diagnostics = %+v, want %+v", got.Diagnostics, want)
+	}
+	if text.Len() != 0 {
+		t.Errorf("structured reporter should not print text, got %q", text.String())
+	}
+}
+
+func TestReporterSARIF(t *testing.T) {
+	var out strings.Builder
+	r, _ := NewReporter("sarif", &strings.Builder{}, &out)
+	r.Report(Warningf(RuleUnusedSubstitution, "unused substitution key: Legacy").At("filter.yaml", 0, 0))
+	r.Report(Errorf(RuleParse, "parsing a.proto: bad").At("protos/a.proto", 4, 15))
+	r.Flush()
+
+	var log sarifLog
+	if err := json.Unmarshal([]byte(out.String()), &log); err != nil {
+		t.Fatalf("invalid SARIF: %v", err)
+	}
+	if log.Version != "2.1.0" || len(log.Runs) != 1 {
+		t.Fatalf("unexpected log: %+v", log)
+	}
+	run := log.Runs[0]
+	if len(run.Tool.Driver.Rules) != len(Rules) {
+		t.Errorf("expected %d rules, got %d", len(Rules), len(run.Tool.Driver.Rules))
+	}
+	if len(run.Results) != 2 {
+		t.Fatalf("expected 2 results, got %d", len(run.Results))
+	}
+	unused, parse := run.Results[0], run.Results[1]
+	if unused.Level != "warning" || unused.Locations[0].PhysicalLocation.Region != nil {
+		t.Errorf("unexpected warning result: %+v", unused)
+	}
+	if run.Tool.Driver.Rules[parse.RuleIndex].ID != RuleParse {
+		t.Errorf("ruleIndex %d does not point at %s", parse.RuleIndex, RuleParse)
+	}
+	loc := parse.Locations[0].PhysicalLocation
+	if loc.ArtifactLocation.URI != "protos/a.proto" {
+		t.Errorf("uri = %q", loc.ArtifactLocation.URI)
+	}
+	if loc.Region == nil || loc.Region.StartLine != 4 || loc.Region.StartColumn != 15 {
+		t.Errorf("region = %+v", loc.Region)
+	}
+}
+
+func TestNewReporterUnknownFormat(t *testing.T) {
+	if _, err := NewReporter("xml", nil, nil); err == nil || !strings.Contains(err.Error(), "xml") {
+		t.Errorf("expected unknown format error, got %v", err)
+	}
+}
@@ -0,0 +1,182 @@
+// Package diff produces line-based unified diffs.
+package diff
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Context is the number of unchanged lines shown around each change.
+const Context = 3
+
+type opKind int
+
+const (
+	opEqual opKind = iota
+	opDelete
+	opInsert
+)
+
+// op is one line of an edit script: a line of a kept or deleted, or a
+// line of b inserted.
+type op struct {
+	kind opKind
+	a, b int // line indexes in a and b before this op
+}
+
+// Unified returns the unified diff turning a into b, with from and to as
+// the file names in the header. It returns "" if a and b are equal.
+func Unified(from, to string, a, b []byte) string {
+	if string(a) == string(b) {
+		return ""
+	}
+	al, bl := splitLines(string(a)), splitLines(string(b))
+	ops := editScript(al, bl)
+
+	var out strings.Builder
+	fmt.Fprintf(&out, "--- %s\n+++ %s\n", from, to)
+	for _, h := range hunks(ops) {
+		first, last := ops[h[0]], ops[h[1]-1]
+		aLen, bLen := last.a-first.a, last.b-first.b
+		if last.kind != opInsert {
+			aLen++
+		}
+		if last.kind != opDelete {
+			bLen++
+		}
+		fmt.Fprintf(&out, "@@ -%s +%s @@\n", hunkRange(first.a, aLen), hunkRange(first.b, bLen))
+		for _, o := range ops[h[0]:h[1]] {
+			switch o.kind {
+			case opEqual:
+				writeLine(&out, ' ', al[o.a])
+			case opDelete:
+				writeLine(&out, '-', al[o.a])
+			case opInsert:
+				writeLine(&out, '+', bl[o.b])
+			}
+		}
+	}
+	return out.String()
+}
+
+// splitLines splits s after each newline. The last line has no newline if
+// s does not end with one.
+func splitLines(s string) []string {
+	lines := strings.SplitAfter(s, "\n")
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	return lines
+}
+
+func writeLine(out *strings.Builder, prefix byte, line string) {
+	out.WriteByte(prefix)
+	out.WriteString(line)
+	if !strings.HasSuffix(line, "\n") {
+		out.WriteString("\n\\ No newline at end of file\n")
+	}
+}
+
+// hunkRange formats the start and length of a hunk, with start a 0-based
+// line index. An empty range is given by the line before it.
+func hunkRange(start, n int) string {
+	switch n {
+	case 0:
+		return fmt.Sprintf("%d,0", start)
+	case 1:
+		return fmt.Sprintf("%d", start+1)
+	}
+	return fmt.Sprintf("%d,%d", start+1, n)
+}
+
+// hunks returns the [start, end) op ranges of the hunks: each run of
+// changes with Context equal lines around it. Changes separated by at
+// most 2*Context equal lines share a hunk.
+func hunks(ops []op) [][2]int {
+	var result [][2]int
+	for i := 0; i < len(ops); i++ {
+		if ops[i].kind == opEqual {
+			continue
+		}
+		start := max(i-Context, 0)
+		end := i + 1
+		for j := end; j < len(ops) && j <= end+2*Context; j++ {
+			if ops[j].kind != opEqual {
+				end = j + 1
+			}
+		}
+		end = min(end+Context, len(ops))
+		result = append(result, [2]int{start, end})
+		i = end - 1
+	}
+	return result
+}
+
+// editScript returns a shortest edit script turning a into b, using
+// Myers' O(ND) algorithm.
+func editScript(a, b []string) []op {
+	n, m := len(a), len(b)
+	limit := n + m
+	v := make([]int, 2*limit+2)
+	// trace[d] holds v[-d:d+2] as it was before step d, relative to k = -d.
+	var trace [][]int
+	for d := 0; d <= limit; d++ {
+		trace = append(trace, append([]int(nil), v[limit-d:limit+d+2]...))
+		done := false
+		for k := -d; k <= d; k += 2 {
+			var x int
+			if k == -d || (k != d && v[limit+k-1] < v[limit+k+1]) {
+				x = v[limit+k+1]
+			} else {
+				x = v[limit+k-1] + 1
+			}
+			y := x - k
+			for x < n && y < m && a[x] == b[y] {
+				x++
+				y++
+			}
+			v[limit+k] = x
+			if x >= n && y >= m {
+				done = true
+				break
+			}
+		}
+		if done {
+			break
+		}
+	}
+
+	// Walk back through the trace from the end of both inputs.
+	var ops []op
+	x, y := n, m
+	for d := len(trace) - 1; d >= 0; d-- {
+		v := trace[d]
+		k := x - y
+		var prevK int
+		if k == -d || (k != d && v[k-1+d] < v[k+1+d]) {
+			prevK = k + 1
+		} else {
+			prevK = k - 1
+		}
+		prevX := v[prevK+d]
+		prevY := prevX - prevK
+		for x > prevX && y > prevY {
+			x--
+			y--
+			ops = append(ops, op{opEqual, x, y})
+		}
+		if d > 0 {
+			if x == prevX {
+				y--
+				ops = append(ops, op{opInsert, x, y})
+			} else {
+				x--
+				ops = append(ops, op{opDelete, x, y})
+			}
+		}
+	}
+	for i, j := 0, len(ops)-1; i < j; i, j = i+1, j-1 {
+		ops[i], ops[j] = ops[j], ops[i]
+	}
+	return ops
+}
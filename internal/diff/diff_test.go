@@ -0,0 +1,106 @@
+package diff
+
+import (
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// lines returns the numbers from and to on lines of their own, with the
+// lines in replace replaced and those in drop left out.
+func lines(from, to int, replace map[int]string, drop ...int) string {
+	var b strings.Builder
+	for i := from; i <= to; i++ {
+		switch s, ok := replace[i]; {
+		case slices.Contains(drop, i):
+		case ok:
+			b.WriteString(s + "\n")
+		default:
+			b.WriteString(strconv.Itoa(i) + "\n")
+		}
+	}
+	return b.String()
+}
+
+func TestUnifiedEqual(t *testing.T) {
+	if got := Unified("a", "b", []byte("x\n"), []byte("x\n")); got != "" {
+		t.Errorf("expected no diff for equal input, got:\n%s", got)
+	}
+}
+
+func TestUnifiedMergesNearbyChanges(t *testing.T) {
+	a := lines(1, 20, nil)
+	b := lines(1, 21, map[int]string{5: "five", 16: "sixteen"}, 10)
+	want := `--- a.txt
++++ b.txt
+@@ -2,19 +2,19 @@
+ 2
+ 3
+ 4
+-5
++five
+ 6
+ 7
+ 8
+ 9
+-10
+ 11
+ 12
+ 13
+ 14
+ 15
+-16
++sixteen
+ 17
+ 18
+ 19
+ 20
++21
+`
+	if got := Unified("a.txt", "b.txt", []byte(a), []byte(b)); got != want {
+		t.Errorf("got:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestUnifiedSeparateHunks(t *testing.T) {
+	a := lines(1, 30, nil)
+	b := lines(1, 30, map[int]string{2: "two", 28: "twenty-eight"})
+	want := `--- a
++++ b
+@@ -1,5 +1,5 @@
+ 1
+-2
++two
+ 3
+ 4
+ 5
+@@ -25,6 +25,6 @@
+ 25
+ 26
+ 27
+-28
++twenty-eight
+ 29
+ 30
+`
+	if got := Unified("a", "b", []byte(a), []byte(b)); got != want {
+		t.Errorf("got:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestUnifiedAddedAndRemovedFiles(t *testing.T) {
+	if got, want := Unified("/dev/null", "b", nil, []byte("x\ny\n")), "--- /dev/null\n+++ b\n@@ -0,0 +1,2 @@\n+x\n+y\n"; got != want {
+		t.Errorf("added: got:\n%s\nwant:\n%s", got, want)
+	}
+	if got, want := Unified("a", "/dev/null", []byte("x\n"), nil), "--- a\n+++ /dev/null\n@@ -1 +0,0 @@\n-x\n"; got != want {
+		t.Errorf("removed: got:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestUnifiedMissingNewline(t *testing.T) {
+	want := "--- a\n+++ b\n@@ -1,2 +1,2 @@\n x\n-y\n\\ No newline at end of file\n+y\n"
+	if got := Unified("a", "b", []byte("x\ny"), []byte("x\ny\n")); got != want {
+		t.Errorf("got:\n%s\nwant:\n%s", got, want)
+	}
+}
@@ -0,0 +1,388 @@
+package filter
+
+import (
+	"strings"
+
+	"github.com/emicklei/proto"
+
+	"github.com/unitedtraders/proto-filter/internal/annotation"
+)
+
+// ElementKind identifies the kind of proto element an annotation is
+// attached to.
+type ElementKind string
+
+const (
+	KindService   ElementKind = "service"
+	KindRPC       ElementKind = "rpc"
+	KindMessage   ElementKind = "message"
+	KindField     ElementKind = "field"
+	KindEnum      ElementKind = "enum"
+	KindEnumValue ElementKind = "enum_value"
+	KindOneof     ElementKind = "oneof"
+)
+
+// Element describes a service, RPC, message, field, enum or enum value
+// visited by PruneElements, together with the annotations found in its
+// leading and inline comments.
+type Element struct {
+	Kind        ElementKind
+	Name        string
+	FQN         string // package-qualified name, e.g. "my.pkg.Order.id"
+	Annotations []annotation.Annotation
+	Parent      *Element // enclosing service, message or enum; nil at top level
+}
+
+// Inherited returns the annotations of the enclosing elements, outermost
+// first.
+func (e *Element) Inherited() []annotation.Annotation {
+	if e.Parent == nil {
+		return nil
+	}
+	return append(e.Parent.Inherited(), e.Parent.Annotations...)
+}
+
+// All returns the inherited annotations followed by the element's own.
+func (e *Element) All() []annotation.Annotation {
+	return append(e.Inherited(), e.Annotations...)
+}
+
+// PruneStats counts the elements removed by a filtering pass.
+type PruneStats struct {
+	Services   int `json:"services"`
+	Methods    int `json:"methods"`
+	Messages   int `json:"messages"` // messages and enums
+	Fields     int `json:"fields"`
+	EnumValues int `json:"enum_values"`
+	Orphans    int `json:"orphans"`
+}
+
+// Add accumulates the counts of other into s.
+func (s *PruneStats) Add(other PruneStats) {
+	s.Services += other.Services
+	s.Methods += other.Methods
+	s.Messages += other.Messages
+	s.Fields += other.Fields
+	s.EnumValues += other.EnumValues
+	s.Orphans += other.Orphans
+}
+
+// Removed returns true if any element was removed.
+func (s PruneStats) Removed() bool {
+	return s.Services+s.Methods+s.Messages+s.Fields+s.EnumValues+s.Orphans > 0
+}
+
+// PruneElements calls keep for every service, RPC, message, field, oneof
+// field, enum and enum value in the AST, recursing into nested messages,
+// nested enums and oneofs, and removes each element for which keep returns
+// false. Children are only visited when their parent is kept. A service
+// whose RPCs are all removed is removed as well. Fields and RPCs that
+// refer to a removed message or enum are removed with it, so the output
+// never names a type it no longer defines. Orphaned types are not cleaned
+// up; callers run RemoveOrphanedDefinitions as needed.
+func PruneElements(def *proto.Proto, pkg string, opts Options, keep func(*Element) bool) PruneStats {
+	var stats PruneStats
+	defined := definedTypes(def, pkg)
+	filtered := make([]proto.Visitee, 0, len(def.Elements))
+	for _, elem := range def.Elements {
+		switch v := elem.(type) {
+		case *proto.Service:
+			e := &Element{Kind: KindService, Name: v.Name, FQN: qualifiedName(pkg, v.Name), Annotations: ElementAnnotations(v, opts)}
+			if !keep(e) || pruneService(v, e, opts, keep, &stats) {
+				stats.Services++
+				continue
+			}
+		case *proto.Message:
+			e := &Element{Kind: KindMessage, Name: v.Name, FQN: qualifiedName(pkg, v.Name), Annotations: ElementAnnotations(v, opts)}
+			if !keep(e) {
+				stats.Messages++
+				continue
+			}
+			pruneMessage(v, e, opts, keep, &stats)
+		case *proto.Enum:
+			e := &Element{Kind: KindEnum, Name: v.Name, FQN: qualifiedName(pkg, v.Name), Annotations: ElementAnnotations(v, opts)}
+			if !keep(e) {
+				stats.Messages++
+				continue
+			}
+			pruneEnum(v, e, opts, keep, &stats)
+		}
+		filtered = append(filtered, elem)
+	}
+	def.Elements = filtered
+	if stats.Messages > 0 {
+		removeDanglingReferences(def, pkg, defined, &stats)
+	}
+	return stats
+}
+
+// definedTypes returns the fully qualified names of the messages and enums
+// in def, including nested ones.
+func definedTypes(def *proto.Proto, pkg string) map[string]bool {
+	types := make(map[string]bool)
+	var walk func(scope string, elements []proto.Visitee)
+	walk = func(scope string, elements []proto.Visitee) {
+		for _, elem := range elements {
+			switch v := elem.(type) {
+			case *proto.Message:
+				fqn := qualifiedName(scope, v.Name)
+				types[fqn] = true
+				walk(fqn, v.Elements)
+			case *proto.Enum:
+				types[qualifiedName(scope, v.Name)] = true
+			}
+		}
+	}
+	walk(pkg, def.Elements)
+	return types
+}
+
+// removeDanglingReferences removes the RPCs and fields that refer to a
+// type in defined that def no longer contains, and services left without
+// RPCs, adding them to stats.
+func removeDanglingReferences(def *proto.Proto, pkg string, defined map[string]bool, stats *PruneStats) {
+	remaining := definedTypes(def, pkg)
+	dangling := func(scope, typeName string) bool {
+		fqn := resolveType(defined, scope, typeName)
+		return fqn != "" && !remaining[fqn]
+	}
+
+	filtered := make([]proto.Visitee, 0, len(def.Elements))
+	for _, elem := range def.Elements {
+		switch v := elem.(type) {
+		case *proto.Service:
+			hadRPC, rpcs := false, make([]proto.Visitee, 0, len(v.Elements))
+			for _, svcElem := range v.Elements {
+				if rpc, ok := svcElem.(*proto.RPC); ok {
+					hadRPC = true
+					if dangling(pkg, rpc.RequestType) || dangling(pkg, rpc.ReturnsType) {
+						stats.Methods++
+						continue
+					}
+				}
+				rpcs = append(rpcs, svcElem)
+			}
+			v.Elements = rpcs
+			if hadRPC && !hasRPC(rpcs) {
+				stats.Services++
+				continue
+			}
+		case *proto.Message:
+			removeDanglingFields(v, qualifiedName(pkg, v.Name), dangling, stats)
+		}
+		filtered = append(filtered, elem)
+	}
+	def.Elements = filtered
+}
+
+func removeDanglingFields(msg *proto.Message, scope string, dangling func(scope, typeName string) bool, stats *PruneStats) {
+	filtered := make([]proto.Visitee, 0, len(msg.Elements))
+	for _, elem := range msg.Elements {
+		switch f := elem.(type) {
+		case *proto.NormalField:
+			if dangling(scope, f.Type) {
+				stats.Fields++
+				continue
+			}
+		case *proto.MapField:
+			if dangling(scope, f.Type) {
+				stats.Fields++
+				continue
+			}
+		case *proto.Oneof:
+			kept := make([]proto.Visitee, 0, len(f.Elements))
+			for _, oElem := range f.Elements {
+				if of, ok := oElem.(*proto.OneOfField); ok && dangling(scope, of.Type) {
+					stats.Fields++
+					continue
+				}
+				kept = append(kept, oElem)
+			}
+			f.Elements = kept
+		case *proto.Message:
+			removeDanglingFields(f, scope+"."+f.Name, dangling, stats)
+		}
+		filtered = append(filtered, elem)
+	}
+	msg.Elements = filtered
+}
+
+// resolveType returns the name in defined that typeName refers to from
+// within scope, searching the enclosing scopes from the innermost out as
+// protoc does, or "" if typeName is a scalar or defined elsewhere.
+func resolveType(defined map[string]bool, scope, typeName string) string {
+	if strings.HasPrefix(typeName, ".") {
+		if name := typeName[1:]; defined[name] {
+			return name
+		}
+		return ""
+	}
+	for {
+		if name := qualifiedName(scope, typeName); defined[name] {
+			return name
+		}
+		if scope == "" {
+			return ""
+		}
+		scope = scope[:max(strings.LastIndex(scope, "."), 0)]
+	}
+}
+
+func hasRPC(elements []proto.Visitee) bool {
+	for _, elem := range elements {
+		if _, ok := elem.(*proto.RPC); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// pruneService removes the RPCs of svc rejected by keep. It returns true
+// if the service had RPCs and none of them are left.
+func pruneService(svc *proto.Service, parent *Element, opts Options, keep func(*Element) bool, stats *PruneStats) bool {
+	hadRPC := false
+	filtered := make([]proto.Visitee, 0, len(svc.Elements))
+	for _, elem := range svc.Elements {
+		rpc, ok := elem.(*proto.RPC)
+		if !ok {
+			filtered = append(filtered, elem)
+			continue
+		}
+		hadRPC = true
+		e := &Element{Kind: KindRPC, Name: rpc.Name, FQN: parent.FQN + "." + rpc.Name, Annotations: ElementAnnotations(rpc, opts), Parent: parent}
+		if keep(e) {
+			filtered = append(filtered, elem)
+		} else {
+			stats.Methods++
+		}
+	}
+	svc.Elements = filtered
+	return hadRPC && !hasRPC(filtered)
+}
+
+func pruneMessage(msg *proto.Message, parent *Element, opts Options, keep func(*Element) bool, stats *PruneStats) {
+	filtered := make([]proto.Visitee, 0, len(msg.Elements))
+	for _, elem := range msg.Elements {
+		switch f := elem.(type) {
+		case *proto.NormalField:
+			if !keep(fieldElement(f.Field, parent, opts)) {
+				stats.Fields++
+				continue
+			}
+		case *proto.MapField:
+			if !keep(fieldElement(f.Field, parent, opts)) {
+				stats.Fields++
+				continue
+			}
+		case *proto.Oneof:
+			kept := make([]proto.Visitee, 0, len(f.Elements))
+			for _, oElem := range f.Elements {
+				if of, ok := oElem.(*proto.OneOfField); ok && !keep(fieldElement(of.Field, parent, opts)) {
+					stats.Fields++
+					continue
+				}
+				kept = append(kept, oElem)
+			}
+			f.Elements = kept
+		case *proto.Message:
+			e := &Element{Kind: KindMessage, Name: f.Name, FQN: parent.FQN + "." + f.Name, Annotations: ElementAnnotations(f, opts), Parent: parent}
+			if !keep(e) {
+				stats.Messages++
+				continue
+			}
+			pruneMessage(f, e, opts, keep, stats)
+		case *proto.Enum:
+			e := &Element{Kind: KindEnum, Name: f.Name, FQN: parent.FQN + "." + f.Name, Annotations: ElementAnnotations(f, opts), Parent: parent}
+			if !keep(e) {
+				stats.Messages++
+				continue
+			}
+			pruneEnum(f, e, opts, keep, stats)
+		}
+		filtered = append(filtered, elem)
+	}
+	msg.Elements = filtered
+}
+
+func pruneEnum(enum *proto.Enum, parent *Element, opts Options, keep func(*Element) bool, stats *PruneStats) {
+	filtered := make([]proto.Visitee, 0, len(enum.Elements))
+	for _, elem := range enum.Elements {
+		if ef, ok := elem.(*proto.EnumField); ok {
+			e := &Element{Kind: KindEnumValue, Name: ef.Name, FQN: parent.FQN + "." + ef.Name, Annotations: ElementAnnotations(ef, opts), Parent: parent}
+			if !keep(e) {
+				stats.EnumValues++
+				continue
+			}
+		}
+		filtered = append(filtered, elem)
+	}
+	enum.Elements = filtered
+}
+
+func fieldElement(f *proto.Field, parent *Element, opts Options) *Element {
+	return &Element{Kind: KindField, Name: f.Name, FQN: parent.FQN + "." + f.Name, Annotations: fieldAnnotations(f, opts), Parent: parent}
+}
+
+// hasAnnotationName returns true if any of the annotations has a name
+// matching the given name or pattern.
+func hasAnnotationName(annotations []annotation.Annotation, name string) bool {
+	for _, a := range annotations {
+		if annotation.MatchName(name, a.Name) {
+			return true
+		}
+	}
+	return false
+}
+
+// FilterByExpression removes elements whose annotations do not satisfy
+// expr. RPCs, fields, enum values and nested types are evaluated against
+// their own annotations plus those of their enclosing elements, so a
+// method inherits the annotations of its service and a field those of its
+// message. Members without annotations the expression mentions follow
+// their parent.
+// Services are kept while at least one RPC survives. Top-level messages
+// and enums satisfying expr are pinned, and those with a mentioned
+// annotation that fail it are removed along with the fields and RPCs
+// that use them; the others are kept only while referenced by surviving
+// definitions.
+func FilterByExpression(def *proto.Proto, pkg string, expr annotation.Expr, opts Options) PruneStats {
+	eval := func(annotations []annotation.Annotation) bool {
+		return expr.Eval(func(name string) bool {
+			return hasAnnotationName(annotations, name)
+		})
+	}
+	mentioned := func(annotations []annotation.Annotation) bool {
+		for _, name := range expr.Names() {
+			if hasAnnotationName(annotations, name) {
+				return true
+			}
+		}
+		return false
+	}
+
+	roots := make(map[string]bool)
+	stats := PruneElements(def, pkg, opts, func(e *Element) bool {
+		switch {
+		case e.Kind == KindService:
+			return true
+		case e.Kind == KindRPC:
+			return eval(e.All())
+		case e.Parent == nil:
+			// A top-level message or enum the expression rejects is dropped
+			// along with its references; the rest are left to orphan removal
+			if mentioned(e.Annotations) && !eval(e.Annotations) {
+				return false
+			}
+			if eval(e.Annotations) {
+				roots[e.FQN] = true
+			}
+			return true
+		case !mentioned(e.Annotations):
+			return true
+		default:
+			return eval(e.All())
+		}
+	})
+	stats.Orphans = RemoveOrphanedDefinitions(def, pkg, roots)
+	return stats
+}
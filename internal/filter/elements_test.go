@@ -0,0 +1,234 @@
+package filter
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/emicklei/proto"
+
+	"github.com/unitedtraders/proto-filter/internal/annotation"
+	"github.com/unitedtraders/proto-filter/internal/config"
+)
+
+func names(def *proto.Proto) string {
+	var out []string
+	for _, elem := range def.Elements {
+		switch v := elem.(type) {
+		case *proto.Service:
+			for _, e := range v.Elements {
+				if rpc, ok := e.(*proto.RPC); ok {
+					out = append(out, v.Name+"."+rpc.Name)
+				}
+			}
+		case *proto.Message:
+			out = append(out, v.Name)
+			for _, e := range v.Elements {
+				if f, ok := e.(*proto.NormalField); ok {
+					out = append(out, v.Name+"."+f.Name)
+				}
+			}
+		case *proto.Enum:
+			out = append(out, v.Name)
+			for _, e := range v.Elements {
+				if f, ok := e.(*proto.EnumField); ok {
+					out = append(out, v.Name+"."+f.Name)
+				}
+			}
+		}
+	}
+	return strings.Join(out, " ")
+}
+
+func TestPruneElementsVisitsAllKinds(t *testing.T) {
+	def := parseFixture(t, "expr", "expr_service.proto")
+	seen := make(map[ElementKind][]string)
+	PruneElements(def, "expr", Options{}, func(e *Element) bool {
+		seen[e.Kind] = append(seen[e.Kind], e.FQN)
+		return true
+	})
+	if got := len(seen[KindService]); got != 3 {
+		t.Errorf("services visited: %d", got)
+	}
+	if got := len(seen[KindRPC]); got != 5 {
+		t.Errorf("rpcs visited: %d", got)
+	}
+	if got := strings.Join(seen[KindEnumValue], ","); got != "expr.Status.STATUS_OK,expr.Status.STATUS_OLD" {
+		t.Errorf("enum values visited: %s", got)
+	}
+	if got := strings.Join(seen[KindField], ","); got != "expr.Order.id,expr.Order.old_id,expr.Order.partner_ref,expr.Order.amount,expr.Secret.value,expr.Resp.id,expr.Resp.secret" {
+		t.Errorf("fields visited: %s", got)
+	}
+}
+
+func TestPruneElementsRemovesEmptiedService(t *testing.T) {
+	def := parseFixture(t, "expr", "expr_service.proto")
+	stats := PruneElements(def, "expr", Options{}, func(e *Element) bool {
+		return e.Kind != KindRPC || e.Parent.Name != "InternalService"
+	})
+	if stats.Methods != 1 || stats.Services != 1 {
+		t.Errorf("expected 1 method and 1 service removed, got %+v", stats)
+	}
+	if strings.Contains(names(def), "InternalService") {
+		t.Error("InternalService should be removed once empty")
+	}
+}
+
+func TestElementInherited(t *testing.T) {
+	def := parseFixture(t, "expr", "expr_service.proto")
+	var legacy *Element
+	PruneElements(def, "expr", Options{}, func(e *Element) bool {
+		if e.FQN == "expr.PublicService.Legacy" {
+			legacy = e
+		}
+		return true
+	})
+	if legacy == nil {
+		t.Fatal("Legacy RPC not visited")
+	}
+	var got []string
+	for _, a := range legacy.All() {
+		got = append(got, a.Name)
+	}
+	if strings.Join(got, ",") != "Public,Deprecated" {
+		t.Errorf("All() = %v, want inherited Public then own Deprecated", got)
+	}
+}
+
+func TestFilterByExpression(t *testing.T) {
+	def := parseFixture(t, "expr", "expr_service.proto")
+	expr, err := annotation.ParseExpr("Public && !Deprecated || Partner")
+	if err != nil {
+		t.Fatalf("ParseExpr: %v", err)
+	}
+	stats := FilterByExpression(def, "expr", expr, Options{})
+
+	want := "PublicService.Get MixedService.Share GetRequest ShareRequest Order Order.id Order.partner_ref Order.amount Status Status.STATUS_OK"
+	if got := names(def); got != want {
+		t.Errorf("remaining:\n got: %s\nwant: %s", got, want)
+	}
+	if stats.Methods != 3 || stats.Services != 1 || stats.Fields != 1 || stats.EnumValues != 1 || stats.Orphans != 4 {
+		t.Errorf("unexpected stats: %+v", stats)
+	}
+}
+
+func TestFilterByExpressionNegationKeepsUnannotated(t *testing.T) {
+	def := parseFixture(t, "expr", "expr_service.proto")
+	expr, err := annotation.ParseExpr("!Deprecated")
+	if err != nil {
+		t.Fatalf("ParseExpr: %v", err)
+	}
+	FilterByExpression(def, "expr", expr, Options{})
+
+	got := names(def)
+	for _, want := range []string{"InternalService.Purge", "MixedService.Hidden", "Unused", "Order.id"} {
+		if !strings.Contains(got, want) {
+			t.Errorf("%s should be kept, remaining: %s", want, got)
+		}
+	}
+	for _, gone := range []string{"Legacy", "old_id", "STATUS_OLD"} {
+		if strings.Contains(got, gone) {
+			t.Errorf("%s should be removed, remaining: %s", gone, got)
+		}
+	}
+}
+
+func TestFilterByExpressionDropsReferencedExcludedType(t *testing.T) {
+	def := parseFixture(t, "expr", "expr_service.proto")
+	expr, err := annotation.ParseExpr("!Internal")
+	if err != nil {
+		t.Fatalf("ParseExpr: %v", err)
+	}
+	stats := FilterByExpression(def, "expr", expr, Options{})
+
+	// Secret is rejected, so Resp.secret, which uses it, goes too
+	got := names(def)
+	if strings.Contains(got, "Secret") || strings.Contains(got, "Resp.secret") {
+		t.Errorf("Secret and the field using it should be removed, remaining: %s", got)
+	}
+	if !strings.Contains(got, "Resp Resp.id") {
+		t.Errorf("Resp should be kept without the field, remaining: %s", got)
+	}
+	if stats.Messages != 1 || stats.Fields != 1 {
+		t.Errorf("expected 1 message and 1 field removed, got %+v", stats)
+	}
+}
+
+func TestFilterByExpressionIgnoresUnrelatedAnnotations(t *testing.T) {
+	def := parseFixture(t, "expr", "expr_service.proto")
+	expr, err := annotation.ParseExpr("Public")
+	if err != nil {
+		t.Fatalf("ParseExpr: %v", err)
+	}
+	stats := FilterByExpression(def, "expr", expr, Options{})
+
+	// amount carries only @Unit, which the expression does not mention, so
+	// it is kept like the unannotated id; old_id and partner_ref carry
+	// annotations of their own but none the expression mentions either
+	got := names(def)
+	for _, want := range []string{"Order.id", "Order.amount", "Order.old_id", "Order.partner_ref"} {
+		if !strings.Contains(got, want) {
+			t.Errorf("%s should be kept, remaining: %s", want, got)
+		}
+	}
+	if stats.Fields != 0 {
+		t.Errorf("expected no fields removed, got %+v", stats)
+	}
+}
+
+func TestApplyAnnotationFiltersExpr(t *testing.T) {
+	def := parseFixture(t, "expr", "expr_service.proto")
+	stats, err := ApplyAnnotationFilters(def, "expr", config.AnnotationConfig{Expr: "Partner"}, Options{})
+	if err != nil {
+		t.Fatalf("ApplyAnnotationFilters: %v", err)
+	}
+	if got := names(def); got != "MixedService.Share ShareRequest Order Order.id Order.old_id Order.partner_ref Order.amount" {
+		t.Errorf("remaining: %s", got)
+	}
+	if !stats.Removed() {
+		t.Error("stats should report removals")
+	}
+}
+
+func TestApplyAnnotationFiltersLists(t *testing.T) {
+	def := parseFixture(t, "expr", "expr_service.proto")
+	_, err := ApplyAnnotationFilters(def, "expr", config.AnnotationConfig{
+		Include: []string{"Public"},
+		Exclude: []string{"Deprecated"},
+	}, Options{})
+	if err != nil {
+		t.Fatalf("ApplyAnnotationFilters: %v", err)
+	}
+	got := names(def)
+	if !strings.Contains(got, "PublicService.Get") || strings.Contains(got, "Legacy") || strings.Contains(got, "MixedService") {
+		t.Errorf("unexpected remaining elements: %s", got)
+	}
+	var svc *proto.Service
+	for _, elem := range def.Elements {
+		if v, ok := elem.(*proto.Service); ok && v.Name == "PublicService" {
+			svc = v
+		}
+	}
+	if svc.Comment != nil {
+		t.Errorf("include marker should be stripped, got %q", svc.Comment.Lines)
+	}
+}
+
+func TestResolveType(t *testing.T) {
+	defined := map[string]bool{"pkg.Order": true, "pkg.Order.Item": true, "pkg.Item": true, "other.Item": true}
+	tests := []struct {
+		scope, typeName, want string
+	}{
+		{"pkg.Order", "Item", "pkg.Order.Item"},
+		{"pkg.Customer", "Item", "pkg.Item"},
+		{"pkg.Customer", "Order.Item", "pkg.Order.Item"},
+		{"pkg.Order", ".pkg.Item", "pkg.Item"},
+		{"pkg", "other.Item", "other.Item"},
+		{"pkg", "google.protobuf.Timestamp", ""},
+		{"pkg.Order", "string", ""},
+	}
+	for _, tc := range tests {
+		if got := resolveType(defined, tc.scope, tc.typeName); got != tc.want {
+			t.Errorf("resolveType(%q, %q) = %q, want %q", tc.scope, tc.typeName, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,49 @@
+package filter
+
+import (
+	"github.com/emicklei/proto"
+
+	"github.com/unitedtraders/proto-filter/internal/annotation"
+)
+
+// FeatureAnnotations name the annotations that gate an element behind a
+// feature flag, e.g. @Feature("instant-payouts").
+var FeatureAnnotations = []string{"Feature"}
+
+// FeatureEnabled reports whether every feature flag referenced by the
+// annotations is in the enabled set. Elements without feature markers are
+// always enabled.
+func FeatureEnabled(annotations []annotation.Annotation, enabled map[string]bool, opts Options) bool {
+	for _, a := range annotations {
+		if !containsName(FeatureAnnotations, a.Name, opts) {
+			continue
+		}
+		for _, flag := range a.Values() {
+			if !enabled[flag] {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// FilterByFeatures removes services, RPCs, messages, fields, enums and enum
+// values tagged with a feature flag that is not enabled, along with the
+// fields and RPCs that use a removed type, removes types left orphaned by
+// the removal, and strips the feature markers from the elements that
+// remain.
+func FilterByFeatures(def *proto.Proto, pkg string, enabled []string, opts Options) PruneStats {
+	enabledSet := make(map[string]bool, len(enabled))
+	for _, f := range enabled {
+		enabledSet[f] = true
+	}
+
+	stats := PruneElements(def, pkg, opts, func(e *Element) bool {
+		return FeatureEnabled(e.Annotations, enabledSet, opts)
+	})
+	if stats.Removed() {
+		stats.Orphans = RemoveOrphanedDefinitions(def, pkg)
+	}
+	StripAnnotations(def, FeatureAnnotations, opts)
+	return stats
+}
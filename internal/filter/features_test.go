@@ -0,0 +1,64 @@
+package filter
+
+import (
+	"testing"
+
+	"github.com/emicklei/proto"
+)
+
+func TestFilterByFeaturesDisabled(t *testing.T) {
+	def := parseFixture(t, "features", "payouts.proto")
+	stats := FilterByFeatures(def, "features", nil, Options{})
+
+	if got := names(def); got != "PayoutService.Payout PayoutRequest Payout Payout.id" {
+		t.Errorf("remaining: %s", got)
+	}
+	if stats.Methods != 1 || stats.Messages != 1 || stats.Fields != 2 || stats.Orphans != 1 {
+		t.Errorf("unexpected stats: %+v", stats)
+	}
+}
+
+func TestFilterByFeaturesDropsReferencesToRemovedTypes(t *testing.T) {
+	def := parseFixture(t, "features", "payouts.proto")
+	FilterByFeatures(def, "features", []string{"instant-payouts"}, Options{})
+
+	if topLevelMessage(def, "FxQuote") != nil {
+		t.Fatal("FxQuote should be removed without the fx flag")
+	}
+	for _, elem := range topLevelMessage(def, "Payout").Elements {
+		if f, ok := elem.(*proto.NormalField); ok && f.Type == "FxQuote" {
+			t.Errorf("field %s still refers to the removed FxQuote", f.Name)
+		}
+	}
+}
+
+func TestFilterByFeaturesEnabledStripsMarker(t *testing.T) {
+	def := parseFixture(t, "features", "payouts.proto")
+	FilterByFeatures(def, "features", []string{"instant-payouts"}, Options{})
+
+	if got := names(def); got != "PayoutService.Payout PayoutService.InstantPayout PayoutRequest InstantPayoutRequest Payout Payout.id" {
+		t.Errorf("remaining: %s", got)
+	}
+	var rpc *proto.RPC
+	for _, elem := range def.Elements {
+		if svc, ok := elem.(*proto.Service); ok {
+			rpc = svc.Elements[1].(*proto.RPC)
+		}
+	}
+	if rpc.Comment == nil || len(rpc.Comment.Lines) != 1 || rpc.Comment.Lines[0] != " Pays out immediately." {
+		t.Errorf("feature marker should be stripped, got %+v", rpc.Comment)
+	}
+}
+
+func TestFilterByFeaturesAllFlagsRequired(t *testing.T) {
+	def := parseFixture(t, "features", "payouts.proto")
+	FilterByFeatures(def, "features", []string{"instant-payouts", "fx"}, Options{})
+
+	payout := topLevelMessage(def, "Payout")
+	if len(payout.Elements) != 3 {
+		t.Fatalf("fx_rate and quote should be kept when both flags are enabled, got %d fields", len(payout.Elements))
+	}
+	if c := payout.Elements[1].(*proto.NormalField).InlineComment; c != nil {
+		t.Errorf("inline feature marker should be stripped, got %q", c.Lines)
+	}
+}
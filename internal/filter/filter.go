@@ -3,24 +3,106 @@ package filter
 import (
 	"fmt"
 	"path"
-	"regexp"
 	"strings"
+	"text/template"
 
 	"github.com/emicklei/proto"
 
+	"github.com/unitedtraders/proto-filter/internal/annotation"
 	"github.com/unitedtraders/proto-filter/internal/config"
 )
 
-var annotationRegex = regexp.MustCompile(`@(\w[\w.]*)|\[(\w[\w.]*)(?:\([^)]*\))?\]`)
-var substitutionRegex = regexp.MustCompile(`@(\w[\w.]*)(?:\(([^)]*)\))?|\[(\w[\w.]*)(?:\(([^)]*)\))?\]`)
+// Options controls how annotations are read from comments and proto
+// options, and how substituted comments are laid out. The zero value reads
+// the default annotation syntaxes, resolves no aliases, maps no options
+// and does not wrap substituted lines.
+type Options struct {
+	// Syntax lists the ways annotations are written in comments; nil means
+	// annotation.DefaultSyntaxes.
+	Syntax annotation.Syntaxes
+	// Aliases maps alternative annotation names, or name patterns, to the
+	// canonical name that filters, substitutions and strict checking see.
+	// For example {"Private": "Internal"} makes @Private behave as @Internal.
+	Aliases map[string]string
+	// IgnoreCase folds annotation names to lower case as they are read, so
+	// that @Internal, @internal and [INTERNAL] are the same annotation. The
+	// names they are compared with must be folded too, as config.LoadConfig
+	// does for case_insensitive_annotations.
+	IgnoreCase bool
+	// OptionAnnotations maps proto options to annotations, so that
+	// elements marked with an option are filtered like annotated ones.
+	OptionAnnotations []config.OptionAnnotation
+	// SubstitutionWrap is the width, in characters of comment text, to
+	// which lines produced by substitutions are wrapped; 0 disables
+	// wrapping.
+	SubstitutionWrap int
+	// Templates holds parsed substitution templates by their text. A
+	// template missing from it is parsed where it is used.
+	Templates map[string]*template.Template
+}
+
+// NewOptions returns the options set by a validated config. A nil config
+// gives the zero Options.
+func NewOptions(cfg *config.FilterConfig) Options {
+	if cfg == nil {
+		return Options{}
+	}
+	syntax, _ := cfg.Syntaxes()
+	templates, _ := cfg.SubstitutionTemplates()
+	return Options{
+		Syntax:            syntax,
+		Aliases:           cfg.AnnotationAliases,
+		IgnoreCase:        cfg.CaseInsensitiveAnnotations,
+		OptionAnnotations: cfg.OptionAnnotations,
+		SubstitutionWrap:  cfg.SubstitutionWrap,
+		Templates:         templates,
+	}
+}
+
+func (o Options) syntax() annotation.Syntaxes {
+	if o.Syntax == nil {
+		return annotation.DefaultSyntaxes
+	}
+	return o.Syntax
+}
+
+// fold returns name as it is compared: lower case under IgnoreCase.
+func (o Options) fold(name string) string {
+	if o.IgnoreCase {
+		return strings.ToLower(name)
+	}
+	return name
+}
+
+// canonicalName folds an annotation name and resolves it through Aliases.
+func (o Options) canonicalName(name string) string {
+	name = o.fold(name)
+	if canonical, ok := annotation.Lookup(o.Aliases, name); ok {
+		return canonical
+	}
+	return name
+}
+
+// findAnnotations returns the annotations in a comment line, with names
+// resolved by canonicalName.
+func (o Options) findAnnotations(line string) []annotation.Match {
+	matches := o.syntax().FindAll(line)
+	for i := range matches {
+		matches[i].Name = o.canonicalName(matches[i].Name)
+	}
+	return matches
+}
 
 // AnnotationLocation represents a single annotation occurrence found in a
 // proto source file, with its file path and line number.
 type AnnotationLocation struct {
-	File  string // relative file path
-	Line  int    // 1-based line number in source
-	Name  string // annotation name (for substitution map lookup)
-	Token string // full annotation token as it appears in source
+	File   string `json:"file"`             // relative file path
+	Line   int    `json:"line"`             // 1-based line number in source
+	Column int    `json:"column,omitempty"` // 1-based column of the token in source
+	Name   string `json:"name"`             // annotation name (for substitution map lookup)
+	Token  string `json:"token"`            // full annotation token as it appears in source
+	Kind   string `json:"kind,omitempty"`   // kind of the annotated element, e.g. "rpc" or "field"
+	Args   string `json:"args,omitempty"`   // argument text as written, without delimiters
 }
 
 // MatchesAny returns true if fqn matches any of the glob patterns.
@@ -152,37 +234,113 @@ func PruneAST(def *proto.Proto, pkg string, keepFQNs map[string]bool) {
 }
 
 // ExtractAnnotations returns annotation names found in a proto comment.
-// Annotations follow the pattern @Name, @Name(...), [Name], or [Name(...)].
+// Annotations follow opts.Syntax; by default the patterns @Name,
+// @Name(...), [Name], or [Name(...)].
 // Returns nil if comment is nil or contains no annotations.
-func ExtractAnnotations(comment *proto.Comment) []string {
+func ExtractAnnotations(comment *proto.Comment, opts Options) []string {
+	var names []string
+	for _, a := range ExtractAnnotationArgs(comment, opts) {
+		names = append(names, a.Name)
+	}
+	return names
+}
+
+// ExtractAnnotationArgs returns the annotations found in a proto comment
+// together with their parsed arguments. Returns nil if comment is nil or
+// contains no annotations.
+func ExtractAnnotationArgs(comment *proto.Comment, opts Options) []annotation.Annotation {
 	if comment == nil {
 		return nil
 	}
-	var annotations []string
+	var annotations []annotation.Annotation
 	for _, line := range comment.Lines {
-		matches := annotationRegex.FindAllStringSubmatch(line, -1)
-		for _, m := range matches {
-			if m[1] != "" {
-				annotations = append(annotations, m[1])
-			} else if m[2] != "" {
-				annotations = append(annotations, m[2])
-			}
+		for _, m := range opts.findAnnotations(line) {
+			annotations = append(annotations, annotation.New(m.Name, m.Args))
 		}
 	}
 	return annotations
 }
 
+// annotationMatcher selects annotations using the rules from an
+// annotation include or exclude list. Plain entries match by name; entries
+// such as `HasAnyRole contains "PARTNER"` also inspect the arguments.
+type annotationMatcher struct {
+	rules []annotation.Rule
+	opts  Options
+}
+
+func newAnnotationMatcher(rules []string, opts Options) annotationMatcher {
+	m := annotationMatcher{rules: make([]annotation.Rule, 0, len(rules)), opts: opts}
+	for _, r := range rules {
+		m.rules = append(m.rules, annotation.MustParseRule(r))
+	}
+	return m
+}
+
+// matches returns true if any annotation of the definition (see
+// ElementAnnotations) satisfies any rule.
+func (m annotationMatcher) matches(v proto.Visitee) bool {
+	for _, a := range ElementAnnotations(v, m.opts) {
+		for _, r := range m.rules {
+			if r.Matches(a) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// ApplyAnnotationFilters applies the annotation filters from cfg to a
+// single file's AST. With an expression configured, elements are filtered
+// by FilterByExpression. Otherwise include rules are applied first
+// (services, messages, and in include-only mode methods), then exclude
+// rules (services, methods, fields). Empty services and orphaned types are
+// removed afterwards and include markers are stripped from the output.
+func ApplyAnnotationFilters(def *proto.Proto, pkg string, cfg config.AnnotationConfig, opts Options) (PruneStats, error) {
+	if cfg.Expr != "" {
+		expr, err := annotation.ParseExpr(cfg.Expr)
+		if err != nil {
+			return PruneStats{}, err
+		}
+		return FilterByExpression(def, pkg, expr, opts), nil
+	}
+
+	var stats PruneStats
+	var includeRoots map[string]bool
+	if len(cfg.Include) > 0 {
+		stats.Services += IncludeServicesByAnnotation(def, cfg.Include, opts)
+		includeRoots = CollectIncludeMessageRoots(def, cfg.Include, opts)
+		stats.Messages += IncludeMessagesByAnnotation(def, cfg.Include, opts)
+		if len(cfg.Exclude) == 0 {
+			// Include-only mode: also filter methods by include annotations
+			stats.Methods += IncludeMethodsByAnnotation(def, cfg.Include, opts)
+		}
+	}
+	if len(cfg.Exclude) > 0 {
+		stats.Services += FilterServicesByAnnotation(def, cfg.Exclude, opts)
+		stats.Methods += FilterMethodsByAnnotation(def, cfg.Exclude, opts)
+		stats.Fields += FilterFieldsByAnnotation(def, cfg.Exclude, opts)
+	}
+	RemoveEmptyServices(def)
+	if stats.Services > 0 || stats.Methods > 0 || stats.Fields > 0 {
+		stats.Orphans = RemoveOrphanedDefinitions(def, pkg, includeRoots)
+	}
+
+	// Strip include annotation markers from output
+	if len(cfg.Include) > 0 {
+		StripAnnotations(def, cfg.Include, opts)
+	}
+	return stats, nil
+}
+
 // FilterServicesByAnnotation removes entire services from the proto AST
 // whose comments contain any of the specified annotations. Returns the
 // number of services removed.
-func FilterServicesByAnnotation(def *proto.Proto, annotations []string) int {
+func FilterServicesByAnnotation(def *proto.Proto, annotations []string, opts Options) int {
 	if len(annotations) == 0 {
 		return 0
 	}
-	annotSet := make(map[string]bool, len(annotations))
-	for _, a := range annotations {
-		annotSet[a] = true
-	}
+	matcher := newAnnotationMatcher(annotations, opts)
 
 	filtered := make([]proto.Visitee, 0, len(def.Elements))
 	removed := 0
@@ -192,14 +350,7 @@ func FilterServicesByAnnotation(def *proto.Proto, annotations []string) int {
 			filtered = append(filtered, elem)
 			continue
 		}
-		annots := ExtractAnnotations(svc.Comment)
-		shouldRemove := false
-		for _, a := range annots {
-			if annotSet[a] {
-				shouldRemove = true
-				break
-			}
-		}
+		shouldRemove := matcher.matches(svc)
 		if shouldRemove {
 			removed++
 		} else {
@@ -213,14 +364,11 @@ func FilterServicesByAnnotation(def *proto.Proto, annotations []string) int {
 // FilterMethodsByAnnotation removes RPC methods from services in the
 // given proto AST whose comments contain any of the specified annotations.
 // Returns the number of methods removed.
-func FilterMethodsByAnnotation(def *proto.Proto, annotations []string) int {
+func FilterMethodsByAnnotation(def *proto.Proto, annotations []string, opts Options) int {
 	if len(annotations) == 0 {
 		return 0
 	}
-	annotSet := make(map[string]bool, len(annotations))
-	for _, a := range annotations {
-		annotSet[a] = true
-	}
+	matcher := newAnnotationMatcher(annotations, opts)
 
 	removed := 0
 	for _, elem := range def.Elements {
@@ -235,14 +383,7 @@ func FilterMethodsByAnnotation(def *proto.Proto, annotations []string) int {
 				filtered = append(filtered, svcElem)
 				continue
 			}
-			annots := ExtractAnnotations(rpc.Comment)
-			shouldRemove := false
-			for _, a := range annots {
-				if annotSet[a] {
-					shouldRemove = true
-					break
-				}
-			}
+			shouldRemove := matcher.matches(rpc)
 			if shouldRemove {
 				removed++
 			} else {
@@ -259,14 +400,11 @@ func FilterMethodsByAnnotation(def *proto.Proto, annotations []string) int {
 // specified include list. Services without any annotations are kept
 // (their methods will be filtered individually by IncludeMethodsByAnnotation).
 // Returns the number of services removed.
-func IncludeServicesByAnnotation(def *proto.Proto, annotations []string) int {
+func IncludeServicesByAnnotation(def *proto.Proto, annotations []string, opts Options) int {
 	if len(annotations) == 0 {
 		return 0
 	}
-	annotSet := make(map[string]bool, len(annotations))
-	for _, a := range annotations {
-		annotSet[a] = true
-	}
+	matcher := newAnnotationMatcher(annotations, opts)
 
 	filtered := make([]proto.Visitee, 0, len(def.Elements))
 	removed := 0
@@ -276,14 +414,7 @@ func IncludeServicesByAnnotation(def *proto.Proto, annotations []string) int {
 			filtered = append(filtered, elem)
 			continue
 		}
-		annots := ExtractAnnotations(svc.Comment)
-		hasMatch := false
-		for _, a := range annots {
-			if annotSet[a] {
-				hasMatch = true
-				break
-			}
-		}
+		hasMatch := matcher.matches(svc)
 		if hasMatch {
 			filtered = append(filtered, elem)
 		} else {
@@ -298,14 +429,11 @@ func IncludeServicesByAnnotation(def *proto.Proto, annotations []string) int {
 // given proto AST whose comments do NOT contain any of the specified
 // annotations. This is the inverse of FilterMethodsByAnnotation.
 // Returns the number of methods removed.
-func IncludeMethodsByAnnotation(def *proto.Proto, annotations []string) int {
+func IncludeMethodsByAnnotation(def *proto.Proto, annotations []string, opts Options) int {
 	if len(annotations) == 0 {
 		return 0
 	}
-	annotSet := make(map[string]bool, len(annotations))
-	for _, a := range annotations {
-		annotSet[a] = true
-	}
+	matcher := newAnnotationMatcher(annotations, opts)
 
 	removed := 0
 	for _, elem := range def.Elements {
@@ -320,14 +448,7 @@ func IncludeMethodsByAnnotation(def *proto.Proto, annotations []string) int {
 				filtered = append(filtered, svcElem)
 				continue
 			}
-			annots := ExtractAnnotations(rpc.Comment)
-			hasMatch := false
-			for _, a := range annots {
-				if annotSet[a] {
-					hasMatch = true
-					break
-				}
-			}
+			hasMatch := matcher.matches(rpc)
 			if hasMatch {
 				filtered = append(filtered, svcElem)
 			} else {
@@ -343,14 +464,11 @@ func IncludeMethodsByAnnotation(def *proto.Proto, annotations []string) int {
 // have a matching include annotation. These can be passed to
 // RemoveOrphanedDefinitions as pinned roots to prevent them from being
 // removed as orphans.
-func CollectIncludeMessageRoots(def *proto.Proto, annotations []string) map[string]bool {
+func CollectIncludeMessageRoots(def *proto.Proto, annotations []string, opts Options) map[string]bool {
 	if len(annotations) == 0 {
 		return nil
 	}
-	annotSet := make(map[string]bool, len(annotations))
-	for _, a := range annotations {
-		annotSet[a] = true
-	}
+	matcher := newAnnotationMatcher(annotations, opts)
 
 	pkg := ""
 	for _, elem := range def.Elements {
@@ -364,37 +482,52 @@ func CollectIncludeMessageRoots(def *proto.Proto, annotations []string) map[stri
 	for _, elem := range def.Elements {
 		switch v := elem.(type) {
 		case *proto.Message:
-			for _, a := range ExtractAnnotations(v.Comment) {
-				if annotSet[a] {
-					roots[qualifiedName(pkg, v.Name)] = true
-					break
-				}
+			if messageHasAnnotation(v, matcher) {
+				roots[qualifiedName(pkg, v.Name)] = true
 			}
 		case *proto.Enum:
-			for _, a := range ExtractAnnotations(v.Comment) {
-				if annotSet[a] {
-					roots[qualifiedName(pkg, v.Name)] = true
-					break
-				}
+			if matcher.matches(v) {
+				roots[qualifiedName(pkg, v.Name)] = true
 			}
 		}
 	}
 	return roots
 }
 
+// messageHasAnnotation returns true if the message, or any message or enum
+// nested inside it, carries an annotation selected by the matcher. A nested
+// definition cannot be emitted without its enclosing message, so a match
+// anywhere in the tree selects the top-level message.
+func messageHasAnnotation(msg *proto.Message, matcher annotationMatcher) bool {
+	if matcher.matches(msg) {
+		return true
+	}
+	for _, elem := range msg.Elements {
+		switch v := elem.(type) {
+		case *proto.Message:
+			if messageHasAnnotation(v, matcher) {
+				return true
+			}
+		case *proto.Enum:
+			if matcher.matches(v) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
 // IncludeMessagesByAnnotation removes top-level messages and enums from the
 // proto AST whose comments do NOT contain any of the specified include
 // annotations and are not referenced (directly or transitively) by an
-// annotated message. Non-message/non-enum elements pass through unchanged.
-// Returns the number of removed messages/enums.
-func IncludeMessagesByAnnotation(def *proto.Proto, annotations []string) int {
+// annotated message. A message also counts as annotated when one of its
+// nested messages or enums carries a matching annotation. Non-message/non-enum
+// elements pass through unchanged. Returns the number of removed messages/enums.
+func IncludeMessagesByAnnotation(def *proto.Proto, annotations []string, opts Options) int {
 	if len(annotations) == 0 {
 		return 0
 	}
-	annotSet := make(map[string]bool, len(annotations))
-	for _, a := range annotations {
-		annotSet[a] = true
-	}
+	matcher := newAnnotationMatcher(annotations, opts)
 
 	// Extract package name for qualified name resolution
 	pkg := ""
@@ -411,20 +544,12 @@ func IncludeMessagesByAnnotation(def *proto.Proto, annotations []string) int {
 	for _, elem := range def.Elements {
 		switch v := elem.(type) {
 		case *proto.Message:
-			annots := ExtractAnnotations(v.Comment)
-			for _, a := range annots {
-				if annotSet[a] {
-					roots[qualifiedName(pkg, v.Name)] = true
-					break
-				}
+			if messageHasAnnotation(v, matcher) {
+				roots[qualifiedName(pkg, v.Name)] = true
 			}
 		case *proto.Enum:
-			annots := ExtractAnnotations(v.Comment)
-			for _, a := range annots {
-				if annotSet[a] {
-					roots[qualifiedName(pkg, v.Name)] = true
-					break
-				}
+			if matcher.matches(v) {
+				roots[qualifiedName(pkg, v.Name)] = true
 			}
 		case *proto.Service:
 			// Services are kept/removed by IncludeServicesByAnnotation.
@@ -502,14 +627,11 @@ func IncludeMessagesByAnnotation(def *proto.Proto, annotations []string) int {
 // AST whose comments contain any of the specified annotations. Handles
 // NormalField, MapField, and OneOfField (within Oneof containers). Also
 // recurses into nested messages. Returns the total count of fields removed.
-func FilterFieldsByAnnotation(def *proto.Proto, annotations []string) int {
+func FilterFieldsByAnnotation(def *proto.Proto, annotations []string, opts Options) int {
 	if len(annotations) == 0 {
 		return 0
 	}
-	annotSet := make(map[string]bool, len(annotations))
-	for _, a := range annotations {
-		annotSet[a] = true
-	}
+	matcher := newAnnotationMatcher(annotations, opts)
 
 	removed := 0
 	for _, elem := range def.Elements {
@@ -517,30 +639,30 @@ func FilterFieldsByAnnotation(def *proto.Proto, annotations []string) int {
 		if !ok {
 			continue
 		}
-		removed += filterFieldsInMessage(msg, annotSet)
+		removed += filterFieldsInMessage(msg, matcher)
 	}
 	return removed
 }
 
-func filterFieldsInMessage(msg *proto.Message, annotSet map[string]bool) int {
+func filterFieldsInMessage(msg *proto.Message, matcher annotationMatcher) int {
 	removed := 0
 	filtered := make([]proto.Visitee, 0, len(msg.Elements))
 	for _, elem := range msg.Elements {
 		switch f := elem.(type) {
 		case *proto.NormalField:
-			if fieldHasAnnotation(f.Comment, f.InlineComment, annotSet) {
+			if matcher.matches(f) {
 				removed++
 				continue
 			}
 		case *proto.MapField:
-			if fieldHasAnnotation(f.Comment, f.InlineComment, annotSet) {
+			if matcher.matches(f) {
 				removed++
 				continue
 			}
 		case *proto.Oneof:
-			removed += filterFieldsInOneof(f, annotSet)
+			removed += filterFieldsInOneof(f, matcher)
 		case *proto.Message:
-			removed += filterFieldsInMessage(f, annotSet)
+			removed += filterFieldsInMessage(f, matcher)
 		}
 		filtered = append(filtered, elem)
 	}
@@ -548,12 +670,12 @@ func filterFieldsInMessage(msg *proto.Message, annotSet map[string]bool) int {
 	return removed
 }
 
-func filterFieldsInOneof(oneof *proto.Oneof, annotSet map[string]bool) int {
+func filterFieldsInOneof(oneof *proto.Oneof, matcher annotationMatcher) int {
 	removed := 0
 	filtered := make([]proto.Visitee, 0, len(oneof.Elements))
 	for _, elem := range oneof.Elements {
 		if f, ok := elem.(*proto.OneOfField); ok {
-			if fieldHasAnnotation(f.Comment, f.InlineComment, annotSet) {
+			if matcher.matches(f) {
 				removed++
 				continue
 			}
@@ -564,20 +686,6 @@ func filterFieldsInOneof(oneof *proto.Oneof, annotSet map[string]bool) int {
 	return removed
 }
 
-func fieldHasAnnotation(comment, inlineComment *proto.Comment, annotSet map[string]bool) bool {
-	for _, a := range ExtractAnnotations(comment) {
-		if annotSet[a] {
-			return true
-		}
-	}
-	for _, a := range ExtractAnnotations(inlineComment) {
-		if annotSet[a] {
-			return true
-		}
-	}
-	return false
-}
-
 // RemoveEmptyServices removes service definitions that have zero RPC
 // method children. Returns the count of removed services.
 func RemoveEmptyServices(def *proto.Proto) int {
@@ -647,10 +755,14 @@ func collectMessageRefs(refs map[string]bool, pkg string, m *proto.Message) {
 			if isUserType(f.Type) {
 				addRef(refs, pkg, f.Type)
 			}
-		case *proto.OneOfField:
-			if isUserType(f.Type) {
-				addRef(refs, pkg, f.Type)
+		case *proto.Oneof:
+			for _, oElem := range f.Elements {
+				if of, ok := oElem.(*proto.OneOfField); ok && isUserType(of.Type) {
+					addRef(refs, pkg, of.Type)
+				}
 			}
+		case *proto.Message:
+			collectMessageRefs(refs, pkg, f)
 		}
 	}
 }
@@ -662,7 +774,7 @@ func addRef(refs map[string]bool, pkg, typeName string) {
 	if strings.Contains(typeName, ".") {
 		refs[typeName] = true
 	} else {
-		refs[pkg+"."+typeName] = true
+		refs[qualifiedName(pkg, typeName)] = true
 	}
 }
 
@@ -725,43 +837,91 @@ func RemoveOrphanedDefinitions(def *proto.Proto, pkg string, pinned ...map[strin
 
 // ConvertBlockComments walks the proto AST and converts all C-style
 // block comments (/* ... */) to single-line // comments. Leading
-// asterisk prefixes are stripped from each line.
+// asterisk prefixes are stripped from each line. Comments on nested
+// messages, nested enums and oneofs are converted as well.
 func ConvertBlockComments(def *proto.Proto) {
+	walkComments(def, func(cp **proto.Comment) {
+		convertComment(*cp)
+	})
+}
+
+// walkComments calls fn for the leading and inline comments of every
+// service, RPC, message, field, oneof, enum and enum value in the AST,
+// recursing into nested messages, nested enums and oneofs. The comment
+// is passed by reference so fn may replace or nil it.
+func walkComments(def *proto.Proto, fn func(cp **proto.Comment)) {
+	walkElementComments(def, func(_ ElementKind, _ string, cp **proto.Comment) {
+		fn(cp)
+	})
+}
+
+// walkElementComments is like walkComments but also passes the kind and
+// fully qualified name of the element each comment belongs to.
+func walkElementComments(def *proto.Proto, fn func(kind ElementKind, fqn string, cp **proto.Comment)) {
+	pkg := packageName(def)
 	for _, elem := range def.Elements {
 		switch v := elem.(type) {
 		case *proto.Service:
-			convertComment(v.Comment)
+			fqn := qualifiedName(pkg, v.Name)
+			fn(KindService, fqn, &v.Comment)
 			for _, svcElem := range v.Elements {
 				if rpc, ok := svcElem.(*proto.RPC); ok {
-					convertComment(rpc.Comment)
-					convertComment(rpc.InlineComment)
+					fn(KindRPC, fqn+"."+rpc.Name, &rpc.Comment)
+					fn(KindRPC, fqn+"."+rpc.Name, &rpc.InlineComment)
 				}
 			}
 		case *proto.Message:
-			convertComment(v.Comment)
-			for _, mElem := range v.Elements {
-				switch f := mElem.(type) {
-				case *proto.NormalField:
-					convertComment(f.Comment)
-					convertComment(f.InlineComment)
-				case *proto.MapField:
-					convertComment(f.Comment)
-					convertComment(f.InlineComment)
-				case *proto.OneOfField:
-					convertComment(f.Comment)
-					convertComment(f.InlineComment)
-				}
-			}
+			walkMessageComments(v, qualifiedName(pkg, v.Name), fn)
 		case *proto.Enum:
-			convertComment(v.Comment)
-			for _, eElem := range v.Elements {
-				if ef, ok := eElem.(*proto.EnumField); ok {
-					convertComment(ef.Comment)
-					convertComment(ef.InlineComment)
+			walkEnumComments(v, qualifiedName(pkg, v.Name), fn)
+		}
+	}
+}
+
+func walkMessageComments(msg *proto.Message, fqn string, fn func(kind ElementKind, fqn string, cp **proto.Comment)) {
+	fn(KindMessage, fqn, &msg.Comment)
+	for _, mElem := range msg.Elements {
+		switch f := mElem.(type) {
+		case *proto.NormalField:
+			fn(KindField, fqn+"."+f.Name, &f.Comment)
+			fn(KindField, fqn+"."+f.Name, &f.InlineComment)
+		case *proto.MapField:
+			fn(KindField, fqn+"."+f.Name, &f.Comment)
+			fn(KindField, fqn+"."+f.Name, &f.InlineComment)
+		case *proto.Oneof:
+			fn(KindOneof, fqn+"."+f.Name, &f.Comment)
+			for _, oElem := range f.Elements {
+				if of, ok := oElem.(*proto.OneOfField); ok {
+					fn(KindField, fqn+"."+of.Name, &of.Comment)
+					fn(KindField, fqn+"."+of.Name, &of.InlineComment)
 				}
 			}
+		case *proto.Message:
+			walkMessageComments(f, fqn+"."+f.Name, fn)
+		case *proto.Enum:
+			walkEnumComments(f, fqn+"."+f.Name, fn)
+		}
+	}
+}
+
+func walkEnumComments(enum *proto.Enum, fqn string, fn func(kind ElementKind, fqn string, cp **proto.Comment)) {
+	fn(KindEnum, fqn, &enum.Comment)
+	for _, eElem := range enum.Elements {
+		if ef, ok := eElem.(*proto.EnumField); ok {
+			fn(KindEnumValue, fqn+"."+ef.Name, &ef.Comment)
+			fn(KindEnumValue, fqn+"."+ef.Name, &ef.InlineComment)
+		}
+	}
+}
+
+// packageName returns the package declared in the AST, or "" if none.
+func packageName(def *proto.Proto) string {
+	for _, elem := range def.Elements {
+		if p, ok := elem.(*proto.Package); ok {
+			return p.Name
 		}
 	}
+	return ""
 }
 
 // convertComment converts a single block comment to single-line style.
@@ -801,224 +961,275 @@ func cleanBlockCommentLine(line string) string {
 	return trimmed
 }
 
-// SubstituteAnnotations replaces annotation tokens in comments across the
-// proto AST using the provided substitutions map. For each annotation found,
-// if its name exists in the map, the full annotation token is replaced with
-// the mapped description text. Empty description values cause the annotation
-// token to be removed; if all content is removed from a comment line, the
-// line is dropped; if all lines are dropped, the comment is set to nil on the
-// element. Returns the total count of substitutions made.
 // StripAnnotations removes annotation markers from comments by substituting
-// each annotation name with an empty string. It reuses SubstituteAnnotations
-// internally, which handles removing empty comment lines and nil-ing comments.
-func StripAnnotations(def *proto.Proto, annotations []string) int {
+// each annotation name with an empty string. Entries may be annotation rules;
+// the marker is stripped by the rule's annotation name. It reuses
+// SubstituteAnnotations internally, which handles removing empty comment
+// lines and nil-ing comments.
+func StripAnnotations(def *proto.Proto, annotations []string, opts Options) int {
 	stripMap := make(map[string]string, len(annotations))
-	for _, name := range annotations {
-		stripMap[name] = ""
+	for _, rule := range annotations {
+		stripMap[opts.fold(annotation.MustParseRule(rule).Name)] = ""
 	}
-	return SubstituteAnnotations(def, stripMap)
+	// Empty text is never a template, so stripping cannot fail.
+	count, _ := SubstituteAnnotations(def, stripMap, opts)
+	return count
 }
 
-func SubstituteAnnotations(def *proto.Proto, substitutions map[string]string) int {
-	if len(substitutions) == 0 {
-		return 0
-	}
+// SubstitutionText is the type of a substitutions map value: plain text
+// used for every element, or a config.Substitution keyed by element kind.
+type SubstitutionText interface {
+	string | config.Substitution
+}
+
+// SubstituteAnnotations replaces annotation tokens in comments across the
+// proto AST using the provided substitutions map. For each annotation found,
+// if its name has a mapping (see LookupSubstitution) with text for the kind
+// of element the comment belongs to, the full annotation token is replaced
+// with that description text. Description text containing `{{` is a
+// text/template executed with annotation.TemplateData for the annotation
+// and the element it is attached to; if the template fails, the annotation
+// is left in place, is not counted and is reported in the returned errors.
+// Otherwise a `%s` in the text is replaced with the raw argument string.
+// Empty description values cause the annotation token to be removed; if all
+// content is removed from a comment line, the line is dropped; if all lines
+// are dropped, the comment is set to nil on the element. Returns the total
+// count of substitutions made.
+func SubstituteAnnotations[T SubstitutionText](def *proto.Proto, substitutions map[string]T, opts Options) (int, []*SubstitutionError) {
+	counts, errs := SubstituteAnnotationsByName(def, substitutions, opts)
 	count := 0
-	for _, elem := range def.Elements {
-		switch v := elem.(type) {
-		case *proto.Service:
-			count += substituteInComment(&v.Comment, substitutions)
-			for _, svcElem := range v.Elements {
-				if rpc, ok := svcElem.(*proto.RPC); ok {
-					count += substituteInComment(&rpc.Comment, substitutions)
-					count += substituteInComment(&rpc.InlineComment, substitutions)
-				}
-			}
-		case *proto.Message:
-			count += substituteInComment(&v.Comment, substitutions)
-			for _, mElem := range v.Elements {
-				switch f := mElem.(type) {
-				case *proto.NormalField:
-					count += substituteInComment(&f.Comment, substitutions)
-					count += substituteInComment(&f.InlineComment, substitutions)
-				case *proto.MapField:
-					count += substituteInComment(&f.Comment, substitutions)
-					count += substituteInComment(&f.InlineComment, substitutions)
-				case *proto.OneOfField:
-					count += substituteInComment(&f.Comment, substitutions)
-					count += substituteInComment(&f.InlineComment, substitutions)
-				}
-			}
-		case *proto.Enum:
-			count += substituteInComment(&v.Comment, substitutions)
-			for _, eElem := range v.Elements {
-				if ef, ok := eElem.(*proto.EnumField); ok {
-					count += substituteInComment(&ef.Comment, substitutions)
-					count += substituteInComment(&ef.InlineComment, substitutions)
-				}
-			}
+	for _, n := range counts {
+		count += n
+	}
+	return count, errs
+}
+
+// SubstituteAnnotationsByName works like SubstituteAnnotations but returns
+// the number of substitutions made for each annotation name.
+func SubstituteAnnotationsByName[T SubstitutionText](def *proto.Proto, substitutions map[string]T, opts Options) (map[string]int, []*SubstitutionError) {
+	counts := make(map[string]int)
+	if len(substitutions) == 0 {
+		return counts, nil
+	}
+	byKind := substitutionsByKind(substitutions)
+	var errs []*SubstitutionError
+	walkElementComments(def, func(kind ElementKind, fqn string, cp **proto.Comment) {
+		errs = append(errs, substituteInComment(cp, byKind, kind, fqn, counts, opts)...)
+	})
+	return counts, errs
+}
+
+// SubstitutionError is a substitution template that failed to render for
+// one annotation.
+type SubstitutionError struct {
+	Line   int    // 1-based source line of the annotation; 0 if unknown
+	Column int    // 1-based column of the annotation token; 0 if unknown
+	FQN    string // element the annotated comment belongs to
+	Token  string // the annotation token as written
+	Err    error
+}
+
+func (e *SubstitutionError) Error() string {
+	return fmt.Sprintf("substituting %s on %s: %v", e.Token, e.FQN, e.Err)
+}
+
+func (e *SubstitutionError) Unwrap() error {
+	return e.Err
+}
+
+// LookupSubstitution returns the substitution for an annotation name. Keys
+// may be name patterns such as `auth.*`; an exact key takes precedence over
+// patterns, and the most specific matching pattern wins (see
+// annotation.Lookup).
+func LookupSubstitution[T SubstitutionText](substitutions map[string]T, name string) (T, bool) {
+	return annotation.Lookup(substitutions, name)
+}
+
+// substitutionsByKind converts a substitutions map to config.Substitution
+// values, using plain text as the default for every kind.
+func substitutionsByKind[T SubstitutionText](substitutions map[string]T) map[string]config.Substitution {
+	byKind := make(map[string]config.Substitution, len(substitutions))
+	for name, v := range substitutions {
+		switch v := any(v).(type) {
+		case string:
+			byKind[name] = config.Substitution{config.DefaultKind: v}
+		case config.Substitution:
+			byKind[name] = v
 		}
 	}
-	return count
+	return byKind
 }
 
-// substituteInComment performs annotation substitution on a single comment.
-// Accepts a pointer-to-pointer so the comment can be set to nil if all lines
-// are removed.
-func substituteInComment(cp **proto.Comment, substitutions map[string]string) int {
+// substituteInComment performs annotation substitution on a single comment
+// of an element of the given kind and FQN, adding the substitutions made to
+// counts by annotation name unless counts is nil. It returns the templates
+// that failed to render. Accepts a pointer-to-pointer so the comment can be
+// set to nil if all lines are removed.
+func substituteInComment(cp **proto.Comment, substitutions map[string]config.Substitution, kind ElementKind, fqn string, counts map[string]int, opts Options) []*SubstitutionError {
 	if cp == nil || *cp == nil {
-		return 0
+		return nil
 	}
 	c := *cp
 	count := 0
+	var errs []*SubstitutionError
 	var cleaned []string
-	for _, line := range c.Lines {
-		newLine := substitutionRegex.ReplaceAllStringFunc(line, func(match string) string {
-			// Parse the match to extract annotation name and argument
-			submatch := substitutionRegex.FindStringSubmatch(match)
-			name := submatch[1]
-			args := submatch[2]
-			if name == "" {
-				name = submatch[3]
-				args = submatch[4]
+	for i, line := range c.Lines {
+		before := count
+		newLine := opts.syntax().ReplaceAll(line, func(m annotation.Match) string {
+			name := opts.canonicalName(m.Name)
+			sub, ok := LookupSubstitution(substitutions, name)
+			if !ok {
+				return m.Token
 			}
-			if replacement, ok := substitutions[name]; ok {
+			replacement, ok := sub.Text(string(kind))
+			if !ok {
+				return m.Token
+			}
+			if annotation.IsTemplate(replacement) {
+				text, err := renderSubstitution(replacement, annotation.New(name, m.Args), kind, fqn, opts)
+				if err != nil {
+					var line, column int
+					if c.Position.Line > 0 {
+						line, column = c.Position.Line+i, commentColumn(c)+m.Start
+					}
+					errs = append(errs, &SubstitutionError{Line: line, Column: column, FQN: fqn, Token: m.Token, Err: err})
+					return m.Token
+				}
 				count++
-				if strings.Contains(replacement, "%s") {
-					return strings.Replace(replacement, "%s", args, 1)
+				if counts != nil {
+					counts[name]++
 				}
-				return replacement
+				return text
 			}
-			return match
+			count++
+			if counts != nil {
+				counts[name]++
+			}
+			if strings.Contains(replacement, "%s") {
+				return strings.Replace(replacement, "%s", m.Args, 1)
+			}
+			return replacement
 		})
-		// Trim the line
-		trimmed := strings.TrimSpace(newLine)
-		if trimmed == "" {
+		// Multi-line substitutions become separate comment lines
+		var lines []string
+		if strings.Contains(newLine, "\n") {
+			lines = expandLines(line, newLine)
+		} else if trimmed := strings.TrimSpace(newLine); trimmed != "" {
+			lines = []string{trimmed}
+		} else {
 			// Line became empty after substitution — drop it
 			continue
 		}
-		cleaned = append(cleaned, " "+trimmed)
+		if count > before {
+			lines = wrapLines(lines, opts.SubstitutionWrap)
+		}
+		for _, l := range lines {
+			if l != "" {
+				l = " " + l
+			}
+			cleaned = append(cleaned, l)
+		}
 	}
 	if len(cleaned) == 0 {
 		*cp = nil
 	} else {
 		c.Lines = cleaned
 	}
-	return count
+	return errs
+}
+
+// renderSubstitution executes a template substitution for one annotation,
+// using the template parsed in opts.Templates if there is one.
+func renderSubstitution(text string, a annotation.Annotation, kind ElementKind, fqn string, opts Options) (string, error) {
+	tmpl, ok := opts.Templates[text]
+	if !ok {
+		var err error
+		if tmpl, err = annotation.ParseTemplate(text); err != nil {
+			return "", err
+		}
+	}
+	var b strings.Builder
+	if err := tmpl.Execute(&b, annotation.NewTemplateData(a, string(kind), fqn)); err != nil {
+		return "", err
+	}
+	return b.String(), nil
 }
 
 // CollectAnnotationLocations walks all elements in the proto AST and collects
 // the location of each annotation occurrence. Returns a slice of AnnotationLocation
 // with the file path, line number, annotation name, and full token.
-func CollectAnnotationLocations(def *proto.Proto, relPath string) []AnnotationLocation {
+func CollectAnnotationLocations(def *proto.Proto, relPath string, opts Options) []AnnotationLocation {
 	var locations []AnnotationLocation
-	for _, elem := range def.Elements {
-		switch v := elem.(type) {
-		case *proto.Service:
-			locations = collectLocationsFromComment(v.Comment, relPath, locations)
-			for _, svcElem := range v.Elements {
-				if rpc, ok := svcElem.(*proto.RPC); ok {
-					locations = collectLocationsFromComment(rpc.Comment, relPath, locations)
-					locations = collectLocationsFromComment(rpc.InlineComment, relPath, locations)
-				}
-			}
-		case *proto.Message:
-			locations = collectLocationsFromComment(v.Comment, relPath, locations)
-			for _, mElem := range v.Elements {
-				switch f := mElem.(type) {
-				case *proto.NormalField:
-					locations = collectLocationsFromComment(f.Comment, relPath, locations)
-					locations = collectLocationsFromComment(f.InlineComment, relPath, locations)
-				case *proto.MapField:
-					locations = collectLocationsFromComment(f.Comment, relPath, locations)
-					locations = collectLocationsFromComment(f.InlineComment, relPath, locations)
-				case *proto.OneOfField:
-					locations = collectLocationsFromComment(f.Comment, relPath, locations)
-					locations = collectLocationsFromComment(f.InlineComment, relPath, locations)
-				}
-			}
-		case *proto.Enum:
-			locations = collectLocationsFromComment(v.Comment, relPath, locations)
-			for _, eElem := range v.Elements {
-				if ef, ok := eElem.(*proto.EnumField); ok {
-					locations = collectLocationsFromComment(ef.Comment, relPath, locations)
-					locations = collectLocationsFromComment(ef.InlineComment, relPath, locations)
-				}
-			}
-		}
-	}
+	walkElementComments(def, func(kind ElementKind, _ string, cp **proto.Comment) {
+		locations = collectLocationsFromComment(*cp, relPath, kind, locations, opts)
+	})
 	return locations
 }
 
-func collectLocationsFromComment(c *proto.Comment, relPath string, locations []AnnotationLocation) []AnnotationLocation {
+func collectLocationsFromComment(c *proto.Comment, relPath string, kind ElementKind, locations []AnnotationLocation, opts Options) []AnnotationLocation {
 	if c == nil {
 		return locations
 	}
 	for i, line := range c.Lines {
-		matches := substitutionRegex.FindAllStringSubmatch(line, -1)
-		for _, m := range matches {
-			name := m[1]
-			if name == "" {
-				name = m[3]
-			}
+		for _, m := range opts.findAnnotations(line) {
 			locations = append(locations, AnnotationLocation{
-				File:  relPath,
-				Line:  c.Position.Line + i,
-				Name:  name,
-				Token: m[0],
+				File:   relPath,
+				Line:   c.Position.Line + i,
+				Column: commentColumn(c) + m.Start,
+				Name:   m.Name,
+				Token:  m.Token,
+				Kind:   string(kind),
+				Args:   m.Args,
 			})
 		}
 	}
 	return locations
 }
 
+// commentColumn returns the column at which the text of a comment line
+// starts, just after the `//` (or `///`) marker. Lines of a comment are
+// assumed to be aligned.
+func commentColumn(c *proto.Comment) int {
+	if c.ExtraSlash {
+		return c.Position.Column + 3
+	}
+	return c.Position.Column + 2
+}
+
 // CollectAllAnnotations walks all elements in the proto AST and collects all
 // unique annotation names from comments. Returns a map of annotation names.
-func CollectAllAnnotations(def *proto.Proto) map[string]bool {
+func CollectAllAnnotations(def *proto.Proto, opts Options) map[string]bool {
 	result := make(map[string]bool)
-	for _, elem := range def.Elements {
-		switch v := elem.(type) {
-		case *proto.Service:
-			collectAnnotationsFromComment(v.Comment, result)
-			for _, svcElem := range v.Elements {
-				if rpc, ok := svcElem.(*proto.RPC); ok {
-					collectAnnotationsFromComment(rpc.Comment, result)
-					collectAnnotationsFromComment(rpc.InlineComment, result)
-				}
-			}
-		case *proto.Message:
-			collectAnnotationsFromComment(v.Comment, result)
-			for _, mElem := range v.Elements {
-				switch f := mElem.(type) {
-				case *proto.NormalField:
-					collectAnnotationsFromComment(f.Comment, result)
-					collectAnnotationsFromComment(f.InlineComment, result)
-				case *proto.MapField:
-					collectAnnotationsFromComment(f.Comment, result)
-					collectAnnotationsFromComment(f.InlineComment, result)
-				case *proto.OneOfField:
-					collectAnnotationsFromComment(f.Comment, result)
-					collectAnnotationsFromComment(f.InlineComment, result)
-				}
-			}
-		case *proto.Enum:
-			collectAnnotationsFromComment(v.Comment, result)
-			for _, eElem := range v.Elements {
-				if ef, ok := eElem.(*proto.EnumField); ok {
-					collectAnnotationsFromComment(ef.Comment, result)
-					collectAnnotationsFromComment(ef.InlineComment, result)
+	walkComments(def, func(cp **proto.Comment) {
+		collectAnnotationsFromComment(*cp, result, opts)
+	})
+	return result
+}
+
+// CollectAliasUses returns the annotation names written in comments that
+// opts.Aliases resolves to a different name, mapped to that name.
+func CollectAliasUses(def *proto.Proto, opts Options) map[string]string {
+	uses := make(map[string]string)
+	walkComments(def, func(cp **proto.Comment) {
+		if *cp == nil {
+			return
+		}
+		for _, line := range (*cp).Lines {
+			for _, m := range opts.syntax().FindAll(line) {
+				if canonical := opts.canonicalName(m.Name); canonical != opts.fold(m.Name) {
+					uses[m.Name] = canonical
 				}
 			}
 		}
-	}
-	return result
+	})
+	return uses
 }
 
-func collectAnnotationsFromComment(c *proto.Comment, result map[string]bool) {
+func collectAnnotationsFromComment(c *proto.Comment, result map[string]bool, opts Options) {
 	if c == nil {
 		return
 	}
-	for _, name := range ExtractAnnotations(c) {
+	for _, name := range ExtractAnnotations(c, opts) {
 		result[name] = true
 	}
 }
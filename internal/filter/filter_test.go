@@ -3,11 +3,15 @@ package filter
 import (
 	"os"
 	"path/filepath"
+	"reflect"
 	"strings"
 	"testing"
+	"text/scanner"
+	"text/template"
 
 	"github.com/emicklei/proto"
 
+	"github.com/unitedtraders/proto-filter/internal/annotation"
 	"github.com/unitedtraders/proto-filter/internal/config"
 	"github.com/unitedtraders/proto-filter/internal/deps"
 	"github.com/unitedtraders/proto-filter/internal/parser"
@@ -236,7 +240,7 @@ func TestIntegrationFilter(t *testing.T) {
 		}
 		PruneAST(pf.def, pf.pkg, keepSet)
 		outPath := filepath.Join(outputDir, pf.rel)
-		if err := writer.WriteProtoFile(pf.def, outPath); err != nil {
+		if err := writer.WriteProtoFile(pf.def, outPath, config.FormatConfig{}); err != nil {
 			t.Fatalf("write %s: %v", pf.rel, err)
 		}
 	}
@@ -313,7 +317,7 @@ func TestExtractAnnotations(t *testing.T) {
 	for _, tc := range tests {
 		t.Run(tc.name, func(t *testing.T) {
 			comment := &proto.Comment{Lines: tc.lines}
-			got := ExtractAnnotations(comment)
+			got := ExtractAnnotations(comment, Options{})
 			if len(got) != len(tc.want) {
 				t.Fatalf("ExtractAnnotations: got %v, want %v", got, tc.want)
 			}
@@ -327,7 +331,7 @@ func TestExtractAnnotations(t *testing.T) {
 }
 
 func TestExtractAnnotationsNilComment(t *testing.T) {
-	got := ExtractAnnotations(nil)
+	got := ExtractAnnotations(nil, Options{})
 	if len(got) != 0 {
 		t.Errorf("nil comment should return empty, got %v", got)
 	}
@@ -341,7 +345,7 @@ func TestFilterMethodsByAnnotation(t *testing.T) {
 		t.Fatalf("parse: %v", err)
 	}
 
-	removed := FilterMethodsByAnnotation(def, []string{"HasAnyRole"})
+	removed := FilterMethodsByAnnotation(def, []string{"HasAnyRole"}, Options{})
 	if removed != 2 {
 		t.Errorf("expected 2 methods removed, got %d", removed)
 	}
@@ -367,7 +371,7 @@ func TestFilterMethodsByAnnotationNoMatch(t *testing.T) {
 		t.Fatalf("parse: %v", err)
 	}
 
-	removed := FilterMethodsByAnnotation(def, []string{"NonExistent"})
+	removed := FilterMethodsByAnnotation(def, []string{"NonExistent"}, Options{})
 	if removed != 0 {
 		t.Errorf("expected 0 methods removed, got %d", removed)
 	}
@@ -391,7 +395,7 @@ func TestFilterMethodsByAnnotationKeepsNonFilteredAnnotation(t *testing.T) {
 
 	// Filter for "Internal" — none of the methods have this annotation.
 	// Methods with @HasAnyRole should NOT be removed.
-	removed := FilterMethodsByAnnotation(def, []string{"Internal"})
+	removed := FilterMethodsByAnnotation(def, []string{"Internal"}, Options{})
 	if removed != 0 {
 		t.Errorf("expected 0 methods removed, got %d", removed)
 	}
@@ -423,12 +427,12 @@ func TestIntegrationAnnotationFilter(t *testing.T) {
 	}
 
 	// Filter methods by annotation
-	FilterMethodsByAnnotation(def, []string{"HasAnyRole"})
+	FilterMethodsByAnnotation(def, []string{"HasAnyRole"}, Options{})
 
 	// Write output and verify
 	outputDir := t.TempDir()
 	outputPath := filepath.Join(outputDir, "service.proto")
-	if err := writer.WriteProtoFile(def, outputPath); err != nil {
+	if err := writer.WriteProtoFile(def, outputPath, config.FormatConfig{}); err != nil {
 		t.Fatalf("write: %v", err)
 	}
 
@@ -470,7 +474,7 @@ func TestRemoveEmptyServices(t *testing.T) {
 	}
 
 	// Remove all methods (all annotated)
-	FilterMethodsByAnnotation(def, []string{"HasAnyRole"})
+	FilterMethodsByAnnotation(def, []string{"HasAnyRole"}, Options{})
 
 	removed := RemoveEmptyServices(def)
 	if removed != 1 {
@@ -492,7 +496,7 @@ func TestRemoveEmptyServicesKeepsNonEmpty(t *testing.T) {
 	}
 
 	// Remove annotated methods (2 of 3)
-	FilterMethodsByAnnotation(def, []string{"HasAnyRole"})
+	FilterMethodsByAnnotation(def, []string{"HasAnyRole"}, Options{})
 
 	removed := RemoveEmptyServices(def)
 	if removed != 0 {
@@ -542,7 +546,7 @@ func TestIntegrationEmptyServiceRemoval(t *testing.T) {
 		t.Fatalf("parse: %v", err)
 	}
 
-	FilterMethodsByAnnotation(def, []string{"HasAnyRole"})
+	FilterMethodsByAnnotation(def, []string{"HasAnyRole"}, Options{})
 	RemoveEmptyServices(def)
 	RemoveOrphanedDefinitions(def, "annotations")
 
@@ -560,7 +564,7 @@ func TestCollectReferencedTypes(t *testing.T) {
 	}
 
 	// Filter out Refund method first
-	FilterMethodsByAnnotation(def, []string{"HasAnyRole"})
+	FilterMethodsByAnnotation(def, []string{"HasAnyRole"}, Options{})
 
 	refs := CollectReferencedTypes(def, "annotations")
 
@@ -592,7 +596,7 @@ func TestRemoveOrphanedDefinitions(t *testing.T) {
 		t.Fatalf("parse: %v", err)
 	}
 
-	FilterMethodsByAnnotation(def, []string{"HasAnyRole"})
+	FilterMethodsByAnnotation(def, []string{"HasAnyRole"}, Options{})
 	removed := RemoveOrphanedDefinitions(def, "annotations")
 
 	if removed != 2 {
@@ -639,12 +643,12 @@ func TestIntegrationOrphanRemoval(t *testing.T) {
 		t.Fatalf("parse: %v", err)
 	}
 
-	FilterMethodsByAnnotation(def, []string{"HasAnyRole"})
+	FilterMethodsByAnnotation(def, []string{"HasAnyRole"}, Options{})
 	RemoveOrphanedDefinitions(def, "annotations")
 
 	outputDir := t.TempDir()
 	outputPath := filepath.Join(outputDir, "shared.proto")
-	if err := writer.WriteProtoFile(def, outputPath); err != nil {
+	if err := writer.WriteProtoFile(def, outputPath, config.FormatConfig{}); err != nil {
 		t.Fatalf("write: %v", err)
 	}
 
@@ -689,7 +693,7 @@ func testGoldenFile(t *testing.T, inputName string) {
 
 	outputDir := t.TempDir()
 	outputPath := filepath.Join(outputDir, inputName+".proto")
-	if err := writer.WriteProtoFile(def, outputPath); err != nil {
+	if err := writer.WriteProtoFile(def, outputPath, config.FormatConfig{}); err != nil {
 		t.Fatalf("write: %v", err)
 	}
 
@@ -898,7 +902,7 @@ func TestFilterServicesByAnnotation(t *testing.T) {
 		t.Fatalf("parse: %v", err)
 	}
 
-	removed := FilterServicesByAnnotation(def, []string{"Internal"})
+	removed := FilterServicesByAnnotation(def, []string{"Internal"}, Options{})
 	if removed != 1 {
 		t.Errorf("expected 1 service removed, got %d", removed)
 	}
@@ -934,7 +938,7 @@ func TestFilterServicesByAnnotationNoMatch(t *testing.T) {
 		t.Fatalf("parse: %v", err)
 	}
 
-	removed := FilterServicesByAnnotation(def, []string{"NonExistent"})
+	removed := FilterServicesByAnnotation(def, []string{"NonExistent"}, Options{})
 	if removed != 0 {
 		t.Errorf("expected 0 services removed, got %d", removed)
 	}
@@ -963,7 +967,7 @@ func TestFilterServicesByAnnotationMultipleAnnotations(t *testing.T) {
 		},
 	}
 
-	removed := FilterServicesByAnnotation(def, []string{"Internal"})
+	removed := FilterServicesByAnnotation(def, []string{"Internal"}, Options{})
 	if removed != 1 {
 		t.Errorf("expected 1 service removed (any match sufficient), got %d", removed)
 	}
@@ -987,13 +991,13 @@ func TestGoldenFileServiceAnnotated(t *testing.T) {
 		t.Fatalf("parse: %v", err)
 	}
 
-	FilterServicesByAnnotation(def, []string{"Internal"})
+	FilterServicesByAnnotation(def, []string{"Internal"}, Options{})
 	RemoveOrphanedDefinitions(def, "annotations")
 	ConvertBlockComments(def)
 
 	outputDir := t.TempDir()
 	outputPath := filepath.Join(outputDir, "service_annotated.proto")
-	if err := writer.WriteProtoFile(def, outputPath); err != nil {
+	if err := writer.WriteProtoFile(def, outputPath, config.FormatConfig{}); err != nil {
 		t.Fatalf("write: %v", err)
 	}
 
@@ -1021,15 +1025,15 @@ func TestGoldenFileMixedAnnotations(t *testing.T) {
 		t.Fatalf("parse: %v", err)
 	}
 
-	FilterServicesByAnnotation(def, []string{"Internal", "HasAnyRole"})
-	FilterMethodsByAnnotation(def, []string{"Internal", "HasAnyRole"})
+	FilterServicesByAnnotation(def, []string{"Internal", "HasAnyRole"}, Options{})
+	FilterMethodsByAnnotation(def, []string{"Internal", "HasAnyRole"}, Options{})
 	RemoveEmptyServices(def)
 	RemoveOrphanedDefinitions(def, "annotations")
 	ConvertBlockComments(def)
 
 	outputDir := t.TempDir()
 	outputPath := filepath.Join(outputDir, "mixed_annotations.proto")
-	if err := writer.WriteProtoFile(def, outputPath); err != nil {
+	if err := writer.WriteProtoFile(def, outputPath, config.FormatConfig{}); err != nil {
 		t.Fatalf("write: %v", err)
 	}
 
@@ -1064,7 +1068,7 @@ func TestFilterServicesByAnnotationAllServicesRemoved(t *testing.T) {
 		}
 	}
 
-	removed := FilterServicesByAnnotation(def, []string{"Internal"})
+	removed := FilterServicesByAnnotation(def, []string{"Internal"}, Options{})
 	if removed != 2 {
 		t.Errorf("expected 2 services removed, got %d", removed)
 	}
@@ -1084,12 +1088,12 @@ func TestFilterServicesByAnnotationEmptyAnnotationList(t *testing.T) {
 		t.Fatalf("parse: %v", err)
 	}
 
-	removed := FilterServicesByAnnotation(def, []string{})
+	removed := FilterServicesByAnnotation(def, []string{}, Options{})
 	if removed != 0 {
 		t.Errorf("expected 0 services removed with empty annotations, got %d", removed)
 	}
 
-	removed = FilterServicesByAnnotation(def, nil)
+	removed = FilterServicesByAnnotation(def, nil, Options{})
 	if removed != 0 {
 		t.Errorf("expected 0 services removed with nil annotations, got %d", removed)
 	}
@@ -1161,8 +1165,8 @@ func TestCrossFileAnnotationFilterPreservesReferencedTypes(t *testing.T) {
 		}
 		PruneAST(parsed[i].def, parsed[i].pkg, keepFQNs)
 
-		sr := FilterServicesByAnnotation(parsed[i].def, annotations)
-		mr := FilterMethodsByAnnotation(parsed[i].def, annotations)
+		sr := FilterServicesByAnnotation(parsed[i].def, annotations, Options{})
+		mr := FilterMethodsByAnnotation(parsed[i].def, annotations, Options{})
 		RemoveEmptyServices(parsed[i].def)
 		if sr > 0 || mr > 0 {
 			RemoveOrphanedDefinitions(parsed[i].def, parsed[i].pkg)
@@ -1250,8 +1254,8 @@ func TestCrossFileServiceRemovalOrphansCommonTypes(t *testing.T) {
 		}
 		PruneAST(parsed[i].def, parsed[i].pkg, keepFQNs)
 
-		sr := FilterServicesByAnnotation(parsed[i].def, annotations)
-		mr := FilterMethodsByAnnotation(parsed[i].def, annotations)
+		sr := FilterServicesByAnnotation(parsed[i].def, annotations, Options{})
+		mr := FilterMethodsByAnnotation(parsed[i].def, annotations, Options{})
 		RemoveEmptyServices(parsed[i].def)
 		if sr > 0 || mr > 0 {
 			RemoveOrphanedDefinitions(parsed[i].def, parsed[i].pkg)
@@ -1321,8 +1325,8 @@ func TestCrossFileCommonFileNoServicesPreserved(t *testing.T) {
 	}
 
 	// Apply annotation filtering — should have no effect
-	sr := FilterServicesByAnnotation(def, []string{"Internal", "HasAnyRole"})
-	mr := FilterMethodsByAnnotation(def, []string{"Internal", "HasAnyRole"})
+	sr := FilterServicesByAnnotation(def, []string{"Internal", "HasAnyRole"}, Options{})
+	mr := FilterMethodsByAnnotation(def, []string{"Internal", "HasAnyRole"}, Options{})
 
 	if sr != 0 {
 		t.Errorf("expected 0 services removed from common.proto, got %d", sr)
@@ -1361,7 +1365,7 @@ func TestFilterMethodsByBracketAnnotation(t *testing.T) {
 		t.Fatalf("parse: %v", err)
 	}
 
-	removed := FilterMethodsByAnnotation(def, []string{"HasAnyRole"})
+	removed := FilterMethodsByAnnotation(def, []string{"HasAnyRole"}, Options{})
 	if removed != 2 {
 		t.Errorf("expected 2 methods removed, got %d", removed)
 	}
@@ -1395,7 +1399,7 @@ func TestFilterServicesByBracketAnnotation(t *testing.T) {
 		},
 	}
 
-	removed := FilterServicesByAnnotation(def, []string{"Internal"})
+	removed := FilterServicesByAnnotation(def, []string{"Internal"}, Options{})
 	if removed != 1 {
 		t.Errorf("expected 1 service removed, got %d", removed)
 	}
@@ -1420,13 +1424,13 @@ func TestGoldenFileBracketService(t *testing.T) {
 		t.Fatalf("parse: %v", err)
 	}
 
-	FilterMethodsByAnnotation(def, []string{"HasAnyRole"})
+	FilterMethodsByAnnotation(def, []string{"HasAnyRole"}, Options{})
 	RemoveOrphanedDefinitions(def, "annotations")
 	ConvertBlockComments(def)
 
 	outputDir := t.TempDir()
 	outputPath := filepath.Join(outputDir, "bracket_service.proto")
-	if err := writer.WriteProtoFile(def, outputPath); err != nil {
+	if err := writer.WriteProtoFile(def, outputPath, config.FormatConfig{}); err != nil {
 		t.Fatalf("write: %v", err)
 	}
 
@@ -1452,7 +1456,7 @@ func TestFilterMethodsMixedAnnotationStyles(t *testing.T) {
 		t.Fatalf("parse: %v", err)
 	}
 
-	removed := FilterMethodsByAnnotation(def, []string{"HasAnyRole"})
+	removed := FilterMethodsByAnnotation(def, []string{"HasAnyRole"}, Options{})
 	if removed != 2 {
 		t.Errorf("expected 2 methods removed (one @HasAnyRole, one [HasAnyRole]), got %d", removed)
 	}
@@ -1480,13 +1484,13 @@ func TestGoldenFileMixedStyles(t *testing.T) {
 		t.Fatalf("parse: %v", err)
 	}
 
-	FilterMethodsByAnnotation(def, []string{"HasAnyRole"})
+	FilterMethodsByAnnotation(def, []string{"HasAnyRole"}, Options{})
 	RemoveOrphanedDefinitions(def, "annotations")
 	ConvertBlockComments(def)
 
 	outputDir := t.TempDir()
 	outputPath := filepath.Join(outputDir, "mixed_styles.proto")
-	if err := writer.WriteProtoFile(def, outputPath); err != nil {
+	if err := writer.WriteProtoFile(def, outputPath, config.FormatConfig{}); err != nil {
 		t.Fatalf("write: %v", err)
 	}
 
@@ -1512,7 +1516,7 @@ func TestIncludeMethodsByAnnotation(t *testing.T) {
 		t.Fatalf("parse: %v", err)
 	}
 
-	removed := IncludeMethodsByAnnotation(def, []string{"Public"})
+	removed := IncludeMethodsByAnnotation(def, []string{"Public"}, Options{})
 	if removed != 1 {
 		t.Errorf("expected 1 method removed (unannotated ListOrders), got %d", removed)
 	}
@@ -1562,7 +1566,7 @@ func TestIncludeServicesByAnnotation(t *testing.T) {
 		},
 	}
 
-	removed := IncludeServicesByAnnotation(def, []string{"Public"})
+	removed := IncludeServicesByAnnotation(def, []string{"Public"}, Options{})
 	if removed != 2 {
 		t.Errorf("expected 2 services removed (InternalService + UnannotatedService), got %d", removed)
 	}
@@ -1598,7 +1602,7 @@ func TestIncludeMethodsByAnnotationNoMatch(t *testing.T) {
 		t.Fatalf("parse: %v", err)
 	}
 
-	removed := IncludeMethodsByAnnotation(def, []string{"NonExistent"})
+	removed := IncludeMethodsByAnnotation(def, []string{"NonExistent"}, Options{})
 	if removed != 3 {
 		t.Errorf("expected 3 methods removed (none match), got %d", removed)
 	}
@@ -1622,14 +1626,14 @@ func TestGoldenFileIncludeService(t *testing.T) {
 		t.Fatalf("parse: %v", err)
 	}
 
-	IncludeMethodsByAnnotation(def, []string{"Public"})
+	IncludeMethodsByAnnotation(def, []string{"Public"}, Options{})
 	RemoveOrphanedDefinitions(def, "annotations")
-	StripAnnotations(def, []string{"Public"})
+	StripAnnotations(def, []string{"Public"}, Options{})
 	ConvertBlockComments(def)
 
 	outputDir := t.TempDir()
 	outputPath := filepath.Join(outputDir, "include_service.proto")
-	if err := writer.WriteProtoFile(def, outputPath); err != nil {
+	if err := writer.WriteProtoFile(def, outputPath, config.FormatConfig{}); err != nil {
 		t.Fatalf("write: %v", err)
 	}
 
@@ -1664,7 +1668,7 @@ func TestSubstituteAnnotations(t *testing.T) {
 		"Internal":   "For internal use only",
 		"Public":     "Available to all users",
 	}
-	count := SubstituteAnnotations(def, subs)
+	count, _ := SubstituteAnnotations(def, subs, Options{})
 	if count != 3 {
 		t.Errorf("expected 3 substitutions, got %d", count)
 	}
@@ -1736,7 +1740,7 @@ func TestSubstituteAnnotationsNoMapping(t *testing.T) {
 		}
 	}
 
-	count := SubstituteAnnotations(def, map[string]string{})
+	count, _ := SubstituteAnnotations(def, map[string]string{}, Options{})
 	if count != 0 {
 		t.Errorf("expected 0 substitutions with empty map, got %d", count)
 	}
@@ -1773,9 +1777,9 @@ func TestSubstituteAnnotationsPartialMapping(t *testing.T) {
 		t.Fatalf("parse: %v", err)
 	}
 
-	count := SubstituteAnnotations(def, map[string]string{
+	count, _ := SubstituteAnnotations(def, map[string]string{
 		"HasAnyRole": "Auth required",
-	})
+	}, Options{})
 	if count != 1 {
 		t.Errorf("expected 1 substitution, got %d", count)
 	}
@@ -1821,11 +1825,11 @@ func TestSubstituteAnnotationsEmptyRemoval(t *testing.T) {
 		t.Fatalf("parse: %v", err)
 	}
 
-	count := SubstituteAnnotations(def, map[string]string{
+	count, _ := SubstituteAnnotations(def, map[string]string{
 		"HasAnyRole": "",
 		"Internal":   "",
 		"Public":     "",
-	})
+	}, Options{})
 	if count != 3 {
 		t.Errorf("expected 3 substitutions, got %d", count)
 	}
@@ -1895,7 +1899,7 @@ func TestSubstituteAnnotationsFullCommentRemoval(t *testing.T) {
 		},
 	}
 
-	count := SubstituteAnnotations(def, map[string]string{"Internal": ""})
+	count, _ := SubstituteAnnotations(def, map[string]string{"Internal": ""}, Options{})
 	if count != 1 {
 		t.Errorf("expected 1 substitution, got %d", count)
 	}
@@ -1915,7 +1919,7 @@ func TestCollectAllAnnotations(t *testing.T) {
 		t.Fatalf("parse: %v", err)
 	}
 
-	result := CollectAllAnnotations(def)
+	result := CollectAllAnnotations(def, Options{})
 
 	expected := []string{"HasAnyRole", "Internal", "Public"}
 	for _, name := range expected {
@@ -1946,7 +1950,7 @@ func TestCollectAllAnnotationsEmpty(t *testing.T) {
 		},
 	}
 
-	result := CollectAllAnnotations(def)
+	result := CollectAllAnnotations(def, Options{})
 	if len(result) != 0 {
 		t.Errorf("expected 0 annotations, got %d: %v", len(result), result)
 	}
@@ -1967,11 +1971,11 @@ func TestGoldenFileSubstitutionReplaced(t *testing.T) {
 		"HasAnyRole": "Requires authentication",
 		"Internal":   "For internal use only",
 		"Public":     "Available to all users",
-	})
+	}, Options{})
 
 	outputDir := t.TempDir()
 	outputPath := filepath.Join(outputDir, "substitution_replaced.proto")
-	if err := writer.WriteProtoFile(def, outputPath); err != nil {
+	if err := writer.WriteProtoFile(def, outputPath, config.FormatConfig{}); err != nil {
 		t.Fatalf("write: %v", err)
 	}
 
@@ -2004,11 +2008,11 @@ func TestGoldenFileSubstitutionRemoved(t *testing.T) {
 		"HasAnyRole": "",
 		"Internal":   "",
 		"Public":     "",
-	})
+	}, Options{})
 
 	outputDir := t.TempDir()
 	outputPath := filepath.Join(outputDir, "substitution_removed.proto")
-	if err := writer.WriteProtoFile(def, outputPath); err != nil {
+	if err := writer.WriteProtoFile(def, outputPath, config.FormatConfig{}); err != nil {
 		t.Fatalf("write: %v", err)
 	}
 
@@ -2038,7 +2042,7 @@ func TestCollectAnnotationLocations(t *testing.T) {
 
 	ConvertBlockComments(def)
 
-	locations := CollectAnnotationLocations(def, "substitution_service.proto")
+	locations := CollectAnnotationLocations(def, "substitution_service.proto", Options{})
 
 	// Expected: 3 annotations at lines 6, 10, 13
 	if len(locations) != 3 {
@@ -2091,7 +2095,7 @@ func TestCollectAnnotationLocationsEmpty(t *testing.T) {
 		},
 	}
 
-	locations := CollectAnnotationLocations(def, "plain.proto")
+	locations := CollectAnnotationLocations(def, "plain.proto", Options{})
 	if len(locations) != 0 {
 		t.Errorf("expected 0 locations for plain proto, got %d: %+v", len(locations), locations)
 	}
@@ -2110,7 +2114,7 @@ func TestCollectAnnotationLocationsNilComment(t *testing.T) {
 		},
 	}
 
-	locations := CollectAnnotationLocations(def, "nocomment.proto")
+	locations := CollectAnnotationLocations(def, "nocomment.proto", Options{})
 	if len(locations) != 0 {
 		t.Errorf("expected 0 locations, got %d", len(locations))
 	}
@@ -2136,9 +2140,9 @@ func TestSubstituteAnnotationsPlaceholder(t *testing.T) {
 		},
 	}
 
-	count := SubstituteAnnotations(def, map[string]string{
+	count, _ := SubstituteAnnotations(def, map[string]string{
 		"Min": "Minimal value is %s",
-	})
+	}, Options{})
 	if count != 1 {
 		t.Errorf("expected 1 substitution, got %d", count)
 	}
@@ -2178,9 +2182,9 @@ func TestSubstituteAnnotationsPlaceholderBracketStyle(t *testing.T) {
 		},
 	}
 
-	count := SubstituteAnnotations(def, map[string]string{
+	count, _ := SubstituteAnnotations(def, map[string]string{
 		"Tag": "Tagged: %s",
-	})
+	}, Options{})
 	if count != 1 {
 		t.Errorf("expected 1 substitution, got %d", count)
 	}
@@ -2214,9 +2218,9 @@ func TestSubstituteAnnotationsPlaceholderComplexArgs(t *testing.T) {
 		},
 	}
 
-	count := SubstituteAnnotations(def, map[string]string{
+	count, _ := SubstituteAnnotations(def, map[string]string{
 		"HasAnyRole": "Requires roles: %s",
-	})
+	}, Options{})
 	if count != 1 {
 		t.Errorf("expected 1 substitution, got %d", count)
 	}
@@ -2250,9 +2254,9 @@ func TestSubstituteAnnotationsNoPlaceholderWithArgs(t *testing.T) {
 		},
 	}
 
-	count := SubstituteAnnotations(def, map[string]string{
+	count, _ := SubstituteAnnotations(def, map[string]string{
 		"Min": "Has minimum constraint",
-	})
+	}, Options{})
 	if count != 1 {
 		t.Errorf("expected 1 substitution, got %d", count)
 	}
@@ -2286,9 +2290,9 @@ func TestSubstituteAnnotationsMultiplePlaceholders(t *testing.T) {
 		},
 	}
 
-	count := SubstituteAnnotations(def, map[string]string{
+	count, _ := SubstituteAnnotations(def, map[string]string{
 		"Range": "Between %s and %s",
-	})
+	}, Options{})
 	if count != 1 {
 		t.Errorf("expected 1 substitution, got %d", count)
 	}
@@ -2321,11 +2325,11 @@ func TestGoldenFilePlaceholderReplaced(t *testing.T) {
 		"HasAnyRole": "Requires roles: %s",
 		"Tag":        "Tagged: %s",
 		"Deprecated": "This method is deprecated",
-	})
+	}, Options{})
 
 	outputDir := t.TempDir()
 	outputPath := filepath.Join(outputDir, "placeholder_replaced.proto")
-	if err := writer.WriteProtoFile(def, outputPath); err != nil {
+	if err := writer.WriteProtoFile(def, outputPath, config.FormatConfig{}); err != nil {
 		t.Fatalf("write: %v", err)
 	}
 
@@ -2363,9 +2367,9 @@ func TestSubstituteAnnotationsPlaceholderNoArgs(t *testing.T) {
 		},
 	}
 
-	count := SubstituteAnnotations(def, map[string]string{
+	count, _ := SubstituteAnnotations(def, map[string]string{
 		"Min": "Minimal value is %s",
-	})
+	}, Options{})
 	if count != 1 {
 		t.Errorf("expected 1 substitution, got %d", count)
 	}
@@ -2403,9 +2407,9 @@ func TestSubstituteAnnotationsPlaceholderEmptyParens(t *testing.T) {
 		},
 	}
 
-	count := SubstituteAnnotations(def, map[string]string{
+	count, _ := SubstituteAnnotations(def, map[string]string{
 		"Min": "Minimal value is %s",
-	})
+	}, Options{})
 	if count != 1 {
 		t.Errorf("expected 1 substitution, got %d", count)
 	}
@@ -2442,9 +2446,9 @@ func TestSubstituteAnnotationsPlaceholderBracketNoArgs(t *testing.T) {
 		},
 	}
 
-	count := SubstituteAnnotations(def, map[string]string{
+	count, _ := SubstituteAnnotations(def, map[string]string{
 		"Tag": "Tagged: %s",
-	})
+	}, Options{})
 	if count != 1 {
 		t.Errorf("expected 1 substitution, got %d", count)
 	}
@@ -2483,7 +2487,7 @@ func TestSubstituteAnnotationsPlaceholderSpecialChars(t *testing.T) {
 	subs := map[string]string{
 		"Format": "Format is %s",
 	}
-	count := SubstituteAnnotations(def, subs)
+	count, _ := SubstituteAnnotations(def, subs, Options{})
 	if count != 1 {
 		t.Errorf("expected 1 substitution, got %d", count)
 	}
@@ -2524,7 +2528,7 @@ func TestFilterFieldsByAnnotationNormalField(t *testing.T) {
 			},
 		},
 	}
-	removed := FilterFieldsByAnnotation(def, []string{"Deprecated"})
+	removed := FilterFieldsByAnnotation(def, []string{"Deprecated"}, Options{})
 	if removed != 1 {
 		t.Errorf("expected 1 field removed, got %d", removed)
 	}
@@ -2559,7 +2563,7 @@ func TestFilterFieldsByAnnotationAtSignSyntax(t *testing.T) {
 			},
 		},
 	}
-	removed := FilterFieldsByAnnotation(def, []string{"Deprecated"})
+	removed := FilterFieldsByAnnotation(def, []string{"Deprecated"}, Options{})
 	if removed != 1 {
 		t.Errorf("expected 1 field removed, got %d", removed)
 	}
@@ -2593,7 +2597,7 @@ func TestFilterFieldsByAnnotationInlineComment(t *testing.T) {
 			},
 		},
 	}
-	removed := FilterFieldsByAnnotation(def, []string{"Deprecated"})
+	removed := FilterFieldsByAnnotation(def, []string{"Deprecated"}, Options{})
 	if removed != 1 {
 		t.Errorf("expected 1 field removed, got %d", removed)
 	}
@@ -2623,7 +2627,7 @@ func TestFilterFieldsByAnnotationMapField(t *testing.T) {
 			},
 		},
 	}
-	removed := FilterFieldsByAnnotation(def, []string{"Deprecated"})
+	removed := FilterFieldsByAnnotation(def, []string{"Deprecated"}, Options{})
 	if removed != 1 {
 		t.Errorf("expected 1 field removed, got %d", removed)
 	}
@@ -2658,7 +2662,7 @@ func TestFilterFieldsByAnnotationOneofField(t *testing.T) {
 			},
 		},
 	}
-	removed := FilterFieldsByAnnotation(def, []string{"Deprecated"})
+	removed := FilterFieldsByAnnotation(def, []string{"Deprecated"}, Options{})
 	if removed != 1 {
 		t.Errorf("expected 1 field removed, got %d", removed)
 	}
@@ -2693,7 +2697,7 @@ func TestFilterFieldsByAnnotationNoMatch(t *testing.T) {
 			},
 		},
 	}
-	removed := FilterFieldsByAnnotation(def, []string{"Deprecated"})
+	removed := FilterFieldsByAnnotation(def, []string{"Deprecated"}, Options{})
 	if removed != 0 {
 		t.Errorf("expected 0 fields removed, got %d", removed)
 	}
@@ -2732,7 +2736,7 @@ func TestFilterFieldsByAnnotationAllFieldsRemoved(t *testing.T) {
 			},
 		},
 	}
-	removed := FilterFieldsByAnnotation(def, []string{"Deprecated"})
+	removed := FilterFieldsByAnnotation(def, []string{"Deprecated"}, Options{})
 	if removed != 3 {
 		t.Errorf("expected 3 fields removed, got %d", removed)
 	}
@@ -2777,11 +2781,11 @@ func TestCombinedIncludeExcludeFiltering(t *testing.T) {
 
 	// Step 1: Include pass — keep only PublicApi services
 	// (service-level only; methods within included services are kept)
-	IncludeServicesByAnnotation(def, []string{"PublicApi"})
+	IncludeServicesByAnnotation(def, []string{"PublicApi"}, Options{})
 
 	// Step 2: Exclude pass — remove Deprecated methods
-	FilterServicesByAnnotation(def, []string{"Deprecated"})
-	FilterMethodsByAnnotation(def, []string{"Deprecated"})
+	FilterServicesByAnnotation(def, []string{"Deprecated"}, Options{})
+	FilterMethodsByAnnotation(def, []string{"Deprecated"}, Options{})
 
 	// PublicService should remain
 	var serviceNames []string
@@ -2841,10 +2845,10 @@ func TestCombinedIncludeExcludeWithFieldFiltering(t *testing.T) {
 	}
 
 	// Include pass (service level)
-	IncludeServicesByAnnotation(def, []string{"PublicApi"})
+	IncludeServicesByAnnotation(def, []string{"PublicApi"}, Options{})
 
 	// Exclude pass (field level)
-	fr := FilterFieldsByAnnotation(def, []string{"Deprecated"})
+	fr := FilterFieldsByAnnotation(def, []string{"Deprecated"}, Options{})
 	if fr != 1 {
 		t.Errorf("expected 1 field removed, got %d", fr)
 	}
@@ -2883,21 +2887,21 @@ func TestGoldenFileCombinedFiltering(t *testing.T) {
 	}
 
 	// Include pass — service level
-	IncludeServicesByAnnotation(def, []string{"PublicApi"})
+	IncludeServicesByAnnotation(def, []string{"PublicApi"}, Options{})
 
 	// Exclude pass — service, method, field level
-	FilterServicesByAnnotation(def, []string{"Deprecated"})
-	FilterMethodsByAnnotation(def, []string{"Deprecated"})
-	FilterFieldsByAnnotation(def, []string{"Deprecated"})
+	FilterServicesByAnnotation(def, []string{"Deprecated"}, Options{})
+	FilterMethodsByAnnotation(def, []string{"Deprecated"}, Options{})
+	FilterFieldsByAnnotation(def, []string{"Deprecated"}, Options{})
 
 	RemoveEmptyServices(def)
 	RemoveOrphanedDefinitions(def, "combined")
-	StripAnnotations(def, []string{"PublicApi"})
+	StripAnnotations(def, []string{"PublicApi"}, Options{})
 	ConvertBlockComments(def)
 
 	outputDir := t.TempDir()
 	outputPath := filepath.Join(outputDir, "combined_filtered.proto")
-	if err := writer.WriteProtoFile(def, outputPath); err != nil {
+	if err := writer.WriteProtoFile(def, outputPath, config.FormatConfig{}); err != nil {
 		t.Fatalf("write: %v", err)
 	}
 
@@ -2943,7 +2947,7 @@ func TestFilterFieldsByAnnotationNestedMessage(t *testing.T) {
 			},
 		},
 	}
-	removed := FilterFieldsByAnnotation(def, []string{"Deprecated"})
+	removed := FilterFieldsByAnnotation(def, []string{"Deprecated"}, Options{})
 	if removed != 1 {
 		t.Errorf("expected 1 field removed from nested message, got %d", removed)
 	}
@@ -2978,9 +2982,9 @@ func TestCombinedSameAnnotationInBothLists(t *testing.T) {
 	}
 
 	// Include pass — service included because it has [PublicApi]
-	IncludeServicesByAnnotation(def, []string{"PublicApi"})
+	IncludeServicesByAnnotation(def, []string{"PublicApi"}, Options{})
 	// Exclude pass — service excluded because it has [PublicApi]
-	FilterServicesByAnnotation(def, []string{"PublicApi"})
+	FilterServicesByAnnotation(def, []string{"PublicApi"}, Options{})
 
 	var serviceCount int
 	proto.Walk(def, proto.WithService(func(s *proto.Service) { serviceCount++ }))
@@ -3013,7 +3017,7 @@ func TestIncludeMessagesByAnnotation(t *testing.T) {
 		},
 	}
 
-	removed := IncludeMessagesByAnnotation(def, []string{"PublishedApi"})
+	removed := IncludeMessagesByAnnotation(def, []string{"PublishedApi"}, Options{})
 	if removed != 1 {
 		t.Errorf("expected 1 removed (UnannotatedMessage), got %d", removed)
 	}
@@ -3048,7 +3052,7 @@ func TestIncludeMessagesByAnnotation_NoAnnotations(t *testing.T) {
 		},
 	}
 
-	removed := IncludeMessagesByAnnotation(def, []string{"PublishedApi"})
+	removed := IncludeMessagesByAnnotation(def, []string{"PublishedApi"}, Options{})
 	if removed != 2 {
 		t.Errorf("expected 2 removed, got %d", removed)
 	}
@@ -3069,7 +3073,7 @@ func TestIncludeMessagesByAnnotation_EmptyList(t *testing.T) {
 		},
 	}
 
-	removed := IncludeMessagesByAnnotation(def, []string{})
+	removed := IncludeMessagesByAnnotation(def, []string{}, Options{})
 	if removed != 0 {
 		t.Errorf("expected 0 removed for empty annotation list, got %d", removed)
 	}
@@ -3093,3 +3097,586 @@ func testdataDir(t *testing.T, sub string) string {
 	}
 	return dir
 }
+
+// parseFixture parses file in the testdata directory dir.
+func parseFixture(t *testing.T, dir, file string) *proto.Proto {
+	t.Helper()
+	def, err := parser.ParseProtoFile(filepath.Join(testdataDir(t, dir), file))
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	return def
+}
+
+// --- Nested Definition Tests ---
+
+// topLevelMessage returns the top-level message with the given name, or nil.
+func topLevelMessage(def *proto.Proto, name string) *proto.Message {
+	for _, elem := range def.Elements {
+		if m, ok := elem.(*proto.Message); ok && m.Name == name {
+			return m
+		}
+	}
+	return nil
+}
+
+func TestSubstituteAnnotationsNested(t *testing.T) {
+	def := parseFixture(t, "nested", filepath.Join("a", "orders.proto"))
+	count, _ := SubstituteAnnotations(def, map[string]string{
+		"Internal":   "Internal use",
+		"HasAnyRole": "Requires %s",
+	}, Options{})
+	if count != 4 {
+		t.Errorf("expected 4 substitutions in nested elements, got %d", count)
+	}
+
+	outer := topLevelMessage(def, "Outer")
+	inner := outer.Elements[0].(*proto.Message)
+	if got := inner.Comment.Lines[0]; got != " Internal use" {
+		t.Errorf("nested message comment: got %q", got)
+	}
+	field := inner.Elements[0].(*proto.NormalField)
+	if got := field.Comment.Lines[0]; got != ` Requires "ADMIN"` {
+		t.Errorf("nested field comment: got %q", got)
+	}
+	oneofField := outer.Elements[1].(*proto.Oneof).Elements[0].(*proto.OneOfField)
+	if got := oneofField.Comment.Lines[0]; got != " Internal use" {
+		t.Errorf("oneof field comment: got %q", got)
+	}
+}
+
+func TestConvertBlockCommentsNested(t *testing.T) {
+	def := parseFixture(t, "nested", filepath.Join("a", "orders.proto"))
+	ConvertBlockComments(def)
+
+	outer := topLevelMessage(def, "Outer")
+	value := outer.Elements[2].(*proto.Enum).Elements[1].(*proto.EnumField)
+	if value.Comment.Cstyle {
+		t.Error("nested enum value comment should be converted to single-line style")
+	}
+	if len(value.Comment.Lines) != 1 || value.Comment.Lines[0] != " @Internal" {
+		t.Errorf("unexpected converted lines: %q", value.Comment.Lines)
+	}
+}
+
+func TestCollectAnnotationLocationsNested(t *testing.T) {
+	def := parseFixture(t, "nested", filepath.Join("a", "orders.proto"))
+	locs := CollectAnnotationLocations(def, "orders.proto", Options{})
+	names := make(map[string]int)
+	for _, loc := range locs {
+		names[loc.Name]++
+	}
+	if names["Internal"] != 3 {
+		t.Errorf("expected 3 nested Internal locations, got %d", names["Internal"])
+	}
+	if names["HasAnyRole"] != 1 {
+		t.Errorf("expected 1 nested HasAnyRole location, got %d", names["HasAnyRole"])
+	}
+
+	all := CollectAllAnnotations(def, Options{})
+	if !all["Internal"] || !all["HasAnyRole"] {
+		t.Errorf("CollectAllAnnotations should include nested annotations, got %v", all)
+	}
+}
+
+func TestIncludeMessagesByAnnotationNested(t *testing.T) {
+	def := parseFixture(t, "nested", filepath.Join("a", "orders.proto"))
+
+	roots := CollectIncludeMessageRoots(def, []string{"Internal"}, Options{})
+	if !roots["nested.orders.Outer"] {
+		t.Errorf("Outer should be a root via its annotated nested message, got %v", roots)
+	}
+
+	removed := IncludeMessagesByAnnotation(def, []string{"Internal"}, Options{})
+	if removed != 1 {
+		t.Errorf("expected 1 removed (Unrelated), got %d", removed)
+	}
+	names := make(map[string]bool)
+	for _, elem := range def.Elements {
+		if m, ok := elem.(*proto.Message); ok {
+			names[m.Name] = true
+		}
+	}
+	if !names["Outer"] {
+		t.Error("Outer should be kept")
+	}
+	if !names["Card"] {
+		t.Error("Card should be kept (referenced from Outer's oneof)")
+	}
+	if names["Unrelated"] {
+		t.Error("Unrelated should be removed")
+	}
+}
+
+// --- Annotation Argument Rule Tests ---
+
+func TestFilterMethodsByAnnotationArgumentRule(t *testing.T) {
+	def := &proto.Proto{
+		Elements: []proto.Visitee{
+			&proto.Service{
+				Name: "OrderService",
+				Elements: []proto.Visitee{
+					&proto.RPC{Name: "AdminOnly", Comment: &proto.Comment{Lines: []string{` @HasAnyRole({"ADMIN"})`}}},
+					&proto.RPC{Name: "Partner", Comment: &proto.Comment{Lines: []string{` @HasAnyRole({"ADMIN", "PARTNER"})`}}},
+					&proto.RPC{Name: "Plain"},
+				},
+			},
+		},
+	}
+
+	removed := FilterMethodsByAnnotation(def, []string{`HasAnyRole contains "PARTNER"`}, Options{})
+	if removed != 1 {
+		t.Errorf("expected 1 method removed, got %d", removed)
+	}
+	svc := def.Elements[0].(*proto.Service)
+	var names []string
+	for _, e := range svc.Elements {
+		names = append(names, e.(*proto.RPC).Name)
+	}
+	if strings.Join(names, ",") != "AdminOnly,Plain" {
+		t.Errorf("remaining methods: %v", names)
+	}
+}
+
+func TestIncludeServicesByAnnotationArgumentRule(t *testing.T) {
+	def := &proto.Proto{
+		Elements: []proto.Visitee{
+			&proto.Service{Name: "PartnerService", Comment: &proto.Comment{Lines: []string{" [Visibility(partner)]"}}},
+			&proto.Service{Name: "InternalService", Comment: &proto.Comment{Lines: []string{" [Visibility(internal)]"}}},
+		},
+	}
+
+	removed := IncludeServicesByAnnotation(def, []string{"Visibility == partner"}, Options{})
+	if removed != 1 {
+		t.Errorf("expected 1 service removed, got %d", removed)
+	}
+	if svc := def.Elements[0].(*proto.Service); svc.Name != "PartnerService" {
+		t.Errorf("expected PartnerService to remain, got %s", svc.Name)
+	}
+
+	StripAnnotations(def, []string{"Visibility == partner"}, Options{})
+	if c := def.Elements[0].(*proto.Service).Comment; c != nil {
+		t.Errorf("include marker should be stripped by rule name, got %q", c.Lines)
+	}
+}
+
+func TestExtractAnnotationArgs(t *testing.T) {
+	c := &proto.Comment{Lines: []string{` @HasAnyRole({"ADMIN", "PARTNER"}) [Visibility(level=partner)] @Internal`}}
+	got := ExtractAnnotationArgs(c, Options{})
+	if len(got) != 3 {
+		t.Fatalf("expected 3 annotations, got %d", len(got))
+	}
+	if vals := got[0].Values(); strings.Join(vals, ",") != "ADMIN,PARTNER" {
+		t.Errorf("HasAnyRole values: %v", vals)
+	}
+	if v, ok := got[1].Get("level"); !ok || v.Text != "partner" {
+		t.Errorf("Visibility level: %+v", v)
+	}
+	if got[2].Name != "Internal" || got[2].Args != nil {
+		t.Errorf("Internal: %+v", got[2])
+	}
+}
+
+// --- Annotation Syntax Tests ---
+
+func TestCustomAnnotationSyntax(t *testing.T) {
+	hash, err := annotation.NewDelimitedSyntax("#", "", "(", ")")
+	if err != nil {
+		t.Fatal(err)
+	}
+	jsdoc, err := annotation.NewSyntax(`@visibility\s+(?P<name>\w+)`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	opts := Options{Syntax: annotation.Syntaxes{hash, jsdoc}}
+
+	def := &proto.Proto{
+		Elements: []proto.Visitee{
+			&proto.Service{
+				Name: "OrderService",
+				Elements: []proto.Visitee{
+					&proto.RPC{Name: "Hidden", Comment: &proto.Comment{Lines: []string{" #internal"}}},
+					&proto.RPC{Name: "Docs", Comment: &proto.Comment{Lines: []string{" @visibility internal"}}},
+					&proto.RPC{Name: "Roles", Comment: &proto.Comment{Position: scanner.Position{Line: 7}, Lines: []string{" Lists orders.", " #roles(ADMIN) @Internal"}}},
+				},
+			},
+		},
+	}
+
+	if got := ExtractAnnotations(def.Elements[0].(*proto.Service).Elements[2].(*proto.RPC).Comment, opts); !reflect.DeepEqual(got, []string{"roles"}) {
+		t.Errorf("@Internal is not an annotation in this syntax, got %v", got)
+	}
+
+	locs := CollectAnnotationLocations(def, "orders.proto", opts)
+	if len(locs) != 3 {
+		t.Fatalf("expected 3 locations, got %+v", locs)
+	}
+	if l := locs[2]; l.Name != "roles" || l.Token != "#roles(ADMIN)" || l.Line != 8 {
+		t.Errorf("unexpected location %+v", l)
+	}
+
+	if removed := FilterMethodsByAnnotation(def, []string{"internal"}, opts); removed != 2 {
+		t.Errorf("expected 2 methods removed, got %d", removed)
+	}
+
+	SubstituteAnnotations(def, map[string]string{"roles": "Requires %s."}, opts)
+	rpc := def.Elements[0].(*proto.Service).Elements[0].(*proto.RPC)
+	if got := rpc.Comment.Lines[1]; got != " Requires ADMIN. @Internal" {
+		t.Errorf("substituted line = %q", got)
+	}
+}
+
+// --- Annotation Wildcard Tests ---
+
+func TestFilterMethodsByAnnotationWildcard(t *testing.T) {
+	def := &proto.Proto{
+		Elements: []proto.Visitee{
+			&proto.Service{
+				Name: "OrderService",
+				Elements: []proto.Visitee{
+					&proto.RPC{Name: "Debug", Comment: &proto.Comment{Lines: []string{" @InternalOnly"}}},
+					&proto.RPC{Name: "Admin", Comment: &proto.Comment{Lines: []string{" @ops.Admin"}}},
+					&proto.RPC{Name: "Get", Comment: &proto.Comment{Lines: []string{" @auth.Public"}}},
+				},
+			},
+		},
+	}
+
+	if removed := FilterMethodsByAnnotation(def, []string{"Internal*", "ops.*"}, Options{}); removed != 2 {
+		t.Errorf("expected 2 methods removed, got %d", removed)
+	}
+	svc := def.Elements[0].(*proto.Service)
+	if len(svc.Elements) != 1 || svc.Elements[0].(*proto.RPC).Name != "Get" {
+		t.Errorf("expected only Get to remain, got %d methods", len(svc.Elements))
+	}
+}
+
+func TestSubstituteAnnotationsWildcard(t *testing.T) {
+	def := &proto.Proto{
+		Elements: []proto.Visitee{
+			&proto.Service{
+				Name: "OrderService",
+				Elements: []proto.Visitee{
+					&proto.RPC{Name: "A", Comment: &proto.Comment{Lines: []string{" @auth.HasAnyRole(ADMIN)"}}},
+					&proto.RPC{Name: "B", Comment: &proto.Comment{Lines: []string{" @auth.Public"}}},
+					&proto.RPC{Name: "C", Comment: &proto.Comment{Lines: []string{" @ops.Deprecated"}}},
+				},
+			},
+		},
+	}
+
+	count, _ := SubstituteAnnotations(def, map[string]string{
+		"auth.*":          "",
+		"auth.HasAnyRole": "Requires %s.",
+	}, Options{})
+	if count != 2 {
+		t.Errorf("expected 2 substitutions, got %d", count)
+	}
+	svc := def.Elements[0].(*proto.Service)
+	if got := svc.Elements[0].(*proto.RPC).Comment.Lines; !reflect.DeepEqual(got, []string{" Requires ADMIN."}) {
+		t.Errorf("specific key should win over wildcard, got %q", got)
+	}
+	if c := svc.Elements[1].(*proto.RPC).Comment; c != nil {
+		t.Errorf("wildcard key should strip @auth.Public, got %q", c.Lines)
+	}
+	if got := svc.Elements[2].(*proto.RPC).Comment.Lines; !reflect.DeepEqual(got, []string{" @ops.Deprecated"}) {
+		t.Errorf("unmatched annotation should be untouched, got %q", got)
+	}
+}
+
+// --- Annotation Alias Tests ---
+
+func TestAnnotationAliasesAndCase(t *testing.T) {
+	tests := []struct {
+		name       string
+		exclude    string
+		aliases    map[string]string
+		ignoreCase bool
+		want       string
+	}{
+		{"exact only", "Internal", nil, false, "GetUser ResetPassword MergeAccounts ImpersonateUser ListUsers"},
+		// With case_insensitive_annotations the config names arrive folded.
+		{"ignore case", "internal", nil, true, "GetUser ImpersonateUser ListUsers"},
+		{"alias", "Internal", map[string]string{"Private": "Internal"}, false, "GetUser ResetPassword MergeAccounts ListUsers"},
+		{"alias ignoring case", "internal", map[string]string{"private": "internal"}, true, "GetUser ListUsers"},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			def := parseFixture(t, "aliases", "users.proto")
+			FilterMethodsByAnnotation(def, []string{tc.exclude}, Options{Aliases: tc.aliases, IgnoreCase: tc.ignoreCase})
+			var rpcs []string
+			for _, name := range strings.Fields(names(def)) {
+				if rpc, ok := strings.CutPrefix(name, "UserService."); ok {
+					rpcs = append(rpcs, rpc)
+				}
+			}
+			if got := strings.Join(rpcs, " "); got != tc.want {
+				t.Errorf("got %q, want %q", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestSubstituteAnnotationsAlias(t *testing.T) {
+	opts := Options{Aliases: map[string]string{"private": "internal"}, IgnoreCase: true}
+	def := parseFixture(t, "aliases", "users.proto")
+
+	count, _ := SubstituteAnnotations(def, map[string]string{"internal": "For internal use."}, opts)
+	if count != 4 {
+		t.Errorf("expected 4 substitutions, got %d", count)
+	}
+	var remaining []string
+	for _, loc := range CollectAnnotationLocations(def, "users.proto", opts) {
+		remaining = append(remaining, loc.Name)
+	}
+	if !reflect.DeepEqual(remaining, []string{"public", "pii"}) {
+		t.Errorf("expected only @Public and @pii to remain, got %v", remaining)
+	}
+
+	uses := CollectAliasUses(parseFixture(t, "aliases", "users.proto"), opts)
+	if !reflect.DeepEqual(uses, map[string]string{"Private": "internal"}) {
+		t.Errorf("CollectAliasUses = %v", uses)
+	}
+}
+
+// --- Template Substitution Tests ---
+
+func TestSubstituteAnnotationsTemplate(t *testing.T) {
+	def := &proto.Proto{
+		Elements: []proto.Visitee{
+			&proto.Package{Name: "orders"},
+			&proto.Service{
+				Name: "OrderService",
+				Elements: []proto.Visitee{
+					&proto.RPC{Name: "GetOrder", Comment: &proto.Comment{Lines: []string{` @HasAnyRole({"ADMIN", "MANAGER"})`}}},
+					&proto.RPC{Name: "Ping", Comment: &proto.Comment{Lines: []string{` @HasAnyRole`}}},
+				},
+			},
+			&proto.Message{
+				Name: "Order",
+				Elements: []proto.Visitee{
+					&proto.NormalField{Field: &proto.Field{Name: "secret", Comment: &proto.Comment{Lines: []string{" @Internal"}}}},
+				},
+			},
+		},
+	}
+
+	count, _ := SubstituteAnnotations(def, map[string]string{
+		"HasAnyRole": `{{if .Args}}Requires one of: {{.Args | join ", "}}{{else}}Requires authentication{{end}}`,
+		"Internal":   `{{.FQN}} is internal ({{.Kind}})`,
+	}, Options{})
+	if count != 3 {
+		t.Errorf("expected 3 substitutions, got %d", count)
+	}
+	svc := def.Elements[1].(*proto.Service)
+	if got := svc.Elements[0].(*proto.RPC).Comment.Lines[0]; got != " Requires one of: ADMIN, MANAGER" {
+		t.Errorf("GetOrder comment = %q", got)
+	}
+	if got := svc.Elements[1].(*proto.RPC).Comment.Lines[0]; got != " Requires authentication" {
+		t.Errorf("Ping comment = %q", got)
+	}
+	field := def.Elements[2].(*proto.Message).Elements[0].(*proto.NormalField)
+	if got := field.Comment.Lines[0]; got != " orders.Order.secret is internal (field)" {
+		t.Errorf("field comment = %q", got)
+	}
+}
+
+func TestSubstituteAnnotationsTemplateError(t *testing.T) {
+	def := &proto.Proto{
+		Elements: []proto.Visitee{
+			&proto.Service{Name: "Svc", Comment: &proto.Comment{
+				Position: scanner.Position{Line: 4, Column: 1},
+				Lines:    []string{" Does things.", " @HasAnyRole"},
+			}},
+		},
+	}
+	text := `{{index .Args 0}}`
+	tmpl, err := annotation.ParseTemplate(text)
+	if err != nil {
+		t.Fatal(err)
+	}
+	count, errs := SubstituteAnnotations(def, map[string]string{"HasAnyRole": text}, Options{Templates: map[string]*template.Template{text: tmpl}})
+	if count != 0 {
+		t.Errorf("failed template should not count, got %d", count)
+	}
+	if len(errs) != 1 {
+		t.Fatalf("expected 1 error, got %v", errs)
+	}
+	if e := errs[0]; e.Line != 5 || e.Column != 4 || e.Token != "@HasAnyRole" || e.FQN != "Svc" {
+		t.Errorf("unexpected error %+v", e)
+	}
+	if got := def.Elements[0].(*proto.Service).Comment.Lines[1]; got != " @HasAnyRole" {
+		t.Errorf("annotation should be left in place, got %q", got)
+	}
+}
+
+// --- Element Kind Substitution Tests ---
+
+func TestSubstituteAnnotationsByKind(t *testing.T) {
+	def := &proto.Proto{
+		Elements: []proto.Visitee{
+			&proto.Service{
+				Name:    "AdminService",
+				Comment: &proto.Comment{Lines: []string{" @Internal"}},
+				Elements: []proto.Visitee{
+					&proto.RPC{Name: "Purge", Comment: &proto.Comment{Lines: []string{" @Internal"}}},
+				},
+			},
+			&proto.Message{
+				Name: "Order",
+				Elements: []proto.Visitee{
+					&proto.NormalField{Field: &proto.Field{Name: "note", InlineComment: &proto.Comment{Lines: []string{" @Internal"}}}},
+				},
+			},
+			&proto.Enum{
+				Name:    "Status",
+				Comment: &proto.Comment{Lines: []string{" @Internal"}},
+			},
+		},
+	}
+
+	count, _ := SubstituteAnnotations(def, map[string]config.Substitution{
+		"Internal": {
+			"service":          "Internal service - not available externally",
+			"field":            "Reserved for internal use",
+			config.DefaultKind: "For internal use only",
+		},
+	}, Options{})
+	if count != 4 {
+		t.Errorf("expected 4 substitutions, got %d", count)
+	}
+	svc := def.Elements[0].(*proto.Service)
+	if got := svc.Comment.Lines[0]; got != " Internal service - not available externally" {
+		t.Errorf("service comment = %q", got)
+	}
+	if got := svc.Elements[0].(*proto.RPC).Comment.Lines[0]; got != " For internal use only" {
+		t.Errorf("rpc comment = %q", got)
+	}
+	field := def.Elements[1].(*proto.Message).Elements[0].(*proto.NormalField)
+	if got := field.InlineComment.Lines[0]; got != " Reserved for internal use" {
+		t.Errorf("field comment = %q", got)
+	}
+
+	// Without a default, other kinds keep the annotation.
+	def = &proto.Proto{Elements: []proto.Visitee{&proto.Message{Name: "M", Comment: &proto.Comment{Lines: []string{" @Internal"}}}}}
+	if n, _ := SubstituteAnnotations(def, map[string]config.Substitution{"Internal": {"field": "x"}}, Options{}); n != 0 {
+		t.Errorf("expected no substitution without a message or default entry, got %d", n)
+	}
+	locs := CollectAnnotationLocations(def, "m.proto", Options{})
+	if len(locs) != 1 || locs[0].Kind != "message" {
+		t.Errorf("expected location with message kind, got %+v", locs)
+	}
+}
+
+// --- Multi-line Substitution Tests ---
+
+func TestSubstituteAnnotationsMultiLine(t *testing.T) {
+	def := &proto.Proto{
+		Elements: []proto.Visitee{
+			&proto.Service{
+				Name: "OrderService",
+				Elements: []proto.Visitee{
+					&proto.RPC{Name: "Cancel", Comment: &proto.Comment{Lines: []string{
+						" Cancels an order.",
+						"   @Audit",
+						" See the runbook.",
+					}}},
+				},
+			},
+		},
+	}
+	count, _ := SubstituteAnnotations(def, map[string]string{
+		"Audit": "Audited operation.\n\nRecorded fields:\n- caller\n  - id\n- order id\n",
+	}, Options{})
+	if count != 1 {
+		t.Fatalf("expected 1 substitution, got %d", count)
+	}
+	got := def.Elements[0].(*proto.Service).Elements[0].(*proto.RPC).Comment.Lines
+	want := []string{
+		" Cancels an order.",
+		"   Audited operation.",
+		"",
+		"   Recorded fields:",
+		"   - caller",
+		"     - id",
+		"   - order id",
+		" See the runbook.",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("comment lines:\n got %q\nwant %q", got, want)
+	}
+	for _, line := range got {
+		if strings.TrimRight(line, " ") != line {
+			t.Errorf("comment line %q should not end in whitespace", line)
+		}
+	}
+}
+
+func TestSubstituteAnnotationsWrap(t *testing.T) {
+	def := &proto.Proto{
+		Elements: []proto.Visitee{
+			&proto.Message{Name: "Order", Comment: &proto.Comment{Lines: []string{
+				" @Doc",
+				" This untouched line is longer than thirty characters.",
+			}}},
+		},
+	}
+	SubstituteAnnotations(def, map[string]string{
+		"Doc": "An order placed by a customer through any sales channel.\n" +
+			"- first item of a list that wraps\n" +
+			"> quoted text that is long enough to wrap\n" +
+			"```\n" +
+			"code inside a fence is never wrapped at all\n" +
+			"```\n" +
+			"| table | rows | are | never | wrapped | either |",
+	}, Options{SubstitutionWrap: 30})
+	got := def.Elements[0].(*proto.Message).Comment.Lines
+	want := []string{
+		" An order placed by a customer",
+		" through any sales channel.",
+		" - first item of a list that",
+		"   wraps",
+		" > quoted text that is long",
+		" > enough to wrap",
+		" ```",
+		" code inside a fence is never wrapped at all",
+		" ```",
+		" | table | rows | are | never | wrapped | either |",
+		" This untouched line is longer than thirty characters.",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("comment lines:\n got %q\nwant %q", got, want)
+	}
+}
+
+func TestWrapComments(t *testing.T) {
+	block := &proto.Comment{Cstyle: true, Lines: []string{" A block comment that is longer than thirty characters."}}
+	def := &proto.Proto{
+		Elements: []proto.Visitee{
+			&proto.Message{Name: "Order", Comment: &proto.Comment{Lines: []string{
+				" An order placed by a customer through any sales channel.",
+				"",
+				" - first item of a list that wraps",
+				" Short line.",
+			}}, Elements: []proto.Visitee{
+				&proto.NormalField{Field: &proto.Field{Name: "id", Comment: block}},
+			}},
+		},
+	}
+	WrapComments(def, 30)
+	got := def.Elements[0].(*proto.Message).Comment.Lines
+	want := []string{
+		" An order placed by a customer",
+		" through any sales channel.",
+		"",
+		" - first item of a list that",
+		"   wraps",
+		" Short line.",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("comment lines:\n got %q\nwant %q", got, want)
+	}
+	if len(block.Lines) != 1 {
+		t.Errorf("block comments should not be wrapped, got %q", block.Lines)
+	}
+}
@@ -0,0 +1,99 @@
+package filter
+
+import (
+	"slices"
+	"sort"
+
+	"github.com/unitedtraders/proto-filter/internal/annotation"
+)
+
+// maxInventoryExamples is the number of distinct argument examples kept per
+// annotation name in an inventory.
+const maxInventoryExamples = 3
+
+// AnnotationSummary describes every occurrence of one annotation name in an
+// input tree.
+type AnnotationSummary struct {
+	Name      string               `json:"name"`
+	Count     int                  `json:"count"`
+	Kinds     []string             `json:"kinds"`              // element kinds, sorted
+	Examples  []string             `json:"examples,omitempty"` // distinct argument texts, in order of appearance
+	Locations []AnnotationLocation `json:"locations"`
+}
+
+// SummarizeAnnotations groups annotation locations by name. Summaries are
+// sorted by name and their locations by file and line.
+func SummarizeAnnotations(locations []AnnotationLocation) []AnnotationSummary {
+	byName := make(map[string]*AnnotationSummary)
+	var names []string
+	for _, loc := range locations {
+		s, ok := byName[loc.Name]
+		if !ok {
+			s = &AnnotationSummary{Name: loc.Name}
+			byName[loc.Name] = s
+			names = append(names, loc.Name)
+		}
+		s.Count++
+		if loc.Kind != "" && !slices.Contains(s.Kinds, loc.Kind) {
+			s.Kinds = append(s.Kinds, loc.Kind)
+		}
+		if loc.Args != "" && len(s.Examples) < maxInventoryExamples && !slices.Contains(s.Examples, loc.Args) {
+			s.Examples = append(s.Examples, loc.Args)
+		}
+		s.Locations = append(s.Locations, loc)
+	}
+	sort.Strings(names)
+	summaries := make([]AnnotationSummary, 0, len(names))
+	for _, name := range names {
+		s := byName[name]
+		sort.Strings(s.Kinds)
+		sort.SliceStable(s.Locations, func(i, j int) bool {
+			if s.Locations[i].File != s.Locations[j].File {
+				return s.Locations[i].File < s.Locations[j].File
+			}
+			return s.Locations[i].Line < s.Locations[j].Line
+		})
+		summaries = append(summaries, *s)
+	}
+	return summaries
+}
+
+// UnusedNames returns the names and patterns that match none of the
+// annotation names seen, for example those collected with
+// CollectAllAnnotations, in their original order.
+func UnusedNames(names []string, seen map[string]bool) []string {
+	var unused []string
+	for _, name := range names {
+		used := false
+		for s := range seen {
+			if annotation.MatchName(name, s) {
+				used = true
+				break
+			}
+		}
+		if !used {
+			unused = append(unused, name)
+		}
+	}
+	return unused
+}
+
+// UnmatchedPatterns returns the include or exclude patterns that match none
+// of fqns, in their original order. Invalid patterns are skipped;
+// ApplyFilter reports them.
+func UnmatchedPatterns(patterns []string, fqns []string) []string {
+	var unmatched []string
+	for _, p := range patterns {
+		used := false
+		for _, fqn := range fqns {
+			if matched, err := matchGlob(fqn, p); err != nil || matched {
+				used = true
+				break
+			}
+		}
+		if !used {
+			unmatched = append(unmatched, p)
+		}
+	}
+	return unmatched
+}
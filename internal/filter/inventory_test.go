@@ -0,0 +1,64 @@
+package filter
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSummarizeAnnotations(t *testing.T) {
+	locations := []AnnotationLocation{
+		{File: "b.proto", Line: 4, Name: "Internal", Kind: "rpc"},
+		{File: "a.proto", Line: 9, Name: "HasAnyRole", Kind: "rpc", Args: `"ADMIN"`},
+		{File: "a.proto", Line: 3, Name: "Internal", Kind: "service"},
+		{File: "a.proto", Line: 12, Name: "HasAnyRole", Kind: "rpc", Args: `"ADMIN"`},
+		{File: "a.proto", Line: 15, Name: "HasAnyRole", Kind: "field", Args: `"USER"`},
+		{File: "a.proto", Line: 18, Name: "HasAnyRole", Kind: "rpc", Args: `"A"`},
+		{File: "a.proto", Line: 21, Name: "HasAnyRole", Kind: "rpc", Args: `"B"`},
+	}
+	got := SummarizeAnnotations(locations)
+	if len(got) != 2 {
+		t.Fatalf("expected 2 summaries, got %d", len(got))
+	}
+
+	roles := got[0]
+	if roles.Name != "HasAnyRole" || roles.Count != 5 {
+		t.Errorf("unexpected summary %s with count %d", roles.Name, roles.Count)
+	}
+	if !reflect.DeepEqual(roles.Kinds, []string{"field", "rpc"}) {
+		t.Errorf("kinds = %v", roles.Kinds)
+	}
+	if !reflect.DeepEqual(roles.Examples, []string{`"ADMIN"`, `"USER"`, `"A"`}) {
+		t.Errorf("examples = %v, want distinct and at most %d", roles.Examples, maxInventoryExamples)
+	}
+
+	internal := got[1]
+	var at []string
+	for _, loc := range internal.Locations {
+		at = append(at, loc.File)
+	}
+	if !reflect.DeepEqual(at, []string{"a.proto", "b.proto"}) || internal.Examples != nil {
+		t.Errorf("unexpected Internal summary: %+v", internal)
+	}
+}
+
+func TestUnusedNames(t *testing.T) {
+	seen := map[string]bool{"HasAnyRole": true, "auth.Admin": true}
+	got := UnusedNames([]string{"Since", "HasAnyRole", "auth.*", "billing.*", "Internal"}, seen)
+	if want := []string{"Since", "billing.*", "Internal"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("UnusedNames() = %v, want %v", got, want)
+	}
+	if got := UnusedNames([]string{"HasAnyRole"}, seen); got != nil {
+		t.Errorf("expected no unused names, got %v", got)
+	}
+}
+
+func TestUnmatchedPatterns(t *testing.T) {
+	fqns := []string{"orders.OrderService", "orders.Order", "billing.Invoice"}
+	got := UnmatchedPatterns([]string{"orders.*", "*.Invoice", "payments.*", "orders.Refund"}, fqns)
+	if want := []string{"payments.*", "orders.Refund"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("UnmatchedPatterns() = %v, want %v", got, want)
+	}
+	if got := UnmatchedPatterns([]string{"orders.Order"}, fqns); got != nil {
+		t.Errorf("expected no unmatched patterns, got %v", got)
+	}
+}
@@ -0,0 +1,302 @@
+package filter
+
+import (
+	"strings"
+
+	"github.com/emicklei/proto"
+
+	"github.com/unitedtraders/proto-filter/internal/annotation"
+	"github.com/unitedtraders/proto-filter/internal/config"
+)
+
+// ElementAnnotations returns the annotations of a definition: those in its
+// leading and inline comments, followed by those derived from its options
+// through opts.OptionAnnotations.
+func ElementAnnotations(v proto.Visitee, opts Options) []annotation.Annotation {
+	switch e := v.(type) {
+	case *proto.Service:
+		return append(ExtractAnnotationArgs(e.Comment, opts), optionAnnotations(optionElements(e.Elements), opts)...)
+	case *proto.RPC:
+		return append(commentPairAnnotations(e.Comment, e.InlineComment, opts), optionAnnotations(optionElements(e.Elements), opts)...)
+	case *proto.Message:
+		return append(ExtractAnnotationArgs(e.Comment, opts), optionAnnotations(optionElements(e.Elements), opts)...)
+	case *proto.Enum:
+		return append(ExtractAnnotationArgs(e.Comment, opts), optionAnnotations(optionElements(e.Elements), opts)...)
+	case *proto.EnumField:
+		return append(commentPairAnnotations(e.Comment, e.InlineComment, opts), optionAnnotations(optionElements(e.Elements), opts)...)
+	case *proto.NormalField:
+		return fieldAnnotations(e.Field, opts)
+	case *proto.MapField:
+		return fieldAnnotations(e.Field, opts)
+	case *proto.OneOfField:
+		return fieldAnnotations(e.Field, opts)
+	}
+	return nil
+}
+
+// CollectOptionAnnotations returns the annotation names of all elements,
+// including those derived from options through opts.OptionAnnotations. It
+// complements CollectAllAnnotations, which only reads comments, and
+// returns an empty map when no options are mapped.
+func CollectOptionAnnotations(def *proto.Proto, opts Options) map[string]bool {
+	result := make(map[string]bool)
+	if len(opts.OptionAnnotations) == 0 {
+		return result
+	}
+	PruneElements(def, "", opts, func(e *Element) bool {
+		for _, a := range e.Annotations {
+			result[a.Name] = true
+		}
+		return true
+	})
+	return result
+}
+
+func fieldAnnotations(f *proto.Field, opts Options) []annotation.Annotation {
+	return append(commentPairAnnotations(f.Comment, f.InlineComment, opts), optionAnnotations(f.Options, opts)...)
+}
+
+func commentPairAnnotations(comment, inlineComment *proto.Comment, opts Options) []annotation.Annotation {
+	return append(ExtractAnnotationArgs(comment, opts), ExtractAnnotationArgs(inlineComment, opts)...)
+}
+
+func optionElements(elements []proto.Visitee) []*proto.Option {
+	var options []*proto.Option
+	for _, elem := range elements {
+		if o, ok := elem.(*proto.Option); ok {
+			options = append(options, o)
+		}
+	}
+	return options
+}
+
+// optionAnnotations returns an annotation for each option selected by
+// opts.OptionAnnotations, so that services, methods, messages, fields and
+// enum values can be selected by options such as `option (myapp.visibility)
+// = INTERNAL;` or `[deprecated = true]` as if they carried a comment
+// annotation. The option value becomes the annotation's argument, so rules
+// such as `Visibility == INTERNAL` apply to mapped options too.
+func optionAnnotations(options []*proto.Option, opts Options) []annotation.Annotation {
+	if len(opts.OptionAnnotations) == 0 {
+		return nil
+	}
+	var annotations []annotation.Annotation
+	for _, o := range options {
+		value := o.Constant.Source
+		for _, m := range opts.OptionAnnotations {
+			if optionName(m.Option) != optionName(o.Name) || m.Value != "" && m.Value != value {
+				continue
+			}
+			annotations = append(annotations, annotation.Annotation{
+				Name: m.Annotation,
+				Raw:  o.Constant.SourceRepresentation(),
+				Args: []annotation.Arg{{Value: annotation.Value{Text: value}}},
+			})
+		}
+	}
+	return annotations
+}
+
+// optionName normalises an option name for comparison, so that
+// `(myapp.visibility)`, `myapp.visibility` and `( myapp.visibility )` are
+// the same option.
+func optionName(name string) string {
+	return strings.NewReplacer("(", "", ")", "", " ", "").Replace(name)
+}
+
+// ConvertAnnotationsToOptions turns annotations into proto options on the
+// element that carries them, using conversions keyed by annotation name or
+// pattern (see annotation.Lookup). Services, methods, messages and enums get
+// `option name = value;` statements; fields and enum values get `[name =
+// value]`. Converted markers are removed from the comments. An enum value
+// that already has an option is left unchanged, since only one option per
+// enum value can be written. Returns the number of options produced.
+func ConvertAnnotationsToOptions(def *proto.Proto, conversions map[string]config.OptionConversion, opts Options) int {
+	if len(conversions) == 0 {
+		return 0
+	}
+	count := 0
+	for _, elem := range def.Elements {
+		switch v := elem.(type) {
+		case *proto.Service:
+			var added int
+			v.Elements, added = addStatementOptions(v.Elements, convertComments(conversions, opts, false, 0, &v.Comment))
+			count += added
+			for _, e := range v.Elements {
+				if rpc, ok := e.(*proto.RPC); ok {
+					rpc.Elements, added = addStatementOptions(rpc.Elements, convertComments(conversions, opts, false, 0, &rpc.Comment, &rpc.InlineComment))
+					count += added
+				}
+			}
+		case *proto.Message:
+			count += convertMessageOptions(v, conversions, opts)
+		case *proto.Enum:
+			count += convertEnumOptions(v, conversions, opts)
+		}
+	}
+	return count
+}
+
+func convertMessageOptions(msg *proto.Message, conversions map[string]config.OptionConversion, opts Options) int {
+	var count int
+	msg.Elements, count = addStatementOptions(msg.Elements, convertComments(conversions, opts, false, 0, &msg.Comment))
+	for _, elem := range msg.Elements {
+		switch f := elem.(type) {
+		case *proto.NormalField:
+			count += convertFieldOptions(f.Field, conversions, opts)
+		case *proto.MapField:
+			count += convertFieldOptions(f.Field, conversions, opts)
+		case *proto.Oneof:
+			for _, oElem := range f.Elements {
+				if of, ok := oElem.(*proto.OneOfField); ok {
+					count += convertFieldOptions(of.Field, conversions, opts)
+				}
+			}
+		case *proto.Message:
+			count += convertMessageOptions(f, conversions, opts)
+		case *proto.Enum:
+			count += convertEnumOptions(f, conversions, opts)
+		}
+	}
+	return count
+}
+
+func convertEnumOptions(enum *proto.Enum, conversions map[string]config.OptionConversion, opts Options) int {
+	var count int
+	enum.Elements, count = addStatementOptions(enum.Elements, convertComments(conversions, opts, false, 0, &enum.Comment))
+	for _, elem := range enum.Elements {
+		ef, ok := elem.(*proto.EnumField)
+		if !ok || ef.ValueOption != nil {
+			continue
+		}
+		options := convertComments(conversions, opts, true, 1, &ef.Comment, &ef.InlineComment)
+		for _, o := range options {
+			ef.ValueOption = o
+			ef.Elements = append(ef.Elements, o)
+		}
+		count += len(options)
+	}
+	return count
+}
+
+func convertFieldOptions(f *proto.Field, conversions map[string]config.OptionConversion, opts Options) int {
+	count := 0
+	for _, o := range convertComments(conversions, opts, true, 0, &f.Comment, &f.InlineComment) {
+		if !hasOption(f.Options, o) {
+			f.Options = append(f.Options, o)
+			count++
+		}
+	}
+	return count
+}
+
+// convertComments returns the options for the convertible annotations in
+// the comments and strips the converted tokens from them; tokens that were
+// not converted stay, even if they share a name with one that was. With
+// limit > 0, no annotation is converted once limit options have been
+// produced.
+func convertComments(conversions map[string]config.OptionConversion, opts Options, embedded bool, limit int, comments ...**proto.Comment) []*proto.Option {
+	var options []*proto.Option
+	for _, cp := range comments {
+		c := *cp
+		if c == nil {
+			continue
+		}
+		var lines []string
+		for _, line := range c.Lines {
+			stripped := false
+			newLine := opts.syntax().ReplaceAll(line, func(m annotation.Match) string {
+				name := opts.canonicalName(m.Name)
+				conv, ok := annotation.Lookup(conversions, name)
+				if !ok || limit > 0 && len(options) >= limit {
+					return m.Token
+				}
+				produced := conversionOptions(conv, annotation.New(name, m.Args), embedded)
+				if len(produced) == 0 || limit > 0 && len(options)+len(produced) > limit {
+					return m.Token
+				}
+				options = append(options, produced...)
+				stripped = true
+				return ""
+			})
+			if !stripped {
+				lines = append(lines, line)
+			} else if trimmed := strings.TrimSpace(newLine); trimmed != "" {
+				lines = append(lines, " "+trimmed)
+			}
+		}
+		if len(lines) == 0 {
+			*cp = nil
+		} else {
+			c.Lines = lines
+		}
+	}
+	return options
+}
+
+// conversionOptions builds the options for one annotation. A `%s` in the
+// value yields one option per argument; without arguments there is nothing
+// to substitute and no option is produced.
+func conversionOptions(conv config.OptionConversion, a annotation.Annotation, embedded bool) []*proto.Option {
+	value := conv.Value
+	if value == "" {
+		value = "true"
+	}
+	values := []string{value}
+	if strings.Contains(value, "%s") {
+		values = nil
+		for _, arg := range a.Values() {
+			values = append(values, strings.ReplaceAll(value, "%s", arg))
+		}
+	}
+	options := make([]*proto.Option, 0, len(values))
+	for _, v := range values {
+		options = append(options, &proto.Option{Name: conv.Option, Constant: optionLiteral(v), IsEmbedded: embedded})
+	}
+	return options
+}
+
+// optionLiteral parses an option value as written in a proto file: a quoted
+// string or a bare constant such as true, 42 or INTERNAL.
+func optionLiteral(s string) proto.Literal {
+	if len(s) >= 2 && (s[0] == '"' || s[0] == '\'') && s[len(s)-1] == s[0] {
+		return proto.Literal{Source: s[1 : len(s)-1], IsString: true, QuoteRune: rune(s[0])}
+	}
+	return proto.Literal{Source: s}
+}
+
+// addStatementOptions adds options to a container's elements after any
+// options it already has, skipping duplicates. It returns the elements and
+// the number of options added.
+func addStatementOptions(elements []proto.Visitee, options []*proto.Option) ([]proto.Visitee, int) {
+	if len(options) == 0 {
+		return elements, 0
+	}
+	existing := optionElements(elements)
+	at := 0
+	for i, elem := range elements {
+		if _, ok := elem.(*proto.Option); ok {
+			at = i + 1
+		}
+	}
+	var added []proto.Visitee
+	for _, o := range options {
+		if !hasOption(existing, o) {
+			added = append(added, o)
+			existing = append(existing, o)
+		}
+	}
+	result := make([]proto.Visitee, 0, len(elements)+len(added))
+	result = append(result, elements[:at]...)
+	result = append(result, added...)
+	return append(result, elements[at:]...), len(added)
+}
+
+func hasOption(options []*proto.Option, o *proto.Option) bool {
+	for _, existing := range options {
+		if optionName(existing.Name) == optionName(o.Name) && existing.Constant.Source == o.Constant.Source {
+			return true
+		}
+	}
+	return false
+}
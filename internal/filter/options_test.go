@@ -0,0 +1,205 @@
+package filter
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/emicklei/proto"
+	"github.com/emicklei/proto-contrib/pkg/protofmt"
+
+	"github.com/unitedtraders/proto-filter/internal/annotation"
+	"github.com/unitedtraders/proto-filter/internal/config"
+)
+
+func TestElementAnnotationsFromOptions(t *testing.T) {
+	opts := Options{OptionAnnotations: []config.OptionAnnotation{
+		{Option: "myapp.visibility", Annotation: "Visibility"},
+		{Option: "deprecated", Value: "true", Annotation: "Deprecated"},
+	}}
+	def := parseFixture(t, "options", "orders.proto")
+
+	svc := def.Elements[2].(*proto.Service)
+	got := ElementAnnotations(svc, opts)
+	if len(got) != 1 || got[0].Name != "Visibility" || got[0].Values()[0] != "INTERNAL" {
+		t.Errorf("service annotations = %+v", got)
+	}
+	if !annotation.MustParseRule("Visibility == INTERNAL").Matches(got[0]) {
+		t.Error("rules should apply to option values")
+	}
+	if got := ElementAnnotations(def.Elements[3].(*proto.Service).Elements[1], opts); len(got) != 1 || got[0].Name != "Deprecated" {
+		t.Errorf("rpc annotations = %+v", got)
+	}
+}
+
+func TestApplyAnnotationFiltersWithOptions(t *testing.T) {
+	opts := Options{OptionAnnotations: []config.OptionAnnotation{
+		{Option: "(myapp.visibility)", Value: "INTERNAL", Annotation: "Internal"},
+		{Option: "deprecated", Value: "true", Annotation: "Deprecated"},
+	}}
+	def := parseFixture(t, "options", "orders.proto")
+
+	stats, err := ApplyAnnotationFilters(def, "opts", config.AnnotationConfig{Exclude: []string{"Internal", "Deprecated"}}, opts)
+	if err != nil {
+		t.Fatalf("ApplyAnnotationFilters: %v", err)
+	}
+	if stats.Services != 1 || stats.Methods != 2 || stats.Fields != 1 {
+		t.Errorf("unexpected stats %+v", stats)
+	}
+	want := "OrderService.GetOrder GetOrderRequest GetOrderRequest.id Order Order.id Order.status Status Status.STATUS_UNSPECIFIED Status.STATUS_OPEN Status.STATUS_HELD"
+	if got := names(def); got != want {
+		t.Errorf("got  %q\nwant %q", got, want)
+	}
+
+	expr, err := annotation.ParseExpr("!Internal")
+	if err != nil {
+		t.Fatal(err)
+	}
+	FilterByExpression(def, "opts", expr, opts)
+	if got := names(def); strings.Contains(got, "STATUS_HELD") {
+		t.Errorf("enum value option should be read by expressions, got %q", got)
+	}
+}
+
+func TestOptionAnnotationsDisabled(t *testing.T) {
+	def := parseFixture(t, "options", "orders.proto")
+	removed := FilterMethodsByAnnotation(def, []string{"Deprecated", "Internal"}, Options{})
+	if removed != 0 {
+		t.Errorf("options should be ignored without a mapping, removed %d", removed)
+	}
+}
+
+const conversionTestSource = `syntax = "proto3";
+
+package conv;
+
+service OrderService {
+  // Returns an order.
+  // @HasAnyRole({"ADMIN", "MANAGER"})
+  rpc GetOrder(GetOrderRequest) returns (Order);
+
+  // @Deprecated
+  rpc GetOrderV1(GetOrderRequest) returns (Order);
+}
+
+message GetOrderRequest {
+  string id = 1;
+}
+
+// @Deprecated
+message Order {
+  string id = 1;
+  // Legacy identifier.
+  // @Deprecated
+  string legacy_code = 2;
+  Status status = 3; // @Deprecated
+}
+
+enum Status {
+  STATUS_UNSPECIFIED = 0;
+  STATUS_HELD = 1; // @Deprecated @Internal
+}
+`
+
+func TestConvertAnnotationsToOptions(t *testing.T) {
+	def, err := proto.NewParser(strings.NewReader(conversionTestSource)).Parse()
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+
+	count := ConvertAnnotationsToOptions(def, map[string]config.OptionConversion{
+		"Deprecated": {Option: "deprecated"},
+		"Internal":   {Option: "(myapp.visibility)", Value: "INTERNAL"},
+		"HasAnyRole": {Option: "(myapp.auth).roles", Value: `"%s"`},
+	}, Options{})
+	if count != 7 {
+		t.Errorf("expected 7 options, got %d", count)
+	}
+
+	var buf strings.Builder
+	protofmt.NewFormatter(&buf, "  ").Format(def)
+	out := buf.String()
+	for _, want := range []string{
+		"// Returns an order.\n",
+		`option (myapp.auth).roles = "ADMIN";`,
+		`option (myapp.auth).roles = "MANAGER";`,
+		"option deprecated = true;",
+		"legacy_code = 2 [deprecated = true];",
+		"status      = 3 [deprecated = true];",
+		"STATUS_HELD        = 1 [deprecated = true]; // @Internal",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("output should contain %q:\n%s", want, out)
+		}
+	}
+	if strings.Contains(out, "@Deprecated") || strings.Contains(out, "@HasAnyRole") {
+		t.Errorf("converted markers should be removed:\n%s", out)
+	}
+	if strings.Count(out, "option deprecated = true;") != 2 {
+		t.Errorf("expected deprecated option on GetOrderV1 and Order:\n%s", out)
+	}
+}
+
+func TestConvertAnnotationsToOptionsSkipsDuplicates(t *testing.T) {
+	def := &proto.Proto{
+		Elements: []proto.Visitee{
+			&proto.Message{
+				Name:    "Order",
+				Comment: &proto.Comment{Lines: []string{" @Deprecated"}},
+				Elements: []proto.Visitee{
+					&proto.Option{Name: "deprecated", Constant: proto.Literal{Source: "true"}},
+				},
+			},
+		},
+	}
+	count := ConvertAnnotationsToOptions(def, map[string]config.OptionConversion{"Deprecated": {Option: "deprecated"}}, Options{})
+	if count != 0 {
+		t.Errorf("a duplicate option should not be counted, got %d", count)
+	}
+	msg := def.Elements[0].(*proto.Message)
+	if len(msg.Elements) != 1 {
+		t.Errorf("expected the existing option to be kept alone, got %d elements", len(msg.Elements))
+	}
+	if msg.Comment != nil {
+		t.Errorf("marker should still be removed, got %q", msg.Comment.Lines)
+	}
+}
+
+func TestConvertAnnotationsToOptionsStripsOnlyConvertedTokens(t *testing.T) {
+	field := &proto.NormalField{Field: &proto.Field{
+		Name:          "code",
+		Comment:       &proto.Comment{Lines: []string{" @HasAnyRole @HasAnyRole(ADMIN)"}},
+		InlineComment: &proto.Comment{Lines: []string{" @Deprecated"}},
+		Options:       []*proto.Option{{Name: "deprecated", Constant: proto.Literal{Source: "true"}}},
+	}}
+	def := &proto.Proto{Elements: []proto.Visitee{&proto.Message{Name: "Order", Elements: []proto.Visitee{field}}}}
+
+	count := ConvertAnnotationsToOptions(def, map[string]config.OptionConversion{
+		"Deprecated": {Option: "deprecated"},
+		"HasAnyRole": {Option: "(myapp.auth).roles", Value: `"%s"`},
+	}, Options{})
+	if count != 1 {
+		t.Errorf("expected 1 option added, got %d", count)
+	}
+	if got := field.Comment.Lines; !reflect.DeepEqual(got, []string{" @HasAnyRole"}) {
+		t.Errorf("only the converted token should be stripped, got %q", got)
+	}
+	if field.InlineComment != nil {
+		t.Errorf("a marker for an existing option should be removed, got %q", field.InlineComment.Lines)
+	}
+}
+
+func TestCollectOptionAnnotations(t *testing.T) {
+	def := parseFixture(t, "options", "orders.proto")
+	if got := CollectOptionAnnotations(def, Options{}); len(got) != 0 {
+		t.Errorf("expected no names without option annotations, got %v", got)
+	}
+
+	opts := Options{OptionAnnotations: []config.OptionAnnotation{
+		{Option: "(myapp.visibility)", Value: "INTERNAL", Annotation: "Internal"},
+	}}
+	got := CollectOptionAnnotations(def, opts)
+	if !got["Internal"] {
+		t.Errorf("expected Internal from options, got %v", got)
+	}
+}
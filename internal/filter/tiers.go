@@ -0,0 +1,91 @@
+package filter
+
+import (
+	"github.com/emicklei/proto"
+
+	"github.com/unitedtraders/proto-filter/internal/annotation"
+	"github.com/unitedtraders/proto-filter/internal/config"
+)
+
+// tierRanks maps annotation names, or name patterns such as `partner.*`,
+// to the rank of the tier they mark.
+type tierRanks map[string]int
+
+func newTierRanks(levels []config.TierLevel) tierRanks {
+	ranks := make(tierRanks)
+	for i, level := range levels {
+		for _, name := range level.Annotations {
+			ranks[name] = i
+		}
+	}
+	return ranks
+}
+
+// rank returns the most restrictive tier marked by the annotations, or
+// false if none of them is a tier annotation.
+func (t tierRanks) rank(annotations []annotation.Annotation) (int, bool) {
+	best, found := 0, false
+	for _, a := range annotations {
+		if r, ok := annotation.Lookup(t, a.Name); ok && (!found || r > best) {
+			best, found = r, true
+		}
+	}
+	return best, found
+}
+
+// FilterByTier keeps the elements visible at the selected tier. Tiers are
+// ordered from most to least public; an element is visible when its tier
+// is at or below the selected one. An element's tier comes from its own
+// tier annotations (the most restrictive wins):
+//
+//   - A method without one takes its service's tier, or the default tier.
+//   - Services are kept while at least one method survives.
+//   - Fields, enum values and nested types without one stay with their parent.
+//   - Top-level messages and enums with one are kept when visible and
+//     otherwise removed, together with the fields and RPCs that use them,
+//     so a more restricted type never leaks through a reference.
+//   - Top-level messages and enums without one follow the definitions that
+//     reference them; unreferenced ones take the default tier.
+func FilterByTier(def *proto.Proto, pkg string, tiers config.TierConfig, selected string, opts Options) PruneStats {
+	ranks := newTierRanks(tiers.Levels)
+	limit := tiers.Rank(selected)
+	defaultRank := tiers.Rank(tiers.Default)
+
+	referenced := CollectReferencedTypes(def, pkg)
+	roots := make(map[string]bool)
+	stats := PruneElements(def, pkg, opts, func(e *Element) bool {
+		switch {
+		case e.Kind == KindService:
+			return true
+		case e.Kind == KindRPC:
+			r, ok := ranks.rank(e.Annotations)
+			if !ok {
+				r, ok = ranks.rank(e.Parent.Annotations)
+			}
+			if !ok {
+				r = defaultRank
+			}
+			return r <= limit
+		case e.Parent == nil:
+			r, ok := ranks.rank(e.Annotations)
+			if ok && r > limit {
+				return false
+			}
+			if !ok {
+				if referenced[e.FQN] {
+					return true
+				}
+				r = defaultRank
+			}
+			if r <= limit {
+				roots[e.FQN] = true
+			}
+			return true
+		default:
+			r, ok := ranks.rank(e.Annotations)
+			return !ok || r <= limit
+		}
+	})
+	stats.Orphans = RemoveOrphanedDefinitions(def, pkg, roots)
+	return stats
+}
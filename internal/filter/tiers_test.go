@@ -0,0 +1,99 @@
+package filter
+
+import (
+	"testing"
+
+	"github.com/emicklei/proto"
+
+	"github.com/unitedtraders/proto-filter/internal/config"
+)
+
+var testTiers = config.TierConfig{
+	Levels: []config.TierLevel{
+		{Name: "public", Annotations: []string{"Public"}},
+		{Name: "partner", Annotations: []string{"Partner"}},
+		{Name: "internal", Annotations: []string{"Internal"}},
+		{Name: "restricted", Annotations: []string{"Restricted"}},
+	},
+	Default: "internal",
+}
+
+func TestFilterByTier(t *testing.T) {
+	tests := []struct {
+		tier string
+		want string
+	}{
+		{"public", "AccountService.Ping GetRequest Account Account.id Region Region.REGION_EU"},
+		{"partner", "AccountService.Get AccountService.Ping GetRequest Account Account.id Account.credit_line CreditLine CreditLine.limit Region Region.REGION_EU"},
+		{"internal", "AccountService.Get AccountService.Ping OpsService.Reindex GetRequest AuditRequest Account Account.id Account.risk_score Account.credit_line CreditLine CreditLine.limit Region Region.REGION_EU Region.REGION_TEST"},
+		{"restricted", "AccountService.Get AccountService.Audit AccountService.Ping OpsService.Reindex GetRequest AuditRequest Account Account.id Account.risk_score Account.credit_line CreditLine CreditLine.limit Region Region.REGION_EU Region.REGION_TEST"},
+	}
+	for _, tc := range tests {
+		t.Run(tc.tier, func(t *testing.T) {
+			def := parseFixture(t, "tiers", "accounts.proto")
+			FilterByTier(def, "tiers", testTiers, tc.tier, Options{})
+			if got := names(def); got != tc.want {
+				t.Errorf("remaining:\n got: %s\nwant: %s", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestFilterByTierDefaultTier(t *testing.T) {
+	tiers := testTiers
+	tiers.Default = "public"
+	def := parseFixture(t, "tiers", "accounts.proto")
+	stats := FilterByTier(def, "tiers", tiers, "public", Options{})
+
+	want := "AccountService.Ping OpsService.Reindex GetRequest AuditRequest Account Account.id Region Region.REGION_EU"
+	if got := names(def); got != want {
+		t.Errorf("remaining:\n got: %s\nwant: %s", got, want)
+	}
+	if stats.Methods != 2 || stats.Messages != 1 || stats.Fields != 2 || stats.EnumValues != 1 {
+		t.Errorf("unexpected stats: %+v", stats)
+	}
+}
+
+func TestFilterByTierRemovesReferencedHigherTierTypes(t *testing.T) {
+	def := parseFixture(t, "tiers", "accounts.proto")
+	FilterByTier(def, "tiers", testTiers, "public", Options{})
+
+	if topLevelMessage(def, "CreditLine") != nil {
+		t.Fatal("@Partner message referenced by a public message should not be in public output")
+	}
+	for _, elem := range topLevelMessage(def, "Account").Elements {
+		if f, ok := elem.(*proto.NormalField); ok && f.Type == "CreditLine" {
+			t.Errorf("field %s still refers to the removed CreditLine", f.Name)
+		}
+	}
+}
+
+func TestFilterByTierPatterns(t *testing.T) {
+	tiers := config.TierConfig{
+		Levels: []config.TierLevel{
+			{Name: "public", Annotations: []string{"visibility.*", "visibility.Public"}},
+			{Name: "internal", Annotations: []string{"visibility.Internal*"}},
+		},
+		Default: "public",
+	}
+	def := &proto.Proto{
+		Elements: []proto.Visitee{
+			&proto.Service{
+				Name: "Svc",
+				Elements: []proto.Visitee{
+					&proto.RPC{Name: "Open", Comment: &proto.Comment{Lines: []string{" @visibility.Public"}}},
+					&proto.RPC{Name: "Ops", Comment: &proto.Comment{Lines: []string{" @visibility.InternalOps"}}},
+					&proto.RPC{Name: "Docs", Comment: &proto.Comment{Lines: []string{" @visibility.Docs"}}},
+				},
+			},
+		},
+	}
+
+	stats := FilterByTier(def, "", tiers, "public", Options{})
+	if stats.Methods != 1 {
+		t.Errorf("expected 1 method removed, got %d", stats.Methods)
+	}
+	if got := names(def); got != "Svc.Open Svc.Docs" {
+		t.Errorf("got %q", got)
+	}
+}
@@ -0,0 +1,68 @@
+package filter
+
+import (
+	"github.com/emicklei/proto"
+
+	"github.com/unitedtraders/proto-filter/internal/annotation"
+)
+
+// SinceAnnotations and UntilAnnotations name the annotations that bound an
+// element's API version range. @Since("2.3") makes an element available
+// from 2.3 on; @Until("3.0") and @Removed("3.0") make it unavailable from
+// 3.0 on.
+var (
+	SinceAnnotations = []string{"Since"}
+	UntilAnnotations = []string{"Until", "Removed"}
+)
+
+// InVersion reports whether an element with the given annotations is part
+// of the API at version target. Version markers whose argument is not a
+// valid version are ignored.
+func InVersion(annotations []annotation.Annotation, target annotation.Version, opts Options) bool {
+	for _, a := range annotations {
+		v, ok := annotationVersion(a)
+		if !ok {
+			continue
+		}
+		if containsName(SinceAnnotations, a.Name, opts) && target.Compare(v) < 0 {
+			return false
+		}
+		if containsName(UntilAnnotations, a.Name, opts) && target.Compare(v) >= 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// FilterByVersion removes services, RPCs, messages, fields, enums and enum
+// values whose @Since/@Until/@Removed range does not contain target, then
+// removes types left orphaned by the removal.
+func FilterByVersion(def *proto.Proto, pkg string, target annotation.Version, opts Options) PruneStats {
+	stats := PruneElements(def, pkg, opts, func(e *Element) bool {
+		return InVersion(e.Annotations, target, opts)
+	})
+	if stats.Removed() {
+		stats.Orphans = RemoveOrphanedDefinitions(def, pkg)
+	}
+	return stats
+}
+
+func annotationVersion(a annotation.Annotation) (annotation.Version, bool) {
+	values := a.Values()
+	if len(values) == 0 {
+		return annotation.Version{}, false
+	}
+	v, err := annotation.ParseVersion(values[0])
+	return v, err == nil
+}
+
+// containsName reports whether name matches any of names (see
+// annotation.MatchName), folded by opts like the names read from comments.
+func containsName(names []string, name string, opts Options) bool {
+	for _, n := range names {
+		if annotation.MatchName(opts.fold(n), name) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,54 @@
+package filter
+
+import (
+	"testing"
+
+	"github.com/unitedtraders/proto-filter/internal/annotation"
+)
+
+func TestFilterByVersion(t *testing.T) {
+	tests := []struct {
+		version string
+		want    string
+	}{
+		{"1.0", "OrderService.GetOrder OrderService.GetOrderV1 GetOrderRequest Order Order.id"},
+		{"2.2", "OrderService.GetOrder OrderService.GetNewThing GetOrderRequest Order Order.id Order.note Order.nt NewThing NewThing.id"},
+		{"2.5", "OrderService.GetOrder OrderService.ListOrders OrderService.GetNewThing GetOrderRequest ListOrdersRequest Order Order.id Order.nt NewThing NewThing.id"},
+		{"3.0.0-beta", "OrderService.GetOrder OrderService.ListOrders OrderService.GetNewThing GetOrderRequest ListOrdersRequest Order Order.id Order.tags Order.nt NewThing NewThing.id"},
+	}
+	for _, tc := range tests {
+		t.Run(tc.version, func(t *testing.T) {
+			def := parseFixture(t, "versions", "orders.proto")
+			target, err := annotation.ParseVersion(tc.version)
+			if err != nil {
+				t.Fatalf("ParseVersion: %v", err)
+			}
+			FilterByVersion(def, "versions", target, Options{})
+			if got := names(def); got != tc.want {
+				t.Errorf("remaining:\n got: %s\nwant: %s", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestFilterByVersionDropsReferencesToRemovedTypes(t *testing.T) {
+	def := parseFixture(t, "versions", "orders.proto")
+	target, _ := annotation.ParseVersion("2.0")
+	stats := FilterByVersion(def, "versions", target, Options{})
+	if got, want := names(def), "OrderService.GetOrder GetOrderRequest Order Order.id"; got != want {
+		t.Errorf("remaining:\n got: %s\nwant: %s", got, want)
+	}
+	// GetOrderV1 and ListOrders by version, GetNewThing for returning NewThing;
+	// tags and note by version, nt for referring to NewThing.
+	if stats.Messages != 1 || stats.Methods != 3 || stats.Fields != 3 {
+		t.Errorf("stats = %+v", stats)
+	}
+}
+
+func TestInVersionIgnoresMalformedMarkers(t *testing.T) {
+	target, _ := annotation.ParseVersion("2.0")
+	anns := []annotation.Annotation{annotation.New("Since", `"next"`), annotation.New("Since", "")}
+	if !InVersion(anns, target, Options{}) {
+		t.Error("malformed version markers should be ignored")
+	}
+}
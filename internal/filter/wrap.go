@@ -0,0 +1,131 @@
+package filter
+
+import (
+	"regexp"
+	"strings"
+
+	"github.com/emicklei/proto"
+)
+
+// listMarker matches a Markdown list item or block quote marker together
+// with its leading indentation and the spaces after it.
+var listMarker = regexp.MustCompile(`^(\s*)([-*+]|\d+[.)]|>)\s+`)
+
+// expandLines turns a line whose substitutions produced newlines into
+// separate comment lines. Every line is given the indentation the
+// annotation's line had, and keeps its own indentation on top of that so
+// nested Markdown lists survive. Blank lines inside the text are kept as
+// empty comment lines; leading and trailing ones are dropped.
+func expandLines(original, substituted string) []string {
+	indent := leadingSpace(strings.TrimPrefix(original, " "))
+	parts := strings.Split(substituted, "\n")
+	for len(parts) > 0 && strings.TrimSpace(parts[0]) == "" {
+		parts = parts[1:]
+	}
+	for len(parts) > 0 && strings.TrimSpace(parts[len(parts)-1]) == "" {
+		parts = parts[:len(parts)-1]
+	}
+	lines := make([]string, 0, len(parts))
+	for i, part := range parts {
+		part = strings.TrimRight(part, " \t\r")
+		switch {
+		case part == "":
+			lines = append(lines, "")
+		case i == 0:
+			lines = append(lines, indent+strings.TrimSpace(part))
+		default:
+			lines = append(lines, indent+part)
+		}
+	}
+	return lines
+}
+
+// wrapLines wraps comment text lines longer than width at spaces. It is
+// Markdown-aware: fenced code blocks, headings and table rows are never
+// wrapped, and continuation lines of list items and block quotes are
+// indented under the item's text (or repeat the quote marker) so the
+// structure is unchanged.
+func wrapLines(lines []string, width int) []string {
+	if width <= 0 {
+		return lines
+	}
+	var wrapped []string
+	inFence := false
+	for _, line := range lines {
+		trimmed := strings.TrimSpace(line)
+		if strings.HasPrefix(trimmed, "```") || strings.HasPrefix(trimmed, "~~~") {
+			inFence = !inFence
+			wrapped = append(wrapped, line)
+			continue
+		}
+		if inFence || len(line) <= width || strings.HasPrefix(trimmed, "#") || strings.HasPrefix(trimmed, "|") {
+			wrapped = append(wrapped, line)
+			continue
+		}
+		wrapped = append(wrapped, wrapLine(line, width)...)
+	}
+	return wrapped
+}
+
+// wrapLine wraps a single line. Words longer than the width are kept whole.
+func wrapLine(line string, width int) []string {
+	first := leadingSpace(line)
+	rest := first
+	if m := listMarker.FindString(line); m != "" {
+		first = m
+		rest = strings.Repeat(" ", len(m))
+		if strings.TrimSpace(m) == ">" {
+			rest = m
+		}
+	}
+	var lines []string
+	current, empty := first, true
+	for _, w := range strings.Fields(line[len(first):]) {
+		if !empty && len(current)+1+len(w) > width {
+			lines = append(lines, current)
+			current, empty = rest, true
+		}
+		if !empty {
+			current += " "
+		}
+		current += w
+		empty = false
+	}
+	return append(lines, current)
+}
+
+func leadingSpace(s string) string {
+	return s[:len(s)-len(strings.TrimLeft(s, " \t"))]
+}
+
+// WrapComments wraps the lines of single-line comments that are longer
+// than width characters of comment text, in the same Markdown-aware way
+// as substitution text. Block comments are left alone; 0 disables
+// wrapping.
+func WrapComments(def *proto.Proto, width int) {
+	if width <= 0 {
+		return
+	}
+	walkComments(def, func(cp **proto.Comment) {
+		c := *cp
+		if c == nil || c.Cstyle {
+			return
+		}
+		text := make([]string, len(c.Lines))
+		for i, line := range c.Lines {
+			text[i] = strings.TrimPrefix(line, " ")
+		}
+		wrapped := wrapLines(text, width)
+		if len(wrapped) == len(text) {
+			return
+		}
+		lines := make([]string, len(wrapped))
+		for i, line := range wrapped {
+			if line != "" {
+				line = " " + line
+			}
+			lines[i] = line
+		}
+		c.Lines = lines
+	})
+}
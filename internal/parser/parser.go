@@ -1,9 +1,14 @@
 package parser
 
 import (
+	"bytes"
+	"errors"
+	"fmt"
 	"io/fs"
 	"os"
 	"path/filepath"
+	"regexp"
+	"strconv"
 	"strings"
 
 	"github.com/emicklei/proto"
@@ -32,22 +37,91 @@ func DiscoverProtoFiles(inputDir string) ([]string, error) {
 	return files, err
 }
 
-// ParseProtoFile parses a single .proto file and returns its AST.
+// ParseProtoFile parses a single .proto file and returns its AST. Syntax
+// errors are returned as *Error.
 func ParseProtoFile(path string) (*proto.Proto, error) {
-	f, err := os.Open(path)
+	content, err := os.ReadFile(path)
 	if err != nil {
 		return nil, err
 	}
-	defer f.Close()
 
-	parser := proto.NewParser(f)
+	parser := proto.NewParser(bytes.NewReader(content))
 	definition, err := parser.Parse()
 	if err != nil {
-		return nil, err
+		return nil, newError(path, content, err)
 	}
 	return definition, nil
 }
 
+// Error is a syntax error in a proto file.
+type Error struct {
+	File   string
+	Line   int    // 1-based; 0 if unknown
+	Column int    // 1-based; 0 if unknown
+	Msg    string // message without the position
+	Source string // the source line the error is on, if known
+}
+
+func (e *Error) Error() string {
+	if e.Line == 0 {
+		return e.File + ": " + e.Msg
+	}
+	return fmt.Sprintf("%s:%d:%d: %s", e.File, e.Line, e.Column, e.Msg)
+}
+
+// ErrorPosition returns the line and column of a parse error returned by
+// ParseProtoFile or ParseFiles, or zeros if the error carries no position.
+func ErrorPosition(err error) (line, column int) {
+	var e *Error
+	if errors.As(err, &e) {
+		return e.Line, e.Column
+	}
+	return 0, 0
+}
+
+// errorPosition matches the `line:column: ` position that parse errors
+// start with, optionally preceded by a file name.
+var errorPosition = regexp.MustCompile(`^(?:[^:]*:)?(\d+):(\d+): `)
+
+func newError(file string, content []byte, err error) *Error {
+	e := &Error{File: file, Msg: err.Error()}
+	m := errorPosition.FindStringSubmatch(e.Msg)
+	if m == nil {
+		return e
+	}
+	e.Line, _ = strconv.Atoi(m[1])
+	e.Column, _ = strconv.Atoi(m[2])
+	e.Msg = e.Msg[len(m[0]):]
+	if lines := strings.Split(string(content), "\n"); e.Line >= 1 && e.Line <= len(lines) {
+		e.Source = strings.TrimRight(lines[e.Line-1], "\r")
+	}
+	return e
+}
+
+// ParseFiles parses the given files, relative to dir, and returns their
+// ASTs in the same order. Parsing continues past files that fail: their
+// AST is nil and an error is returned for each of them, with File set to
+// the relative path. The proto parser stops at the first syntax error in a
+// file, so there is at most one error per file.
+func ParseFiles(dir string, files []string) ([]*proto.Proto, []*Error) {
+	defs := make([]*proto.Proto, len(files))
+	var errs []*Error
+	for i, rel := range files {
+		def, err := ParseProtoFile(filepath.Join(dir, rel))
+		if err != nil {
+			e, ok := err.(*Error)
+			if !ok {
+				e = &Error{Msg: err.Error()}
+			}
+			e.File = rel
+			errs = append(errs, e)
+			continue
+		}
+		defs[i] = def
+	}
+	return defs, errs
+}
+
 // ExtractPackage returns the package name from a parsed proto AST.
 func ExtractPackage(def *proto.Proto) string {
 	var pkg string
@@ -1,6 +1,7 @@
 package parser
 
 import (
+	"errors"
 	"os"
 	"path/filepath"
 	"sort"
@@ -10,6 +11,7 @@ import (
 	"github.com/emicklei/proto"
 	"github.com/emicklei/proto-contrib/pkg/protofmt"
 
+	"github.com/unitedtraders/proto-filter/internal/config"
 	"github.com/unitedtraders/proto-filter/internal/writer"
 )
 
@@ -189,7 +191,7 @@ func TestIntegrationPassThrough(t *testing.T) {
 					t.Fatalf("parse %s: %v", rel, err)
 				}
 				outPath := filepath.Join(outputDir, rel)
-				if err := writer.WriteProtoFile(def, outPath); err != nil {
+				if err := writer.WriteProtoFile(def, outPath, config.FormatConfig{}); err != nil {
 					t.Fatalf("write %s: %v", rel, err)
 				}
 			}
@@ -341,3 +343,55 @@ func formatToString(def *proto.Proto) string {
 	formatter.Format(def)
 	return b.String()
 }
+
+func TestParseFilesCollectsErrors(t *testing.T) {
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "a.proto"), []byte("syntax = \"proto3\";\nmessage A {\n\tstring id = ;\n}\n"), 0o644)
+	os.WriteFile(filepath.Join(dir, "b.proto"), []byte("syntax = \"proto3\";\nmessage B {}\n"), 0o644)
+	os.WriteFile(filepath.Join(dir, "c.proto"), []byte("syntax = \"proto3\";\nservice C {\n  rpc Do(A) returns B;\n}\n"), 0o644)
+
+	defs, errs := ParseFiles(dir, []string{"a.proto", "b.proto", "c.proto"})
+	if len(defs) != 3 || defs[0] != nil || defs[1] == nil || defs[2] != nil {
+		t.Fatalf("expected only b.proto to parse, got %v", defs)
+	}
+	if len(errs) != 2 {
+		t.Fatalf("expected 2 errors, got %d: %v", len(errs), errs)
+	}
+	a := errs[0]
+	if a.File != "a.proto" || a.Line != 3 || a.Column != 14 || a.Source != "\tstring id = ;" {
+		t.Errorf("unexpected error: %+v", a)
+	}
+	if want := `a.proto:3:14: found ";" but expected [field sequence number]`; a.Error() != want {
+		t.Errorf("Error() = %q, want %q", a.Error(), want)
+	}
+	if errs[1].File != "c.proto" || errs[1].Line != 3 || errs[1].Column != 21 {
+		t.Errorf("unexpected error: %+v", errs[1])
+	}
+
+	_, errs = ParseFiles(dir, []string{"missing.proto"})
+	if len(errs) != 1 || errs[0].Line != 0 || errs[0].File != "missing.proto" {
+		t.Errorf("expected an error without position, got %+v", errs)
+	}
+}
+
+func TestErrorPosition(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "broken.proto")
+	os.WriteFile(path, []byte("syntax = \"proto3\";\nmessage A {\n  string id = ;\n}\n"), 0o644)
+	_, err := ParseProtoFile(path)
+	if err == nil {
+		t.Fatal("expected parse error")
+	}
+	if line, column := ErrorPosition(err); line != 3 || column != 15 {
+		t.Errorf("ErrorPosition(%q) = %d:%d, want 3:15", err, line, column)
+	}
+	if line, column := ErrorPosition(os.ErrNotExist); line != 0 || column != 0 {
+		t.Errorf("expected no position for %v, got %d:%d", os.ErrNotExist, line, column)
+	}
+}
+
+func TestNewErrorLineZero(t *testing.T) {
+	e := newError("a.proto", []byte("syntax = \"proto3\";\n"), errors.New("0:0: unexpected end"))
+	if e.Line != 0 || e.Source != "" || e.Msg != "unexpected end" {
+		t.Errorf("unexpected error %+v", e)
+	}
+}
@@ -0,0 +1,129 @@
+// Package report builds the structured run report written by --report.
+package report
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/unitedtraders/proto-filter/internal/filter"
+)
+
+// Removal reasons, the keys of File.Removed.
+const (
+	ReasonIncludeExclude = "include_exclude"
+	ReasonAnnotations    = "annotations"
+	ReasonAPIVersion     = "api_version"
+	ReasonFeatures       = "features"
+	ReasonTier           = "tier"
+)
+
+// Report describes one run: what was read and written, what was removed
+// from each file and why, which annotations were substituted, how long
+// each phase took and the configuration in effect.
+type Report struct {
+	Input         string                       `json:"input"`
+	Output        string                       `json:"output"`
+	ConfigFile    string                       `json:"config_file,omitempty"`
+	InputFiles    []string                     `json:"input_files"`
+	OutputFiles   []string                     `json:"output_files"`
+	Files         []*File                      `json:"files"`
+	Removed       map[string]filter.PruneStats `json:"removed"`
+	Substitutions map[string]int               `json:"substitutions"`
+	Options       int                          `json:"options"`
+	Timings       []Timing                     `json:"timings"`
+	Config        map[string]any               `json:"config,omitempty"`
+
+	files map[string]*File
+}
+
+// File describes what happened to one input file.
+type File struct {
+	Path          string                       `json:"path"`
+	Written       bool                         `json:"written"`
+	Removed       map[string]filter.PruneStats `json:"removed"`
+	Substitutions map[string]int               `json:"substitutions,omitempty"`
+}
+
+// Timing is the duration of a pipeline phase.
+type Timing struct {
+	Phase      string  `json:"phase"`
+	DurationMS float64 `json:"duration_ms"`
+}
+
+// New returns an empty report for a run over the given input files.
+func New(input, output, configFile string, inputFiles []string) *Report {
+	r := &Report{
+		Input:         input,
+		Output:        output,
+		ConfigFile:    configFile,
+		InputFiles:    inputFiles,
+		OutputFiles:   []string{},
+		Removed:       make(map[string]filter.PruneStats),
+		Substitutions: make(map[string]int),
+		files:         make(map[string]*File),
+	}
+	for _, path := range inputFiles {
+		f := &File{Path: path, Removed: make(map[string]filter.PruneStats)}
+		r.Files = append(r.Files, f)
+		r.files[path] = f
+	}
+	return r
+}
+
+// Remove records elements removed from a file for the given reason. Empty
+// stats are not recorded.
+func (r *Report) Remove(path, reason string, stats filter.PruneStats) {
+	if !stats.Removed() {
+		return
+	}
+	f := r.files[path]
+	total := f.Removed[reason]
+	total.Add(stats)
+	f.Removed[reason] = total
+	total = r.Removed[reason]
+	total.Add(stats)
+	r.Removed[reason] = total
+}
+
+// Substitute records the substitutions made in a file, by annotation name.
+func (r *Report) Substitute(path string, counts map[string]int) {
+	if len(counts) == 0 {
+		return
+	}
+	f := r.files[path]
+	if f.Substitutions == nil {
+		f.Substitutions = make(map[string]int)
+	}
+	for name, n := range counts {
+		f.Substitutions[name] += n
+		r.Substitutions[name] += n
+	}
+}
+
+// Written records that a file was written to the output directory.
+func (r *Report) Written(path string) {
+	r.files[path].Written = true
+	r.OutputFiles = append(r.OutputFiles, path)
+}
+
+// Phase records the time spent in a phase that started at start.
+func (r *Report) Phase(name string, start time.Time) {
+	r.Timings = append(r.Timings, Timing{Phase: name, DurationMS: float64(time.Since(start).Microseconds()) / 1000})
+}
+
+// Write writes the report as indented JSON to path, creating parent
+// directories as needed.
+func (r *Report) Write(path string) error {
+	sort.Strings(r.OutputFiles)
+	data, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	return os.WriteFile(path, append(data, '\n'), 0o644)
+}
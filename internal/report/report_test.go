@@ -0,0 +1,52 @@
+package report
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/unitedtraders/proto-filter/internal/filter"
+)
+
+func TestReport(t *testing.T) {
+	r := New("protos", "out", "", []string{"a.proto", "b.proto"})
+	r.Remove("a.proto", ReasonAnnotations, filter.PruneStats{Methods: 2, Orphans: 1})
+	r.Remove("b.proto", ReasonAnnotations, filter.PruneStats{Fields: 1})
+	r.Remove("b.proto", ReasonTier, filter.PruneStats{})
+	r.Substitute("a.proto", map[string]int{"Internal": 2})
+	r.Substitute("b.proto", map[string]int{"Internal": 1, "Since": 1})
+	r.Written("b.proto")
+	r.Written("a.proto")
+	r.Phase("parse", time.Now())
+
+	path := filepath.Join(t.TempDir(), "nested", "report.json")
+	if err := r.Write(path); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("reading report: %v", err)
+	}
+	var got Report
+	if err := json.Unmarshal(data, &got); err != nil {
+		t.Fatalf("invalid JSON: %v", err)
+	}
+
+	if got.Removed[ReasonAnnotations] != (filter.PruneStats{Methods: 2, Fields: 1, Orphans: 1}) {
+		t.Errorf("total removals = %+v", got.Removed)
+	}
+	if _, ok := got.Files[1].Removed[ReasonTier]; ok {
+		t.Error("empty removals should not be recorded")
+	}
+	if got.Substitutions["Internal"] != 3 || got.Files[1].Substitutions["Since"] != 1 {
+		t.Errorf("substitutions = %v, per file %v", got.Substitutions, got.Files[1].Substitutions)
+	}
+	if len(got.OutputFiles) != 2 || got.OutputFiles[0] != "a.proto" || !got.Files[0].Written {
+		t.Errorf("output files = %v", got.OutputFiles)
+	}
+	if len(got.Timings) != 1 || got.Timings[0].Phase != "parse" {
+		t.Errorf("timings = %+v", got.Timings)
+	}
+}
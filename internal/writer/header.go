@@ -0,0 +1,35 @@
+package writer
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"strings"
+)
+
+// AddHeader returns content with header prepended as // comment lines and
+// followed by a blank line. Comments already at the top of content, such
+// as a license header, stay where they are, below the header and above
+// the syntax statement.
+func AddHeader(content []byte, header string) []byte {
+	header = strings.TrimRight(header, "\n")
+	if header == "" {
+		return content
+	}
+	var b strings.Builder
+	for _, line := range strings.Split(header, "\n") {
+		line = strings.TrimRight(line, " \t\r")
+		if line == "" {
+			b.WriteString("//\n")
+			continue
+		}
+		b.WriteString("// " + line + "\n")
+	}
+	b.WriteString("\n")
+	return append([]byte(b.String()), content...)
+}
+
+// ContentHash returns the hex-encoded SHA-256 of content.
+func ContentHash(content []byte) string {
+	sum := sha256.Sum256(content)
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,146 @@
+package writer
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// ManifestName is the file in the output directory that lists the files
+// written by the last run.
+const ManifestName = ".proto-filter-manifest.json"
+
+// Manifest lists output files, relative to the output directory with
+// slash separators, and the SHA-256 of the content written to each.
+type Manifest struct {
+	Files map[string]string
+}
+
+type manifestFile struct {
+	Files []manifestEntry `json:"files"`
+}
+
+type manifestEntry struct {
+	Path   string `json:"path"`
+	SHA256 string `json:"sha256"`
+}
+
+// NewManifest returns an empty manifest.
+func NewManifest() *Manifest {
+	return &Manifest{Files: make(map[string]string)}
+}
+
+// Add records that content was written to the output file rel.
+func (m *Manifest) Add(rel string, content []byte) {
+	m.Files[filepath.ToSlash(rel)] = ContentHash(content)
+}
+
+// LoadManifest reads the manifest in dir. A missing manifest is empty.
+func LoadManifest(dir string) (*Manifest, error) {
+	m := NewManifest()
+	data, err := os.ReadFile(filepath.Join(dir, ManifestName))
+	if errors.Is(err, fs.ErrNotExist) {
+		return m, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var f manifestFile
+	if err := json.Unmarshal(data, &f); err != nil {
+		return nil, fmt.Errorf("%s: %w", ManifestName, err)
+	}
+	for _, e := range f.Files {
+		if !filepath.IsLocal(filepath.FromSlash(e.Path)) || e.Path == ManifestName {
+			return nil, fmt.Errorf("%s: invalid path %q", ManifestName, e.Path)
+		}
+		m.Files[e.Path] = e.SHA256
+	}
+	return m, nil
+}
+
+// Write writes the manifest to dir, sorted by path.
+func (m *Manifest) Write(dir string) error {
+	f := manifestFile{Files: make([]manifestEntry, 0, len(m.Files))}
+	for path, hash := range m.Files {
+		f.Files = append(f.Files, manifestEntry{path, hash})
+	}
+	sort.Slice(f.Files, func(i, j int) bool { return f.Files[i].Path < f.Files[j].Path })
+	data, err := json.MarshalIndent(f, "", "  ")
+	if err != nil {
+		return err
+	}
+	return WriteFile(filepath.Join(dir, ManifestName), append(data, '\n'))
+}
+
+// Verify checks that every file in m has the recorded content in dir.
+func (m *Manifest) Verify(dir string) error {
+	paths := make([]string, 0, len(m.Files))
+	for path := range m.Files {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	for _, path := range paths {
+		content, err := os.ReadFile(filepath.Join(dir, filepath.FromSlash(path)))
+		if err != nil {
+			return err
+		}
+		if ContentHash(content) != m.Files[path] {
+			return fmt.Errorf("%s: content differs from what was written", path)
+		}
+	}
+	return nil
+}
+
+// Stale returns the paths in previous that are not in m, sorted.
+func (m *Manifest) Stale(previous *Manifest) []string {
+	var stale []string
+	for path := range previous.Files {
+		if _, ok := m.Files[path]; !ok {
+			stale = append(stale, path)
+		}
+	}
+	sort.Strings(stale)
+	return stale
+}
+
+// RemoveStale deletes the files in dir that previous lists and m does not,
+// along with directories left empty. A file whose content no longer
+// matches its hash in previous was changed by someone else and is kept;
+// its path is returned in modified. Files already gone are ignored.
+func (m *Manifest) RemoveStale(dir string, previous *Manifest) (removed, modified []string, err error) {
+	for _, path := range m.Stale(previous) {
+		file := filepath.Join(dir, filepath.FromSlash(path))
+		content, err := os.ReadFile(file)
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return removed, modified, err
+		}
+		if ContentHash(content) != previous.Files[path] {
+			modified = append(modified, path)
+			continue
+		}
+		if err := os.Remove(file); err != nil {
+			return removed, modified, err
+		}
+		removed = append(removed, path)
+		removeEmptyDirs(dir, filepath.Dir(file))
+	}
+	return removed, modified, nil
+}
+
+// removeEmptyDirs removes sub and its parents up to, but not including,
+// root while they are empty.
+func removeEmptyDirs(root, sub string) {
+	for sub != root && len(sub) > len(root) {
+		if os.Remove(sub) != nil {
+			return
+		}
+		sub = filepath.Dir(sub)
+	}
+}
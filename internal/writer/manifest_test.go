@@ -0,0 +1,112 @@
+package writer
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestManifestRoundTrip(t *testing.T) {
+	dir := t.TempDir()
+	m := NewManifest()
+	m.Add(filepath.Join("b", "b.proto"), []byte("b"))
+	m.Add("a.proto", []byte("a"))
+	if err := m.Write(dir); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+	data, _ := os.ReadFile(filepath.Join(dir, ManifestName))
+	want := `{
+  "files": [
+    {
+      "path": "a.proto",
+      "sha256": "` + ContentHash([]byte("a")) + `"
+    },
+    {
+      "path": "b/b.proto",
+      "sha256": "` + ContentHash([]byte("b")) + `"
+    }
+  ]
+}
+`
+	if string(data) != want {
+		t.Errorf("manifest =\n%s\nwant:\n%s", data, want)
+	}
+
+	loaded, err := LoadManifest(dir)
+	if err != nil {
+		t.Fatalf("LoadManifest: %v", err)
+	}
+	if !reflect.DeepEqual(loaded, m) {
+		t.Errorf("loaded %v, want %v", loaded.Files, m.Files)
+	}
+}
+
+func TestLoadManifestMissing(t *testing.T) {
+	m, err := LoadManifest(t.TempDir())
+	if err != nil || len(m.Files) != 0 {
+		t.Errorf("expected an empty manifest, got %v, %v", m, err)
+	}
+}
+
+func TestLoadManifestRejectsPathsOutsideDir(t *testing.T) {
+	for _, path := range []string{"../escape.proto", "/etc/passwd", ManifestName} {
+		dir := t.TempDir()
+		os.WriteFile(filepath.Join(dir, ManifestName), []byte(`{"files": [{"path": "`+path+`", "sha256": ""}]}`), 0o644)
+		if _, err := LoadManifest(dir); err == nil {
+			t.Errorf("%s: expected an error", path)
+		}
+	}
+}
+
+func TestRemoveStale(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"kept.proto":          "kept",
+		"old/stale.proto":     "stale",
+		"edited.proto":        "original",
+		"untracked.proto":     "untracked",
+		"shared/stale.proto":  "stale",
+		"shared/other.proto":  "other",
+		"deleted/gone.proto":  "",
+		"nested/a/b/c.proto":  "c",
+		"nested/keep.txt":     "keep",
+		"unrelated/dir/x.txt": "x",
+	}
+	previous := NewManifest()
+	for path, content := range files {
+		if path != "deleted/gone.proto" {
+			if err := WriteFile(filepath.Join(dir, path), []byte(content)); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if path != "untracked.proto" && path != "shared/other.proto" && path != "nested/keep.txt" && path != "unrelated/dir/x.txt" {
+			previous.Add(path, []byte(content))
+		}
+	}
+	os.WriteFile(filepath.Join(dir, "edited.proto"), []byte("edited by hand"), 0o644)
+
+	current := NewManifest()
+	current.Add("kept.proto", []byte("kept"))
+	removed, modified, err := current.RemoveStale(dir, previous)
+	if err != nil {
+		t.Fatalf("RemoveStale: %v", err)
+	}
+	if want := []string{"nested/a/b/c.proto", "old/stale.proto", "shared/stale.proto"}; !reflect.DeepEqual(removed, want) {
+		t.Errorf("removed = %v, want %v", removed, want)
+	}
+	if want := []string{"edited.proto"}; !reflect.DeepEqual(modified, want) {
+		t.Errorf("modified = %v, want %v", modified, want)
+	}
+
+	for _, path := range []string{"kept.proto", "edited.proto", "untracked.proto", "shared/other.proto", "nested/keep.txt", "unrelated/dir/x.txt"} {
+		if _, err := os.Stat(filepath.Join(dir, path)); err != nil {
+			t.Errorf("%s should be kept: %v", path, err)
+		}
+	}
+	for _, path := range []string{"old", "nested/a"} {
+		if _, err := os.Stat(filepath.Join(dir, path)); !os.IsNotExist(err) {
+			t.Errorf("empty directory %s should be removed", path)
+		}
+	}
+}
@@ -0,0 +1,671 @@
+package writer
+
+import (
+	"bytes"
+	"sort"
+	"strings"
+	"text/scanner"
+
+	"github.com/emicklei/proto"
+)
+
+// WriteProtoFileMinimal writes the AST to the given path as an edit of
+// source, the text it was parsed from, so that formatting the filters
+// did not touch is kept. Parent directories are created as needed.
+func WriteProtoFileMinimal(definition *proto.Proto, source []byte, outputPath string) error {
+	content, err := EditSource(definition, source)
+	if err != nil {
+		return err
+	}
+	return WriteFile(outputPath, content)
+}
+
+// EditSource returns source with the changes made to definition since it
+// was parsed applied as text edits: removed elements are cut out together
+// with their comments, comments whose text changed are rewritten, and
+// added options are inserted. Everything else is kept byte for byte.
+//
+// Elements are matched to the source by position, so definition must have
+// been parsed from source. A comment counts as changed only if its text
+// did; converting a block comment or trimming blank comment lines keeps
+// the original.
+func EditSource(definition *proto.Proto, source []byte) ([]byte, error) {
+	original, err := proto.NewParser(bytes.NewReader(source)).Parse()
+	if err != nil {
+		return nil, err
+	}
+	e := &editor{src: source, current: make(map[int]proto.Visitee)}
+	e.index(definition.Elements)
+	e.diff(original.Elements)
+	return e.apply(), nil
+}
+
+// edit replaces src[start:end] with text. Lines is set when the edit
+// deletes whole lines.
+type edit struct {
+	start, end int
+	text       string
+	lines      bool
+}
+
+type editor struct {
+	src     []byte
+	current map[int]proto.Visitee // elements of the edited AST by source offset
+	edits   []edit
+}
+
+// node returns the position and comments of an element, and false for
+// elements that have no position.
+func node(v proto.Visitee) (pos scanner.Position, leading, inline *proto.Comment, ok bool) {
+	switch v := v.(type) {
+	case *proto.Syntax:
+		return v.Position, v.Comment, v.InlineComment, true
+	case *proto.Edition:
+		return v.Position, v.Comment, v.InlineComment, true
+	case *proto.Package:
+		return v.Position, v.Comment, v.InlineComment, true
+	case *proto.Import:
+		return v.Position, v.Comment, v.InlineComment, true
+	case *proto.Option:
+		return v.Position, v.Comment, v.InlineComment, true
+	case *proto.Message:
+		return v.Position, v.Comment, nil, true
+	case *proto.Enum:
+		return v.Position, v.Comment, nil, true
+	case *proto.EnumField:
+		return v.Position, v.Comment, v.InlineComment, true
+	case *proto.Service:
+		return v.Position, v.Comment, nil, true
+	case *proto.RPC:
+		return v.Position, v.Comment, v.InlineComment, true
+	case *proto.NormalField:
+		return v.Position, v.Comment, v.InlineComment, true
+	case *proto.MapField:
+		return v.Position, v.Comment, v.InlineComment, true
+	case *proto.OneOfField:
+		return v.Position, v.Comment, v.InlineComment, true
+	case *proto.Oneof:
+		return v.Position, v.Comment, nil, true
+	case *proto.Group:
+		return v.Position, v.Comment, nil, true
+	case *proto.Reserved:
+		return v.Position, v.Comment, v.InlineComment, true
+	case *proto.Extensions:
+		return v.Position, v.Comment, v.InlineComment, true
+	case *proto.Comment:
+		return v.Position, nil, nil, true
+	}
+	return scanner.Position{}, nil, nil, false
+}
+
+// children returns the statements inside a block element.
+func children(v proto.Visitee) []proto.Visitee {
+	switch v := v.(type) {
+	case *proto.Message:
+		return v.Elements
+	case *proto.Enum:
+		return v.Elements
+	case *proto.Service:
+		return v.Elements
+	case *proto.RPC:
+		return v.Elements
+	case *proto.Oneof:
+		return v.Elements
+	case *proto.Group:
+		return v.Elements
+	}
+	return nil
+}
+
+// index records the elements of the edited AST that came from the source.
+// Elements added by the filters have no position.
+func (e *editor) index(elements []proto.Visitee) {
+	for _, v := range elements {
+		if pos, _, _, ok := node(v); ok && pos.Line > 0 {
+			e.current[pos.Offset] = v
+		}
+		e.index(children(v))
+	}
+}
+
+// diff compares the elements of the original AST with the edited one and
+// records the edits needed to turn one into the other.
+func (e *editor) diff(elements []proto.Visitee) {
+	for _, v := range elements {
+		pos, _, _, ok := node(v)
+		if !ok {
+			continue
+		}
+		cur, ok := e.current[pos.Offset]
+		if !ok {
+			e.cut(v)
+			continue
+		}
+		e.diffComments(v, cur)
+		e.addOptions(v, cur)
+		e.diff(children(v))
+	}
+}
+
+// cut removes an element with its leading and trailing comments.
+func (e *editor) cut(v proto.Visitee) {
+	pos, leading, _, _ := node(v)
+	if c, ok := v.(*proto.Comment); ok {
+		e.remove(pos.Offset, e.commentEnd(c))
+		return
+	}
+	start := pos.Offset
+	if leading != nil && leading.Position.Offset < start {
+		switch c := leading.Position.Offset; {
+		case e.blankBefore(c):
+			start = c
+		case bytes.HasPrefix(e.src[c:], []byte("//")) && e.lineEnd(c) < e.lineStart(start):
+			// The parser merges a trailing comment on the line before
+			// into the leading comment; keep that line.
+			start = e.lineEnd(c) + 1
+		}
+	}
+	e.remove(start, e.trailingComment(e.statementEnd(pos.Offset)))
+}
+
+// diffComments rewrites, removes or adds the leading and inline comments
+// of an element that is kept.
+func (e *editor) diffComments(orig, cur proto.Visitee) {
+	pos, origLeading, origInline, _ := node(orig)
+	_, leading, inline, _ := node(cur)
+	indent := e.indent(pos.Offset)
+
+	switch {
+	case origLeading == nil && leading == nil:
+	case origLeading == nil:
+		if e.blankBefore(pos.Offset) {
+			start := e.lineStart(pos.Offset)
+			e.add(start, start, indent+renderComment(leading, indent, false)+"\n")
+		}
+	case leading == nil:
+		e.remove(origLeading.Position.Offset, e.commentEnd(origLeading))
+	case !sameText(origLeading, leading):
+		end := e.commentEnd(origLeading)
+		if e.blankBefore(origLeading.Position.Offset) {
+			indent = e.indent(origLeading.Position.Offset)
+		}
+		e.add(origLeading.Position.Offset, end, renderComment(leading, indent, !e.blankAfter(end)))
+	}
+
+	switch {
+	case origInline == nil && inline == nil:
+	case origInline == nil:
+		end := e.statementEnd(pos.Offset)
+		e.add(end, end, " "+renderComment(inline, "", !e.blankAfter(end)))
+	case inline == nil:
+		e.remove(origInline.Position.Offset, e.commentEnd(origInline))
+	case !sameText(origInline, inline):
+		end := e.commentEnd(origInline)
+		e.add(origInline.Position.Offset, end, renderComment(inline, "", !e.blankAfter(end)))
+	}
+}
+
+// addOptions inserts the options the filters added to an element: option
+// statements in blocks and compact options in field brackets.
+func (e *editor) addOptions(orig, cur proto.Visitee) {
+	pos, _, _, _ := node(orig)
+	switch cur := cur.(type) {
+	case *proto.Message, *proto.Enum, *proto.Service, *proto.RPC:
+		var added []string
+		for _, o := range newOptions(children(cur)) {
+			added = append(added, "option "+optionText(o)+";")
+		}
+		if len(added) > 0 {
+			e.addStatements(pos.Offset, children(orig), added)
+		}
+	case *proto.NormalField:
+		e.addCompactOptions(pos.Offset, newOptions(optionElements(cur.Options)))
+	case *proto.MapField:
+		e.addCompactOptions(pos.Offset, newOptions(optionElements(cur.Options)))
+	case *proto.OneOfField:
+		e.addCompactOptions(pos.Offset, newOptions(optionElements(cur.Options)))
+	case *proto.EnumField:
+		e.addCompactOptions(pos.Offset, newOptions(cur.Elements))
+	}
+}
+
+// addStatements inserts statements into the block of the element at
+// start: after its last option, or else at the top of the block. An RPC
+// without a block gets one.
+func (e *editor) addStatements(start int, origChildren []proto.Visitee, statements []string) {
+	indent := e.indent(start)
+	inner := indent + indentUnit(indent)
+	lastOption := -1
+	for _, c := range origChildren {
+		pos, _, _, _ := node(c)
+		if _, ok := c.(*proto.Option); ok {
+			lastOption = pos.Offset
+		}
+		if _, ok := c.(*proto.Comment); !ok && e.blankBefore(pos.Offset) {
+			inner = e.indent(pos.Offset)
+		}
+	}
+	lines := "\n" + inner + strings.Join(statements, "\n"+inner)
+	if lastOption >= 0 {
+		end := e.trailingComment(e.statementEnd(lastOption))
+		e.add(end, end, lines)
+		return
+	}
+	end := e.statementEnd(start)
+	open := e.blockOpen(start, end)
+	if open < 0 {
+		// A statement such as `rpc Get(A) returns (B);`
+		e.add(end-1, end, " {"+lines+"\n"+indent+"}")
+		return
+	}
+	if lineEnd := e.trailingComment(open + 1); e.blankAfter(lineEnd) {
+		e.add(lineEnd, lineEnd, lines)
+		return
+	}
+	if e.src[e.skipSpace(open+1)] == '}' {
+		e.add(open+1, e.skipSpace(open+1), lines+"\n"+indent)
+		return
+	}
+	e.add(open+1, open+1, lines)
+}
+
+// addCompactOptions adds options to the brackets of the field or enum
+// value at start, adding the brackets if it has none.
+func (e *editor) addCompactOptions(start int, options []*proto.Option) {
+	if len(options) == 0 {
+		return
+	}
+	texts := make([]string, 0, len(options))
+	for _, o := range options {
+		texts = append(texts, optionText(o))
+	}
+	end := e.statementEnd(start) - 1 // the semicolon
+	last := end - 1
+	for last > start && isSpace(e.src[last]) {
+		last--
+	}
+	if e.src[last] == ']' {
+		e.add(last, last, ", "+strings.Join(texts, ", "))
+		return
+	}
+	e.add(last+1, last+1, " ["+strings.Join(texts, ", ")+"]")
+}
+
+func newOptions(elements []proto.Visitee) []*proto.Option {
+	var options []*proto.Option
+	for _, v := range elements {
+		if o, ok := v.(*proto.Option); ok && o.Position.Line == 0 {
+			options = append(options, o)
+		}
+	}
+	return options
+}
+
+func optionElements(options []*proto.Option) []proto.Visitee {
+	elements := make([]proto.Visitee, len(options))
+	for i, o := range options {
+		elements[i] = o
+	}
+	return elements
+}
+
+func optionText(o *proto.Option) string {
+	return o.Name + " = " + o.Constant.SourceRepresentation()
+}
+
+// sameText reports whether two comments have the same text, ignoring
+// indentation, blank lines and block comment framing.
+func sameText(a, b *proto.Comment) bool {
+	ta, tb := commentText(a), commentText(b)
+	if len(ta) != len(tb) {
+		return false
+	}
+	for i := range ta {
+		if ta[i] != tb[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func commentText(c *proto.Comment) []string {
+	var text []string
+	for _, line := range c.Lines {
+		line = strings.TrimSpace(strings.TrimLeft(strings.TrimSpace(line), "*"))
+		if line != "" {
+			text = append(text, line)
+		}
+	}
+	return text
+}
+
+// renderComment renders a comment whose first line starts at the current
+// position and whose other lines start with indent. A comment followed by
+// code on the same line is rendered as a single block comment.
+func renderComment(c *proto.Comment, indent string, codeAfter bool) string {
+	if codeAfter {
+		return "/* " + strings.Join(commentText(c), " ") + " */"
+	}
+	if c.Cstyle {
+		lines := []string{"/*"}
+		for i, line := range c.Lines {
+			line = strings.TrimRight(line, " ")
+			if line == "" && (i == 0 || i == len(c.Lines)-1) {
+				continue
+			}
+			lines = append(lines, line)
+		}
+		return strings.Join(append(lines, " */"), "\n"+indent)
+	}
+	prefix := "//"
+	if c.ExtraSlash {
+		prefix = "///"
+	}
+	lines := make([]string, len(c.Lines))
+	for i, line := range c.Lines {
+		lines[i] = prefix + line
+	}
+	return strings.Join(lines, "\n"+indent)
+}
+
+func (e *editor) add(start, end int, text string) {
+	e.edits = append(e.edits, edit{start: start, end: end, text: text})
+}
+
+// remove deletes src[start:end]. Whole lines are deleted when nothing else
+// is on them, and otherwise the spaces next to the deleted text.
+func (e *editor) remove(start, end int) {
+	switch before, after := e.blankBefore(start), e.blankAfter(end); {
+	case before && after:
+		end = e.lineEnd(end)
+		if end < len(e.src) {
+			end++
+		}
+		e.edits = append(e.edits, edit{start: e.lineStart(start), end: end, lines: true})
+	case after:
+		for start > 0 && isSpace(e.src[start-1]) {
+			start--
+		}
+		e.add(start, end, "")
+	default:
+		e.add(start, e.skipSpace(end), "")
+	}
+}
+
+// apply returns the source with the edits applied.
+func (e *editor) apply() []byte {
+	starts := e.lineStarts()
+	deleted := make([]bool, len(starts))
+	var edits []edit
+	for _, ed := range e.edits {
+		if !ed.lines {
+			edits = append(edits, ed)
+			continue
+		}
+		for i := sort.SearchInts(starts, ed.start); i < len(starts) && starts[i] < ed.end; i++ {
+			deleted[i] = true
+		}
+	}
+	e.collapseBlankLines(starts, deleted)
+	for i := 0; i < len(starts); i++ {
+		if !deleted[i] {
+			continue
+		}
+		j := i
+		for j < len(starts) && deleted[j] {
+			j++
+		}
+		end := len(e.src)
+		if j < len(starts) {
+			end = starts[j]
+		}
+		edits = append(edits, edit{start: starts[i], end: end})
+		i = j
+	}
+	sort.SliceStable(edits, func(i, j int) bool { return edits[i].start < edits[j].start })
+
+	var out bytes.Buffer
+	pos := 0
+	for _, ed := range edits {
+		if ed.start < pos {
+			if ed.text != "" && ed.start != ed.end {
+				continue // a replacement overlapping an earlier edit
+			}
+			ed.start, ed.end = pos, max(ed.end, pos)
+		}
+		out.Write(e.src[pos:ed.start])
+		out.WriteString(ed.text)
+		pos = ed.end
+	}
+	out.Write(e.src[pos:])
+	return out.Bytes()
+}
+
+// collapseBlankLines deletes blank lines left next to deleted lines so
+// that removing elements does not leave more than one blank line in a
+// row, or a blank line at the start or end of a block.
+func (e *editor) collapseBlankLines(starts []int, deleted []bool) {
+	line := func(i int) string {
+		end := len(e.src)
+		if i+1 < len(starts) {
+			end = starts[i+1]
+		}
+		return strings.TrimSpace(string(e.src[starts[i]:end]))
+	}
+	n := len(starts)
+	for i := 0; i < n; {
+		if !deleted[i] && line(i) != "" {
+			i++
+			continue
+		}
+		j, any := i, false
+		for j < n && (deleted[j] || line(j) == "") {
+			any = any || deleted[j]
+			j++
+		}
+		if any {
+			keep := 1
+			if i == 0 || strings.HasSuffix(line(i-1), "{") || j == n || strings.HasPrefix(line(j), "}") {
+				keep = 0
+			}
+			for k := i; k < j; k++ {
+				if deleted[k] {
+					continue
+				}
+				if keep > 0 {
+					keep--
+				} else {
+					deleted[k] = true
+				}
+			}
+		}
+		i = j
+	}
+}
+
+// lineStarts returns the offset of the start of each line.
+func (e *editor) lineStarts() []int {
+	starts := []int{0}
+	for i, c := range e.src {
+		if c == '\n' && i+1 < len(e.src) {
+			starts = append(starts, i+1)
+		}
+	}
+	return starts
+}
+
+// scanCode calls fn with the offset and byte of each character of code
+// from start on, skipping comments and string literals, until fn returns
+// false.
+func (e *editor) scanCode(start int, fn func(i int, c byte) bool) {
+	src := e.src
+	for i := start; i < len(src); i++ {
+		switch c := src[i]; {
+		case c == '/' && i+1 < len(src) && src[i+1] == '/':
+			i = e.lineEnd(i) - 1
+		case c == '/' && i+1 < len(src) && src[i+1] == '*':
+			if end := bytes.Index(src[i+2:], []byte("*/")); end >= 0 {
+				i += end + 3
+			} else {
+				return
+			}
+		case c == '"' || c == '\'':
+			for i++; i < len(src) && src[i] != c; i++ {
+				if src[i] == '\\' {
+					i++
+				}
+			}
+		default:
+			if !fn(i, c) {
+				return
+			}
+		}
+	}
+}
+
+// statementEnd returns the offset after the statement or block that
+// starts at start.
+func (e *editor) statementEnd(start int) int {
+	end := len(e.src)
+	depth := 0
+	e.scanCode(start, func(i int, c byte) bool {
+		switch c {
+		case '{', '[', '(':
+			depth++
+		case ']', ')':
+			depth--
+		case '}':
+			depth--
+			if depth == 0 {
+				end = i + 1
+				return false
+			}
+		case ';':
+			if depth == 0 {
+				end = i + 1
+				return false
+			}
+		}
+		return true
+	})
+	return end
+}
+
+// blockOpen returns the offset of the brace that opens the block of the
+// element between start and end, or -1 if it has none.
+func (e *editor) blockOpen(start, end int) int {
+	open := -1
+	depth := 0
+	e.scanCode(start, func(i int, c byte) bool {
+		switch c {
+		case '[', '(':
+			depth++
+		case ']', ')':
+			depth--
+		case '{':
+			if depth == 0 {
+				open = i
+				return false
+			}
+		}
+		return i < end
+	})
+	return open
+}
+
+// commentEnd returns the offset after a comment in the original source.
+// Consecutive comments are merged by the parser, so as many are consumed
+// as the comment has lines.
+func (e *editor) commentEnd(c *proto.Comment) int {
+	src := e.src
+	i := c.Position.Offset
+	for lines := 0; lines < len(c.Lines); {
+		i = e.skipSpace(i)
+		for i < len(src) && src[i] == '\n' {
+			i = e.skipSpace(i + 1)
+		}
+		switch {
+		case bytes.HasPrefix(src[i:], []byte("//")):
+			i = e.lineEnd(i)
+			lines++
+		case bytes.HasPrefix(src[i:], []byte("/*")):
+			end := bytes.Index(src[i:], []byte("*/"))
+			if end < 0 {
+				return len(src)
+			}
+			lines += bytes.Count(src[i:i+end], []byte("\n")) + 1
+			i += end + 2
+		default:
+			return i
+		}
+	}
+	return i
+}
+
+// trailingComment returns the offset after a comment that follows end on
+// the same line, or end if there is none.
+func (e *editor) trailingComment(end int) int {
+	i := e.skipSpace(end)
+	switch {
+	case bytes.HasPrefix(e.src[i:], []byte("//")):
+		return e.lineEnd(i)
+	case bytes.HasPrefix(e.src[i:], []byte("/*")):
+		close := bytes.Index(e.src[i:], []byte("*/"))
+		if close >= 0 && !bytes.Contains(e.src[i:i+close], []byte("\n")) {
+			return i + close + 2
+		}
+	}
+	return end
+}
+
+func (e *editor) lineStart(i int) int {
+	return bytes.LastIndexByte(e.src[:i], '\n') + 1
+}
+
+// lineEnd returns the offset of the newline ending the line i is on, or
+// the end of the source.
+func (e *editor) lineEnd(i int) int {
+	if n := bytes.IndexByte(e.src[i:], '\n'); n >= 0 {
+		return i + n
+	}
+	return len(e.src)
+}
+
+func (e *editor) skipSpace(i int) int {
+	for i < len(e.src) && isSpace(e.src[i]) {
+		i++
+	}
+	return i
+}
+
+// blankBefore reports whether only spaces precede i on its line.
+func (e *editor) blankBefore(i int) bool {
+	return strings.TrimSpace(string(e.src[e.lineStart(i):i])) == ""
+}
+
+// blankAfter reports whether only spaces follow i on its line.
+func (e *editor) blankAfter(i int) bool {
+	return e.skipSpace(i) == e.lineEnd(i)
+}
+
+// indent returns the indentation of the line i is on.
+func (e *editor) indent(i int) string {
+	start := e.lineStart(i)
+	end := start
+	for end < len(e.src) && isSpace(e.src[end]) {
+		end++
+	}
+	return string(e.src[start:end])
+}
+
+// indentUnit guesses one level of indentation from an element's indent.
+func indentUnit(indent string) string {
+	if strings.Contains(indent, "\t") {
+		return "\t"
+	}
+	return "  "
+}
+
+func isSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\r'
+}
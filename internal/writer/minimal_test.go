@@ -0,0 +1,276 @@
+package writer
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/emicklei/proto"
+)
+
+const minimalSource = `syntax = "proto3";
+
+package shop.v1;
+
+import "google/protobuf/timestamp.proto";
+
+// OrderService manages orders.
+service OrderService {
+  rpc GetOrder(GetOrderRequest)   returns (Order);
+  // @Internal
+  rpc DeleteOrder(GetOrderRequest) returns (Order);
+}
+
+message GetOrderRequest {
+  string   id    = 1;   // aligned
+}
+
+/**
+ * An order.
+ * @HasAnyRole("admin")
+ */
+message Order {
+  string id = 1;
+  // Deprecated total.
+  int64 total_cents = 2 [deprecated = true];
+  repeated string tags = 3;
+}
+
+// Unused.
+message Audit {
+  string who = 1;
+}
+`
+
+func parseSource(t *testing.T, src string) *proto.Proto {
+	t.Helper()
+	def, err := proto.NewParser(bytes.NewReader([]byte(src))).Parse()
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	return def
+}
+
+func editSource(t *testing.T, def *proto.Proto, src string) string {
+	t.Helper()
+	out, err := EditSource(def, []byte(src))
+	if err != nil {
+		t.Fatalf("EditSource: %v", err)
+	}
+	return string(out)
+}
+
+func findMessage(def *proto.Proto, name string) *proto.Message {
+	for _, e := range def.Elements {
+		if m, ok := e.(*proto.Message); ok && m.Name == name {
+			return m
+		}
+	}
+	return nil
+}
+
+func findService(def *proto.Proto, name string) *proto.Service {
+	for _, e := range def.Elements {
+		if s, ok := e.(*proto.Service); ok && s.Name == name {
+			return s
+		}
+	}
+	return nil
+}
+
+func removeElement(elements []proto.Visitee, remove proto.Visitee) []proto.Visitee {
+	var kept []proto.Visitee
+	for _, e := range elements {
+		if e != remove {
+			kept = append(kept, e)
+		}
+	}
+	return kept
+}
+
+func TestEditSourceUnchanged(t *testing.T) {
+	def := parseSource(t, minimalSource)
+	if got := editSource(t, def, minimalSource); got != minimalSource {
+		t.Errorf("unchanged AST should give the source back, got:\n%s", got)
+	}
+}
+
+func TestEditSourceRemovesElements(t *testing.T) {
+	def := parseSource(t, minimalSource)
+	def.Elements = removeElement(def.Elements, findMessage(def, "Audit"))
+	svc := findService(def, "OrderService")
+	svc.Elements = svc.Elements[:1]
+	order := findMessage(def, "Order")
+	order.Elements = removeElement(order.Elements, order.Elements[1])
+
+	want := `syntax = "proto3";
+
+package shop.v1;
+
+import "google/protobuf/timestamp.proto";
+
+// OrderService manages orders.
+service OrderService {
+  rpc GetOrder(GetOrderRequest)   returns (Order);
+}
+
+message GetOrderRequest {
+  string   id    = 1;   // aligned
+}
+
+/**
+ * An order.
+ * @HasAnyRole("admin")
+ */
+message Order {
+  string id = 1;
+  repeated string tags = 3;
+}
+`
+	if got := editSource(t, def, minimalSource); got != want {
+		t.Errorf("output:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestEditSourceCollapsesBlankLines(t *testing.T) {
+	src := "syntax = \"proto3\";\n\nmessage A {\n  string a = 1;\n\n  // B\n  string b = 2;\n}\n\nmessage B {}\n\nmessage C {}\n"
+	def := parseSource(t, src)
+	def.Elements = removeElement(def.Elements, findMessage(def, "B"))
+	a := findMessage(def, "A")
+	a.Elements = a.Elements[:1]
+
+	want := "syntax = \"proto3\";\n\nmessage A {\n  string a = 1;\n}\n\nmessage C {}\n"
+	if got := editSource(t, def, src); got != want {
+		t.Errorf("output:\n%q\nwant:\n%q", got, want)
+	}
+}
+
+func TestEditSourceComments(t *testing.T) {
+	def := parseSource(t, minimalSource)
+	// Converting the block comment does not change its text
+	order := findMessage(def, "Order")
+	order.Comment.Cstyle = false
+	order.Comment.Lines = []string{" An order.", " Requires admin."}
+	// Removed comment
+	order.Elements[1].(*proto.NormalField).Comment = nil
+	// Unchanged text, different whitespace
+	svc := findService(def, "OrderService")
+	svc.Comment.Lines = []string{"   OrderService manages orders.", ""}
+	// Changed inline comment
+	findMessage(def, "GetOrderRequest").Elements[0].(*proto.NormalField).InlineComment.Lines = []string{" the ID"}
+
+	want := `syntax = "proto3";
+
+package shop.v1;
+
+import "google/protobuf/timestamp.proto";
+
+// OrderService manages orders.
+service OrderService {
+  rpc GetOrder(GetOrderRequest)   returns (Order);
+  // @Internal
+  rpc DeleteOrder(GetOrderRequest) returns (Order);
+}
+
+message GetOrderRequest {
+  string   id    = 1;   // the ID
+}
+
+// An order.
+// Requires admin.
+message Order {
+  string id = 1;
+  int64 total_cents = 2 [deprecated = true];
+  repeated string tags = 3;
+}
+
+// Unused.
+message Audit {
+  string who = 1;
+}
+`
+	if got := editSource(t, def, minimalSource); got != want {
+		t.Errorf("output:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestEditSourceAddsOptions(t *testing.T) {
+	def := parseSource(t, minimalSource)
+	option := func(name, value string) *proto.Option {
+		return &proto.Option{Name: name, Constant: proto.Literal{Source: value}}
+	}
+	svc := findService(def, "OrderService")
+	svc.Elements[1].(*proto.RPC).Elements = append(svc.Elements[1].(*proto.RPC).Elements, option("(internal)", "true"))
+	order := findMessage(def, "Order")
+	order.Elements = append([]proto.Visitee{option("(acme.visibility)", "ADMIN")}, order.Elements...)
+	order.Elements[2].(*proto.NormalField).Options = append(order.Elements[2].(*proto.NormalField).Options, option("(sensitive)", "true"))
+	order.Elements[3].(*proto.NormalField).Options = append(order.Elements[3].(*proto.NormalField).Options, option("(sensitive)", "true"))
+
+	want := `syntax = "proto3";
+
+package shop.v1;
+
+import "google/protobuf/timestamp.proto";
+
+// OrderService manages orders.
+service OrderService {
+  rpc GetOrder(GetOrderRequest)   returns (Order);
+  // @Internal
+  rpc DeleteOrder(GetOrderRequest) returns (Order) {
+    option (internal) = true;
+  }
+}
+
+message GetOrderRequest {
+  string   id    = 1;   // aligned
+}
+
+/**
+ * An order.
+ * @HasAnyRole("admin")
+ */
+message Order {
+  option (acme.visibility) = ADMIN;
+  string id = 1;
+  // Deprecated total.
+  int64 total_cents = 2 [deprecated = true, (sensitive) = true];
+  repeated string tags = 3 [(sensitive) = true];
+}
+
+// Unused.
+message Audit {
+  string who = 1;
+}
+`
+	if got := editSource(t, def, minimalSource); got != want {
+		t.Errorf("output:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestWriteProtoFileMinimal(t *testing.T) {
+	def := parseSource(t, minimalSource)
+	outputPath := filepath.Join(t.TempDir(), "nested", "order.proto")
+	if err := WriteProtoFileMinimal(def, []byte(minimalSource), outputPath); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	data, err := os.ReadFile(outputPath)
+	if err != nil {
+		t.Fatalf("reading output: %v", err)
+	}
+	if string(data) != minimalSource {
+		t.Errorf("output should equal the source, got:\n%s", data)
+	}
+}
+
+func TestEditSourceBlockComment(t *testing.T) {
+	def := parseSource(t, minimalSource)
+	order := findMessage(def, "Order")
+	order.Comment.Lines = []string{"", " * An order.", " * Requires admin.", " "}
+
+	want := "/*\n * An order.\n * Requires admin.\n */\nmessage Order {"
+	if got := editSource(t, def, minimalSource); !strings.Contains(got, want) {
+		t.Errorf("output should contain %q:\n%s", want, got)
+	}
+}
@@ -0,0 +1,129 @@
+package writer
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+)
+
+// rename moves a file or directory. Tests replace it to make a commit fail.
+var rename = os.Rename
+
+// Stage is a temporary copy of an output directory, created next to it,
+// that a run writes to. Commit swaps it into place once everything is
+// written, so the output directory never holds a partial result.
+type Stage struct {
+	// Dir is the staging directory to write to.
+	Dir    string
+	target string
+}
+
+// NewStage creates a staging directory next to target holding a copy of
+// target's current content, if target exists. If target is a symbolic
+// link, the directory it points to is copied and later replaced, and the
+// link is left in place.
+func NewStage(target string) (*Stage, error) {
+	mode := fs.FileMode(0o755)
+	info, err := os.Stat(target)
+	switch {
+	case err == nil && !info.IsDir():
+		return nil, fmt.Errorf("%s is not a directory", target)
+	case err == nil:
+		mode = info.Mode().Perm()
+		if target, err = filepath.EvalSymlinks(target); err != nil {
+			return nil, err
+		}
+	case !errors.Is(err, fs.ErrNotExist):
+		return nil, err
+	}
+	parent, base := filepath.Split(target)
+	if err := os.MkdirAll(parent, 0o755); err != nil {
+		return nil, err
+	}
+	dir, err := os.MkdirTemp(parent, "."+base+".tmp-")
+	if err != nil {
+		return nil, err
+	}
+	s := &Stage{Dir: dir, target: target}
+	if info != nil {
+		err = copyTree(target, dir)
+	}
+	if err == nil {
+		err = os.Chmod(dir, mode)
+	}
+	if err != nil {
+		s.Discard()
+		return nil, err
+	}
+	return s, nil
+}
+
+// Commit replaces the target directory with the staging directory: the
+// target is renamed to a backup next to it, the staging directory is
+// renamed into its place, and the backup is removed. If either rename
+// fails, the target keeps its previous content.
+func (s *Stage) Commit() error {
+	backup := s.Dir + ".old"
+	_, err := os.Lstat(s.target)
+	exists := err == nil
+	if exists {
+		if err := rename(s.target, backup); err != nil {
+			return err
+		}
+	}
+	if err := rename(s.Dir, s.target); err != nil {
+		if exists {
+			if restoreErr := rename(backup, s.target); restoreErr != nil {
+				return fmt.Errorf("%w; previous output kept in %s", err, backup)
+			}
+		}
+		return err
+	}
+	if exists {
+		return os.RemoveAll(backup)
+	}
+	return nil
+}
+
+// Discard removes the staging directory. It does nothing after Commit.
+func (s *Stage) Discard() {
+	os.RemoveAll(s.Dir)
+}
+
+// copyTree copies the directories, regular files and symbolic links under
+// src into dst, keeping their permissions.
+func copyTree(src, dst string) error {
+	return filepath.WalkDir(src, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, _ := filepath.Rel(src, path)
+		if rel == "." {
+			return nil
+		}
+		target := filepath.Join(dst, rel)
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		switch {
+		case d.IsDir():
+			return os.Mkdir(target, info.Mode().Perm()|0o700)
+		case d.Type()&fs.ModeSymlink != 0:
+			link, err := os.Readlink(path)
+			if err != nil {
+				return err
+			}
+			return os.Symlink(link, target)
+		case d.Type().IsRegular():
+			content, err := os.ReadFile(path)
+			if err != nil {
+				return err
+			}
+			return os.WriteFile(target, content, info.Mode().Perm())
+		}
+		return nil
+	})
+}
@@ -0,0 +1,188 @@
+package writer
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestStageCommit(t *testing.T) {
+	parent := t.TempDir()
+	target := filepath.Join(parent, "out")
+	WriteFile(filepath.Join(target, "old.proto"), []byte("old"))
+	os.Chmod(filepath.Join(target, "old.proto"), 0o600)
+	WriteFile(filepath.Join(target, "docs", "README.md"), []byte("docs"))
+
+	s, err := NewStage(target)
+	if err != nil {
+		t.Fatalf("NewStage: %v", err)
+	}
+	if filepath.Dir(s.Dir) != parent {
+		t.Errorf("stage %s should be next to the target", s.Dir)
+	}
+	if got := readTree(t, s.Dir); !reflect.DeepEqual(got, map[string]string{"old.proto": "old", "docs/README.md": "docs"}) {
+		t.Errorf("stage should start as a copy of the target, got %v", got)
+	}
+	WriteFile(filepath.Join(s.Dir, "old.proto"), []byte("new"))
+	WriteFile(filepath.Join(s.Dir, "added", "a.proto"), []byte("added"))
+	if content, _ := os.ReadFile(filepath.Join(target, "old.proto")); string(content) != "old" {
+		t.Errorf("target should be unchanged before Commit, got %q", content)
+	}
+
+	if err := s.Commit(); err != nil {
+		t.Fatalf("Commit: %v", err)
+	}
+	s.Discard()
+	want := map[string]string{"old.proto": "new", "docs/README.md": "docs", "added/a.proto": "added"}
+	if got := readTree(t, target); !reflect.DeepEqual(got, want) {
+		t.Errorf("target = %v, want %v", got, want)
+	}
+	if info, err := os.Stat(filepath.Join(target, "old.proto")); err != nil || info.Mode().Perm() != 0o600 {
+		t.Errorf("a copied file should keep its mode, got %v, %v", info, err)
+	}
+	assertOnlyEntry(t, parent, "out")
+}
+
+func TestStageDiscard(t *testing.T) {
+	parent := t.TempDir()
+	target := filepath.Join(parent, "out")
+	WriteFile(filepath.Join(target, "a.proto"), []byte("a"))
+
+	s, err := NewStage(target)
+	if err != nil {
+		t.Fatalf("NewStage: %v", err)
+	}
+	WriteFile(filepath.Join(s.Dir, "a.proto"), []byte("partial"))
+	s.Discard()
+
+	if got := readTree(t, target); !reflect.DeepEqual(got, map[string]string{"a.proto": "a"}) {
+		t.Errorf("target should keep its content, got %v", got)
+	}
+	assertOnlyEntry(t, parent, "out")
+}
+
+func TestStageNewTarget(t *testing.T) {
+	parent := t.TempDir()
+	target := filepath.Join(parent, "nested", "out")
+	s, err := NewStage(target)
+	if err != nil {
+		t.Fatalf("NewStage: %v", err)
+	}
+	WriteFile(filepath.Join(s.Dir, "a.proto"), []byte("a"))
+	if err := s.Commit(); err != nil {
+		t.Fatalf("Commit: %v", err)
+	}
+	s.Discard()
+	info, err := os.Stat(target)
+	if err != nil || info.Mode().Perm() != 0o755 {
+		t.Errorf("expected a 0755 output directory, got %v, %v", info, err)
+	}
+	assertOnlyEntry(t, target, "a.proto")
+	assertOnlyEntry(t, filepath.Dir(target), "out")
+}
+
+func TestStageCommitFailure(t *testing.T) {
+	parent := t.TempDir()
+	target := filepath.Join(parent, "out")
+	WriteFile(filepath.Join(target, "a.proto"), []byte("old"))
+	WriteFile(filepath.Join(target, ManifestName), []byte("old manifest"))
+
+	s, err := NewStage(target)
+	if err != nil {
+		t.Fatalf("NewStage: %v", err)
+	}
+	defer s.Discard()
+	WriteFile(filepath.Join(s.Dir, "a.proto"), []byte("new"))
+	WriteFile(filepath.Join(s.Dir, ManifestName), []byte("new manifest"))
+
+	// Moving the stage into place fails after the target was moved aside
+	defer func(orig func(string, string) error) { rename = orig }(rename)
+	rename = func(from, to string) error {
+		if from == s.Dir {
+			return errors.New("rename failed")
+		}
+		return os.Rename(from, to)
+	}
+	if err := s.Commit(); err == nil {
+		t.Fatal("expected Commit to fail")
+	}
+	s.Discard()
+
+	want := map[string]string{"a.proto": "old", ManifestName: "old manifest"}
+	if got := readTree(t, target); !reflect.DeepEqual(got, want) {
+		t.Errorf("target should keep its previous content and manifest, got %v", got)
+	}
+	assertOnlyEntry(t, parent, "out")
+}
+
+func TestNewStageRejectsFile(t *testing.T) {
+	parent := t.TempDir()
+	target := filepath.Join(parent, "out")
+	os.WriteFile(target, []byte("file"), 0o644)
+	if _, err := NewStage(target); err == nil {
+		t.Fatal("expected an error for a target that is a file")
+	}
+	assertOnlyEntry(t, parent, "out")
+}
+
+func TestStageSymlinkTarget(t *testing.T) {
+	parent := t.TempDir()
+	linked := filepath.Join(parent, "linked")
+	WriteFile(filepath.Join(linked, "a.proto"), []byte("old"))
+	link := filepath.Join(parent, "out")
+	if err := os.Symlink(linked, link); err != nil {
+		t.Skipf("symlinks not supported: %v", err)
+	}
+
+	s, err := NewStage(link)
+	if err != nil {
+		t.Fatalf("NewStage: %v", err)
+	}
+	WriteFile(filepath.Join(s.Dir, "a.proto"), []byte("new"))
+	if err := s.Commit(); err != nil {
+		t.Fatalf("Commit: %v", err)
+	}
+
+	if info, err := os.Lstat(link); err != nil || info.Mode()&os.ModeSymlink == 0 {
+		t.Errorf("the output link should stay a link, got %v, %v", info, err)
+	}
+	if got := readTree(t, linked); !reflect.DeepEqual(got, map[string]string{"a.proto": "new"}) {
+		t.Errorf("the linked directory should be updated, got %v", got)
+	}
+}
+
+// readTree returns the content of the regular files under dir by slash
+// path.
+func readTree(t *testing.T, dir string) map[string]string {
+	t.Helper()
+	files := make(map[string]string)
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() {
+			return err
+		}
+		rel, _ := filepath.Rel(dir, path)
+		content, err := os.ReadFile(path)
+		files[filepath.ToSlash(rel)] = string(content)
+		return err
+	})
+	if err != nil {
+		t.Fatalf("reading %s: %v", dir, err)
+	}
+	return files
+}
+
+// assertOnlyEntry fails unless dir holds just name, so no staging or
+// backup directory was left behind.
+func assertOnlyEntry(t *testing.T, dir, name string) {
+	t.Helper()
+	entries, _ := os.ReadDir(dir)
+	var names []string
+	for _, e := range entries {
+		names = append(names, e.Name())
+	}
+	if !reflect.DeepEqual(names, []string{name}) {
+		t.Errorf("%s contains %v, want only %s", dir, names, name)
+	}
+}
@@ -1,28 +1,247 @@
 package writer
 
 import (
+	"bytes"
 	"os"
 	"path/filepath"
+	"slices"
+	"strings"
 
 	"github.com/emicklei/proto"
 	"github.com/emicklei/proto-contrib/pkg/protofmt"
+
+	"github.com/unitedtraders/proto-filter/internal/config"
 )
 
-// WriteProtoFile formats the AST and writes it to the given path,
-// creating parent directories as needed.
-func WriteProtoFile(definition *proto.Proto, outputPath string) error {
-	dir := filepath.Dir(outputPath)
-	if err := os.MkdirAll(dir, 0o755); err != nil {
+// WriteProtoFile formats the AST according to format and writes it to the
+// given path, creating parent directories as needed.
+func WriteProtoFile(definition *proto.Proto, outputPath string, format config.FormatConfig) error {
+	return WriteFile(outputPath, Render(definition, format))
+}
+
+// WriteFile writes rendered content to the given path, creating parent
+// directories as needed.
+func WriteFile(outputPath string, content []byte) error {
+	if err := os.MkdirAll(filepath.Dir(outputPath), 0o755); err != nil {
 		return err
 	}
+	return os.WriteFile(outputPath, content, 0o644)
+}
 
-	f, err := os.Create(outputPath)
-	if err != nil {
-		return err
+// Render formats the AST according to format. The zero FormatConfig is the
+// default formatting.
+func Render(definition *proto.Proto, format config.FormatConfig) []byte {
+	defer padBlankCommentLines(definition.Elements)()
+	elements := definition.Elements
+	if format.ImportOrder == config.ImportOrderSorted {
+		elements = sortImports(elements)
+	}
+	var buf bytes.Buffer
+	for i, group := range statementGroups(elements) {
+		if i > 0 {
+			blank := 1
+			if isDefinition(group[0]) {
+				blank = format.BlankLineCount()
+			}
+			buf.WriteString(strings.Repeat("\n", blank))
+		}
+		formatter := protofmt.NewFormatter(&buf, format.Indent())
+		formatter.Format(&proto.Proto{Filename: definition.Filename, Elements: group})
+	}
+	out := buf.Bytes()
+	if !format.Aligned() {
+		out = unalign(out, format.Indent())
+	}
+	return trimCommentLines(out)
+}
+
+// padBlankCommentLines replaces the empty lines of single-line comments
+// under elements with a space, since the formatter prints an empty line
+// of a comment above a field or RPC as a blank line that splits the
+// comment. It returns a function that restores the empty lines.
+func padBlankCommentLines(elements []proto.Visitee) (restore func()) {
+	var padded []*string
+	var visit func(elements []proto.Visitee)
+	visit = func(elements []proto.Visitee) {
+		for _, v := range elements {
+			if d, ok := v.(proto.Documented); ok {
+				if c := d.Doc(); c != nil && !c.Cstyle {
+					for i := range c.Lines {
+						if c.Lines[i] == "" {
+							c.Lines[i] = " "
+							padded = append(padded, &c.Lines[i])
+						}
+					}
+				}
+			}
+			switch v := v.(type) {
+			case *proto.Message:
+				visit(v.Elements)
+			case *proto.Service:
+				visit(v.Elements)
+			case *proto.RPC:
+				visit(v.Elements)
+			case *proto.Enum:
+				visit(v.Elements)
+			case *proto.Oneof:
+				visit(v.Elements)
+			case *proto.Group:
+				visit(v.Elements)
+			}
+		}
+	}
+	visit(elements)
+	return func() {
+		for _, line := range padded {
+			*line = ""
+		}
+	}
+}
+
+// trimCommentLines removes trailing spaces from single-line comments.
+func trimCommentLines(formatted []byte) []byte {
+	lines := strings.SplitAfter(string(formatted), "\n")
+	for i, line := range lines {
+		if strings.HasPrefix(strings.TrimLeft(line, " \t"), "//") {
+			text, newline := strings.CutSuffix(line, "\n")
+			lines[i] = strings.TrimRight(text, " \t")
+			if newline {
+				lines[i] += "\n"
+			}
+		}
+	}
+	return []byte(strings.Join(lines, ""))
+}
+
+// statementGroups splits top-level elements into the groups the formatter
+// separates with a blank line: runs of imports, runs of options, and
+// every other element on its own.
+func statementGroups(elements []proto.Visitee) [][]proto.Visitee {
+	var groups [][]proto.Visitee
+	for i, v := range elements {
+		if i > 0 && sameStatement(elements[i-1], v) {
+			groups[len(groups)-1] = append(groups[len(groups)-1], v)
+			continue
+		}
+		groups = append(groups, []proto.Visitee{v})
+	}
+	return groups
+}
+
+func sameStatement(a, b proto.Visitee) bool {
+	switch a.(type) {
+	case *proto.Import:
+		_, ok := b.(*proto.Import)
+		return ok
+	case *proto.Option:
+		_, ok := b.(*proto.Option)
+		return ok
+	}
+	return false
+}
+
+func isDefinition(v proto.Visitee) bool {
+	switch v.(type) {
+	case *proto.Message, *proto.Enum, *proto.Service:
+		return true
+	}
+	return false
+}
+
+// sortImports returns the elements with the imports sorted by path, in
+// the places the imports had.
+func sortImports(elements []proto.Visitee) []proto.Visitee {
+	var imports []*proto.Import
+	for _, v := range elements {
+		if imp, ok := v.(*proto.Import); ok {
+			imports = append(imports, imp)
+		}
+	}
+	slices.SortStableFunc(imports, func(a, b *proto.Import) int {
+		return strings.Compare(a.Filename, b.Filename)
+	})
+	sorted := slices.Clone(elements)
+	next := 0
+	for i, v := range sorted {
+		if _, ok := v.(*proto.Import); ok {
+			sorted[i] = imports[next]
+			next++
+		}
+	}
+	return sorted
+}
+
+// unalign removes the padding the formatter adds to align fields, enum
+// values and RPCs in columns, leaving single spaces between tokens.
+// Comment lines and string literals are kept as they are.
+func unalign(formatted []byte, indent string) []byte {
+	var out bytes.Buffer
+	depth := 0
+	inComment := false
+	for _, line := range strings.SplitAfter(string(formatted), "\n") {
+		trimmed := strings.TrimLeft(line, " \t")
+		switch {
+		case inComment:
+			inComment = !strings.Contains(line, "*/")
+			out.WriteString(line)
+			continue
+		case strings.HasPrefix(trimmed, "/*"):
+			inComment = !strings.Contains(trimmed, "*/")
+			out.WriteString(line)
+			continue
+		case strings.HasPrefix(trimmed, "//"), trimmed == "\n", trimmed == "":
+			out.WriteString(line)
+			continue
+		}
+		level := depth
+		if strings.HasPrefix(trimmed, "}") {
+			level--
+		}
+		code, opened := collapseSpaces(strings.TrimSuffix(trimmed, "\n"))
+		depth += opened
+		out.WriteString(strings.Repeat(indent, max(level, 0)) + code)
+		if strings.HasSuffix(line, "\n") {
+			out.WriteString("\n")
+		}
 	}
-	defer f.Close()
+	return out.Bytes()
+}
 
-	formatter := protofmt.NewFormatter(f, "  ")
-	formatter.Format(definition)
-	return nil
+// collapseSpaces replaces runs of spaces in a line of code with one space
+// and drops spaces just inside parentheses. A trailing comment is kept
+// with one space before it. It also returns the number of braces the line
+// opens less those it closes.
+func collapseSpaces(code string) (string, int) {
+	var b []byte
+	opened := 0
+	var quote byte
+	for i := 0; i < len(code); i++ {
+		c := code[i]
+		switch {
+		case quote != 0:
+			if c == '\\' && i+1 < len(code) {
+				b = append(b, c)
+				i++
+				c = code[i]
+			} else if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			quote = c
+		case c == '/' && strings.HasPrefix(code[i:], "//"):
+			return strings.TrimRight(string(b), " ") + " " + code[i:], opened
+		case c == ' ':
+			if n := len(b); n > 0 && (b[n-1] == ' ' || b[n-1] == '(') {
+				continue
+			}
+		case c == ')':
+			b = bytes.TrimRight(b, " ")
+		case c == '{':
+			opened++
+		case c == '}':
+			opened--
+		}
+		b = append(b, c)
+	}
+	return string(b), opened
 }
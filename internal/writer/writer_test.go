@@ -8,6 +8,7 @@ import (
 
 	"github.com/emicklei/proto"
 
+	"github.com/unitedtraders/proto-filter/internal/config"
 	"github.com/unitedtraders/proto-filter/internal/parser"
 )
 
@@ -31,7 +32,7 @@ func TestWriteProtoFile(t *testing.T) {
 	outputDir := t.TempDir()
 	outputPath := filepath.Join(outputDir, "service.proto")
 
-	if err := WriteProtoFile(def, outputPath); err != nil {
+	if err := WriteProtoFile(def, outputPath, config.FormatConfig{}); err != nil {
 		t.Fatalf("write: %v", err)
 	}
 
@@ -94,7 +95,7 @@ func TestWriteProtoFileCreatesDirectories(t *testing.T) {
 	outputDir := t.TempDir()
 	deepPath := filepath.Join(outputDir, "a", "b", "c", "service.proto")
 
-	if err := WriteProtoFile(def, deepPath); err != nil {
+	if err := WriteProtoFile(def, deepPath, config.FormatConfig{}); err != nil {
 		t.Fatalf("write to deep path: %v", err)
 	}
 
@@ -114,7 +115,7 @@ func TestWritePreservesComments(t *testing.T) {
 	outputDir := t.TempDir()
 	outputPath := filepath.Join(outputDir, "commented.proto")
 
-	if err := WriteProtoFile(def, outputPath); err != nil {
+	if err := WriteProtoFile(def, outputPath, config.FormatConfig{}); err != nil {
 		t.Fatalf("write: %v", err)
 	}
 
@@ -165,7 +166,7 @@ func TestWritePreservesMultilineBlockComments(t *testing.T) {
 	outputDir := t.TempDir()
 	outputPath := filepath.Join(outputDir, "multiline.proto")
 
-	if err := WriteProtoFile(def, outputPath); err != nil {
+	if err := WriteProtoFile(def, outputPath, config.FormatConfig{}); err != nil {
 		t.Fatalf("write: %v", err)
 	}
 
@@ -232,7 +233,7 @@ func TestWritePreservesConsecutiveSingleLineComments(t *testing.T) {
 	outputDir := t.TempDir()
 	outputPath := filepath.Join(outputDir, "multiline.proto")
 
-	if err := WriteProtoFile(def, outputPath); err != nil {
+	if err := WriteProtoFile(def, outputPath, config.FormatConfig{}); err != nil {
 		t.Fatalf("write: %v", err)
 	}
 
@@ -272,3 +273,157 @@ func TestWritePreservesConsecutiveSingleLineComments(t *testing.T) {
 		t.Fatalf("output not parseable after round-trip: %v", err)
 	}
 }
+
+const formatSource = `syntax = "proto3";
+package shop;
+import "b.proto";
+import "a.proto";
+service Orders {
+  rpc Get(GetRequest) returns (Order);
+  rpc Cancel(CancelOrderRequest) returns (Order);
+}
+message Order {
+  string id = 1; // the "ID"
+  repeated int64 item_ids = 20;
+}
+`
+
+func renderWith(t *testing.T, format config.FormatConfig) string {
+	t.Helper()
+	return string(Render(parseSource(t, formatSource), format))
+}
+
+func TestRenderDefaultFormat(t *testing.T) {
+	want := `syntax = "proto3";
+
+package shop;
+
+import "b.proto";
+import "a.proto";
+
+service Orders {
+  rpc Get    (GetRequest        ) returns (Order);
+  rpc Cancel (CancelOrderRequest) returns (Order);
+}
+
+message Order {
+           string id       =  1; // the "ID"
+  repeated int64  item_ids = 20;
+}
+`
+	if got := renderWith(t, config.FormatConfig{}); got != want {
+		t.Errorf("output:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestRenderFormatSettings(t *testing.T) {
+	noAlign, blankLines := false, 2
+	want := `syntax = "proto3";
+
+package shop;
+
+import "a.proto";
+import "b.proto";
+
+
+service Orders {
+	rpc Get (GetRequest) returns (Order);
+	rpc Cancel (CancelOrderRequest) returns (Order);
+}
+
+
+message Order {
+	string id = 1; // the "ID"
+	repeated int64 item_ids = 20;
+}
+`
+	got := renderWith(t, config.FormatConfig{
+		IndentChar:  config.IndentTab,
+		AlignFields: &noAlign,
+		BlankLines:  &blankLines,
+		ImportOrder: config.ImportOrderSorted,
+	})
+	if got != want {
+		t.Errorf("output:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestRenderIndentWidthAndNoBlankLines(t *testing.T) {
+	blankLines := 0
+	got := renderWith(t, config.FormatConfig{IndentWidth: 4, BlankLines: &blankLines})
+	for _, want := range []string{
+		"import \"a.proto\";\nservice Orders {\n    rpc Get    (GetRequest        ) returns (Order);\n",
+		"}\nmessage Order {\n",
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("output should contain %q:\n%s", want, got)
+		}
+	}
+}
+
+func TestRenderBlankCommentLines(t *testing.T) {
+	def := parseSource(t, `syntax = "proto3";
+
+package shop;
+
+message Order {
+  // Identifies the order.
+  //
+  // Never reused.
+  string id = 1;
+}
+`)
+	field := def.Elements[2].(*proto.Message).Elements[0].(*proto.NormalField)
+	field.Comment.Lines = append(field.Comment.Lines, " Trailing space. ")
+
+	got := string(Render(def, config.FormatConfig{}))
+	want := "  // Identifies the order.\n  //\n  // Never reused.\n  // Trailing space.\n  string id = 1;\n"
+	if !strings.Contains(got, want) {
+		t.Errorf("output should contain %q:\n%s", want, got)
+	}
+	if field.Comment.Lines[1] != "" {
+		t.Errorf("Render should leave the comment unchanged, got %q", field.Comment.Lines)
+	}
+}
+
+func TestCollapseSpaces(t *testing.T) {
+	tests := []struct {
+		in     string
+		want   string
+		opened int
+	}{
+		{"rpc Get    (GetRequest        ) returns (Order);", "rpc Get (GetRequest) returns (Order);", 0},
+		{"string id       =  1; // keep   this", "string id = 1; // keep   this", 0},
+		{`string s = 1 [default = "a   b"];`, `string s = 1 [default = "a   b"];`, 0},
+		{"message A {", "message A {", 1},
+		{"}", "}", -1},
+	}
+	for _, tt := range tests {
+		got, opened := collapseSpaces(tt.in)
+		if got != tt.want || opened != tt.opened {
+			t.Errorf("collapseSpaces(%q) = %q, %d; want %q, %d", tt.in, got, opened, tt.want, tt.opened)
+		}
+	}
+}
+
+func TestAddHeader(t *testing.T) {
+	content := []byte("// Copyright 2024.\n\nsyntax = \"proto3\";\n")
+	got := string(AddHeader(content, "Code generated. DO NOT EDIT.\n\nSource: a.proto\n"))
+	want := "// Code generated. DO NOT EDIT.\n//\n// Source: a.proto\n\n// Copyright 2024.\n\nsyntax = \"proto3\";\n"
+	if got != want {
+		t.Errorf("AddHeader() =\n%s\nwant:\n%s", got, want)
+	}
+	if got := AddHeader(content, ""); string(got) != string(content) {
+		t.Errorf("expected an empty header to leave content unchanged, got:\n%s", got)
+	}
+}
+
+func TestContentHash(t *testing.T) {
+	got := ContentHash([]byte("syntax = \"proto3\";\n"))
+	if len(got) != 64 || got != ContentHash([]byte("syntax = \"proto3\";\n")) {
+		t.Errorf("ContentHash() = %q, want a stable SHA-256 hex digest", got)
+	}
+	if got == ContentHash([]byte("syntax = \"proto2\";\n")) {
+		t.Error("expected different content to hash differently")
+	}
+}
@@ -4,15 +4,22 @@ import (
 	"flag"
 	"fmt"
 	"os"
+	"os/exec"
 	"path/filepath"
 	"sort"
+	"strings"
+	"text/template"
+	"time"
 
 	"github.com/emicklei/proto"
 
+	"github.com/unitedtraders/proto-filter/internal/annotation"
 	"github.com/unitedtraders/proto-filter/internal/config"
 	"github.com/unitedtraders/proto-filter/internal/deps"
+	"github.com/unitedtraders/proto-filter/internal/diag"
 	"github.com/unitedtraders/proto-filter/internal/filter"
 	"github.com/unitedtraders/proto-filter/internal/parser"
+	"github.com/unitedtraders/proto-filter/internal/report"
 	"github.com/unitedtraders/proto-filter/internal/writer"
 )
 
@@ -21,42 +28,60 @@ func main() {
 }
 
 func run() int {
+	if len(os.Args) > 1 && os.Args[1] == "annotations" {
+		return runAnnotations(os.Args[2:])
+	}
+
 	inputDir := flag.String("input", "", "path to directory containing source .proto files")
 	outputDir := flag.String("output", "", "path to directory where filtered .proto files are written")
 	configFile := flag.String("config", "", "path to YAML filter configuration file")
 	verbose := flag.Bool("verbose", false, "print processing summary to stderr")
+	tier := flag.String("tier", "", "generate output for this visibility tier (overrides tiers.select in config)")
+	apiVersion := flag.String("api-version", "", "keep only elements whose @Since/@Until range contains this version (overrides api_version in config)")
+	diagnosticsFormat := flag.String("diagnostics-format", "text", "format of errors and warnings: text (stderr), json or sarif (stdout)")
+	reportFile := flag.String("report", "", "write a JSON report of the run to this path")
+	preserveFormatting := flag.Bool("preserve-formatting", false, "edit the source text instead of reformatting, so unchanged lines are kept as they are")
+	clean := flag.Bool("clean", false, "remove output files written by an earlier run that this run no longer produces; every run records the files it writes in "+writer.ManifestName+" in the output directory")
+	check := flag.Bool("check", false, "compare the output with the output directory, print the differences and exit 3 if there are any, without writing")
 
 	flag.Parse()
 
+	diags, err := diag.NewReporter(*diagnosticsFormat, os.Stderr, os.Stdout)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "proto-filter: error: %v\n", err)
+		return 1
+	}
+	defer diags.Flush()
+
 	if *inputDir == "" || *outputDir == "" {
-		fmt.Fprintln(os.Stderr, "proto-filter: error: --input and --output flags are required")
+		diags.Report(diag.Errorf(diag.RuleUsage, "--input and --output flags are required"))
 		flag.Usage()
 		return 1
 	}
 
 	absInput, err := filepath.Abs(*inputDir)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "proto-filter: error: %v\n", err)
+		diags.Report(diag.Errorf(diag.RuleUsage, "%v", err))
 		return 1
 	}
 	absOutput, err := filepath.Abs(*outputDir)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "proto-filter: error: %v\n", err)
+		diags.Report(diag.Errorf(diag.RuleUsage, "%v", err))
 		return 1
 	}
 
 	if absInput == absOutput {
-		fmt.Fprintln(os.Stderr, "proto-filter: error: input and output directories must be different")
+		diags.Report(diag.Errorf(diag.RuleUsage, "input and output directories must be different"))
 		return 1
 	}
 
 	info, err := os.Stat(absInput)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "proto-filter: error: input directory not found: %s\n", absInput)
+		diags.Report(diag.Errorf(diag.RuleUsage, "input directory not found: %s", absInput))
 		return 1
 	}
 	if !info.IsDir() {
-		fmt.Fprintf(os.Stderr, "proto-filter: error: input path is not a directory: %s\n", absInput)
+		diags.Report(diag.Errorf(diag.RuleUsage, "input path is not a directory: %s", absInput))
 		return 1
 	}
 
@@ -66,24 +91,41 @@ func run() int {
 		var err error
 		cfg, err = config.LoadConfig(*configFile)
 		if err != nil {
-			fmt.Fprintf(os.Stderr, "proto-filter: error: %v\n", err)
+			diags.Report(diag.Errorf(diag.RuleConfig, "%v", err).At(*configFile, 0, 0))
+			return 2
+		}
+	}
+	if *apiVersion != "" {
+		if cfg == nil {
+			cfg = &config.FilterConfig{}
+		}
+		cfg.APIVersion = *apiVersion
+	}
+	if *tier != "" {
+		if cfg == nil || cfg.Tiers == nil {
+			diags.Report(diag.Errorf(diag.RuleUsage, "--tier requires a config with a tiers section"))
 			return 2
 		}
+		cfg.Tiers.Select = *tier
+	}
+	if cfg != nil {
 		if err := cfg.Validate(); err != nil {
-			fmt.Fprintf(os.Stderr, "proto-filter: error: %v\n", err)
+			diags.Report(diag.Errorf(diag.RuleConfig, "%v", err).At(*configFile, 0, 0))
 			return 2
 		}
 	}
+	opts := filter.NewOptions(cfg)
 
 	// Discover proto files
+	start := time.Now()
 	files, err := parser.DiscoverProtoFiles(absInput)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "proto-filter: error: discovering proto files: %v\n", err)
+		diags.Report(diag.Errorf(diag.RuleIO, "discovering proto files: %v", err))
 		return 1
 	}
 
 	if len(files) == 0 {
-		fmt.Fprintln(os.Stderr, "proto-filter: warning: no .proto files found in input directory")
+		diags.Report(diag.Warningf(diag.RuleNoInput, "no .proto files found in input directory"))
 		return 0
 	}
 
@@ -95,22 +137,64 @@ func run() int {
 	}
 	parsed := make([]parsedFile, 0, len(files))
 
-	for _, rel := range files {
-		inPath := filepath.Join(absInput, rel)
-		def, err := parser.ParseProtoFile(inPath)
-		if err != nil {
-			fmt.Fprintf(os.Stderr, "proto-filter: error: parsing %s: %v\n", rel, err)
-			return 1
+	defs, parseErrs := parser.ParseFiles(absInput, files)
+	if len(parseErrs) > 0 {
+		reportParseErrors(diags, *inputDir, parseErrs)
+		return 1
+	}
+	for i, rel := range files {
+		pkg := parser.ExtractPackage(defs[i])
+		parsed = append(parsed, parsedFile{rel, defs[i], pkg})
+	}
+	runReport := report.New(*inputDir, *outputDir, *configFile, files)
+	runReport.Phase("parse", start)
+	start = time.Now()
+
+	// Report substitution keys and annotation filter names that match no
+	// annotation in the input
+	if cfg != nil {
+		seen := make(map[string]bool)
+		for _, pf := range parsed {
+			for name := range filter.CollectAllAnnotations(pf.def, opts) {
+				seen[name] = true
+			}
+			for name := range filter.CollectOptionAnnotations(pf.def, opts) {
+				seen[name] = true
+			}
+		}
+		report := diag.Warningf
+		if cfg.StrictUnused {
+			report = diag.Errorf
+		}
+		unusedSubs := filter.UnusedNames(cfg.SubstitutionNames(), seen)
+		unusedFilters := filter.UnusedNames(cfg.AnnotationFilterNames(), seen)
+		if diags.Structured() {
+			for _, name := range unusedSubs {
+				diags.Report(report(diag.RuleUnusedSubstitution, "unused substitution key: %s", name).At(*configFile, 0, 0))
+			}
+			for _, name := range unusedFilters {
+				diags.Report(report(diag.RuleUnusedAnnotationFilter, "unused annotation filter name: %s", name).At(*configFile, 0, 0))
+			}
+		} else {
+			if len(unusedSubs) > 0 {
+				diags.Report(report(diag.RuleUnusedSubstitution, "unused substitution keys: %s", joinNames(unusedSubs)))
+			}
+			if len(unusedFilters) > 0 {
+				diags.Report(report(diag.RuleUnusedAnnotationFilter, "unused annotation filter names: %s", joinNames(unusedFilters)))
+			}
+		}
+		if cfg.StrictUnused && len(unusedSubs)+len(unusedFilters) > 0 {
+			return 2
 		}
-		pkg := parser.ExtractPackage(def)
-		parsed = append(parsed, parsedFile{rel, def, pkg})
 	}
 
 	// Determine total definitions count
 	totalDefs := 0
 	graph := deps.NewGraph()
+	fileDefs := make(map[string][]parser.DefinitionInfo, len(parsed))
 	for _, pf := range parsed {
 		defs := parser.ExtractDefinitions(pf.def, pf.pkg)
+		fileDefs[pf.rel] = defs
 		totalDefs += len(defs)
 		for _, d := range defs {
 			graph.AddDefinition(&deps.Definition{
@@ -136,7 +220,24 @@ func run() int {
 
 		included, err := filter.ApplyFilter(cfg, allFQNs)
 		if err != nil {
-			fmt.Fprintf(os.Stderr, "proto-filter: error: %v\n", err)
+			diags.Report(diag.Errorf(diag.RuleFilter, "%v", err))
+			return 2
+		}
+
+		// Report include and exclude patterns that match no definition
+		reportUnmatched := diag.Warningf
+		if cfg.StrictUnused {
+			reportUnmatched = diag.Errorf
+		}
+		unmatched := filter.UnmatchedPatterns(append(append([]string(nil), cfg.Include...), cfg.Exclude...), allFQNs)
+		if diags.Structured() {
+			for _, p := range unmatched {
+				diags.Report(reportUnmatched(diag.RuleUnmatchedPattern, "unmatched pattern: %s", p).At(*configFile, 0, 0))
+			}
+		} else if len(unmatched) > 0 {
+			diags.Report(reportUnmatched(diag.RuleUnmatchedPattern, "unmatched patterns: %s", joinNames(unmatched)))
+		}
+		if cfg.StrictUnused && len(unmatched) > 0 {
 			return 2
 		}
 
@@ -160,6 +261,9 @@ func run() int {
 		for _, f := range requiredFiles {
 			filesToWrite[f] = true
 		}
+		for _, pf := range parsed {
+			runReport.Remove(pf.rel, report.ReasonIncludeExclude, removedDefinitions(fileDefs[pf.rel], keepFQNs))
+		}
 	} else {
 		// No filtering: write all files
 		for _, pf := range parsed {
@@ -167,23 +271,29 @@ func run() int {
 		}
 	}
 
+	runReport.Phase("resolve", start)
+	start = time.Now()
+
 	// Pass 1: Prune, filter, convert block comments, collect annotations
 	type processedFile struct {
 		pf   parsedFile
 		skip bool // true if file has no remaining definitions after filtering
 	}
 	processed := make([]processedFile, 0, len(parsed))
-	servicesRemoved := 0
-	messagesRemoved := 0
-	methodsRemoved := 0
-	fieldsRemoved := 0
-	orphansRemoved := 0
+	var annotationStats filter.PruneStats
+	var versionStats filter.PruneStats
+	var featureStats filter.PruneStats
+	var tierStats filter.PruneStats
 	var allLocations []filter.AnnotationLocation
+	aliasUses := make(map[string]string)
 
 	for _, pf := range parsed {
 		if !filesToWrite[pf.rel] {
 			continue
 		}
+		for alias, canonical := range filter.CollectAliasUses(pf.def, opts) {
+			aliasUses[alias] = canonical
+		}
 		if keepFQNs != nil {
 			filter.PruneAST(pf.def, pf.pkg, keepFQNs)
 		}
@@ -191,47 +301,58 @@ func run() int {
 		skip := false
 		// Annotation-based filtering
 		if cfg != nil && cfg.HasAnnotations() {
-			var sr, msgr, mr, fr int
-			var includeRoots map[string]bool
-			if cfg.HasAnnotationInclude() {
-				sr += filter.IncludeServicesByAnnotation(pf.def, cfg.Annotations.Include)
-				includeRoots = filter.CollectIncludeMessageRoots(pf.def, cfg.Annotations.Include)
-				msgr += filter.IncludeMessagesByAnnotation(pf.def, cfg.Annotations.Include)
-				if !cfg.HasAnnotationExclude() {
-					// Include-only mode: also filter methods by include annotations
-					mr += filter.IncludeMethodsByAnnotation(pf.def, cfg.Annotations.Include)
-				}
+			stats, err := filter.ApplyAnnotationFilters(pf.def, pf.pkg, cfg.Annotations, opts)
+			if err != nil {
+				diags.Report(diag.Errorf(diag.RuleFilter, "%v", err))
+				return 2
 			}
-			if cfg.HasAnnotationExclude() {
-				sr += filter.FilterServicesByAnnotation(pf.def, cfg.Annotations.Exclude)
-				mr += filter.FilterMethodsByAnnotation(pf.def, cfg.Annotations.Exclude)
-				fr += filter.FilterFieldsByAnnotation(pf.def, cfg.Annotations.Exclude)
+			annotationStats.Add(stats)
+			runReport.Remove(pf.rel, report.ReasonAnnotations, stats)
+
+			if !filter.HasRemainingDefinitions(pf.def) {
+				skip = true
 			}
-			servicesRemoved += sr
-			messagesRemoved += msgr
-			methodsRemoved += mr
-			fieldsRemoved += fr
-			filter.RemoveEmptyServices(pf.def)
-			if sr > 0 || mr > 0 || fr > 0 {
-				orphansRemoved += filter.RemoveOrphanedDefinitions(pf.def, pf.pkg, includeRoots)
+		}
+
+		// API version filtering
+		if cfg != nil && cfg.HasAPIVersion() {
+			target, _ := annotation.ParseVersion(cfg.APIVersion)
+			stats := filter.FilterByVersion(pf.def, pf.pkg, target, opts)
+			versionStats.Add(stats)
+			runReport.Remove(pf.rel, report.ReasonAPIVersion, stats)
+			if !filter.HasRemainingDefinitions(pf.def) {
+				skip = true
 			}
+		}
 
+		// Feature flag filtering
+		if cfg != nil && cfg.HasFeatures() {
+			stats := filter.FilterByFeatures(pf.def, pf.pkg, cfg.Features.Enabled, opts)
+			featureStats.Add(stats)
+			runReport.Remove(pf.rel, report.ReasonFeatures, stats)
 			if !filter.HasRemainingDefinitions(pf.def) {
 				skip = true
 			}
+		}
 
-			// Strip include annotation markers from output
-			if cfg.HasAnnotationInclude() {
-				filter.StripAnnotations(pf.def, cfg.Annotations.Include)
+		// Tier filtering
+		if cfg != nil && cfg.HasTiers() {
+			stats := filter.FilterByTier(pf.def, pf.pkg, *cfg.Tiers, cfg.Tiers.Select, opts)
+			tierStats.Add(stats)
+			runReport.Remove(pf.rel, report.ReasonTier, stats)
+			if !filter.HasRemainingDefinitions(pf.def) {
+				skip = true
 			}
 		}
 
 		// Convert block comments to single-line style
-		filter.ConvertBlockComments(pf.def)
+		if cfg == nil || cfg.Format.ConvertsBlockComments() {
+			filter.ConvertBlockComments(pf.def)
+		}
 
 		// Collect annotation locations for strict mode check
 		if cfg != nil && cfg.StrictSubstitutions {
-			allLocations = append(allLocations, filter.CollectAnnotationLocations(pf.def, pf.rel)...)
+			allLocations = append(allLocations, filter.CollectAnnotationLocations(pf.def, pf.rel, opts)...)
 		}
 
 		processed = append(processed, processedFile{pf: pf, skip: skip})
@@ -243,27 +364,40 @@ func run() int {
 		missingNames := make(map[string]bool)
 		var missingLocations []filter.AnnotationLocation
 		for _, loc := range allLocations {
-			if _, ok := cfg.Substitutions[loc.Name]; !ok {
+			if sub, ok := filter.LookupSubstitution(cfg.Substitutions, loc.Name); ok {
+				if _, ok := sub.Text(loc.Kind); ok {
+					continue
+				}
+			}
+			if _, ok := annotation.Lookup(cfg.AnnotationOptions, loc.Name); !ok {
 				missingNames[loc.Name] = true
 				missingLocations = append(missingLocations, loc)
 			}
 		}
 		if len(missingNames) > 0 {
+			sort.Slice(missingLocations, func(i, j int) bool {
+				if missingLocations[i].File != missingLocations[j].File {
+					return missingLocations[i].File < missingLocations[j].File
+				}
+				return missingLocations[i].Line < missingLocations[j].Line
+			})
+			if diags.Structured() {
+				for _, loc := range missingLocations {
+					diags.Report(diag.Errorf(diag.RuleUnsubstitutedAnnotation, "unsubstituted annotation %s", loc.Token).
+						At(filepath.Join(*inputDir, loc.File), loc.Line, loc.Column))
+				}
+				return 2
+			}
+
 			// Print summary line (backward compatible)
 			names := make([]string, 0, len(missingNames))
 			for name := range missingNames {
 				names = append(names, name)
 			}
 			sort.Strings(names)
-			fmt.Fprintf(os.Stderr, "proto-filter: error: unsubstituted annotations found: %s\n", joinNames(names))
+			diags.Report(diag.Errorf(diag.RuleUnsubstitutedAnnotation, "unsubstituted annotations found: %s", joinNames(names)))
 
 			// Print location lines sorted by file then line
-			sort.Slice(missingLocations, func(i, j int) bool {
-				if missingLocations[i].File != missingLocations[j].File {
-					return missingLocations[i].File < missingLocations[j].File
-				}
-				return missingLocations[i].Line < missingLocations[j].Line
-			})
 			for _, loc := range missingLocations {
 				fmt.Fprintf(os.Stderr, "  %s:%d: %s\n", loc.File, loc.Line, loc.Token)
 			}
@@ -271,39 +405,211 @@ func run() int {
 		}
 	}
 
-	// Pass 2: Substitute annotations and write output
-	writtenCount := 0
+	runReport.Phase("filter", start)
+	start = time.Now()
+
+	// Header template and the data shared by all files
+	var header *template.Template
+	var headerData config.HeaderData
+	if cfg != nil {
+		header, _ = cfg.HeaderTemplate()
+	}
+	if header != nil {
+		headerData = config.HeaderData{
+			Config:  filepath.ToSlash(*configFile),
+			Profile: cfg.ProfileName(*configFile),
+			Commit:  gitCommit(absInput),
+		}
+	}
+
+	// Pass 2: Substitute annotations and render output
+	var outputs []outputFile
 	substitutionCount := 0
+	substitutionFailed := false
+	optionCount := 0
 	for _, pf := range processed {
 		if pf.skip {
 			continue
 		}
 
+		if cfg != nil && cfg.HasAnnotationOptions() {
+			optionCount += filter.ConvertAnnotationsToOptions(pf.pf.def, cfg.AnnotationOptions, opts)
+		}
 		if cfg != nil && cfg.HasSubstitutions() {
-			substitutionCount += filter.SubstituteAnnotations(pf.pf.def, cfg.Substitutions)
+			counts, errs := filter.SubstituteAnnotationsByName(pf.pf.def, cfg.Substitutions, opts)
+			for _, e := range errs {
+				diags.Report(diag.Errorf(diag.RuleSubstitutionTemplate, "%s:%d:%d: %v", pf.pf.rel, e.Line, e.Column, e).At(filepath.Join(*inputDir, pf.pf.rel), e.Line, e.Column))
+				substitutionFailed = true
+			}
+			for _, n := range counts {
+				substitutionCount += n
+			}
+			runReport.Substitute(pf.pf.rel, counts)
 		}
 
-		outPath := filepath.Join(absOutput, pf.pf.rel)
-		if err := writer.WriteProtoFile(pf.pf.def, outPath); err != nil {
-			fmt.Fprintf(os.Stderr, "proto-filter: error: writing %s: %v\n", pf.pf.rel, err)
+		var content []byte
+		var err error
+		if *preserveFormatting {
+			var source []byte
+			if source, err = os.ReadFile(filepath.Join(absInput, pf.pf.rel)); err == nil {
+				content, err = writer.EditSource(pf.pf.def, source)
+			}
+		} else {
+			var format config.FormatConfig
+			if cfg != nil {
+				format = cfg.Format
+				filter.WrapComments(pf.pf.def, format.CommentWidth)
+			}
+			content = writer.Render(pf.pf.def, format)
+		}
+		if err == nil && header != nil {
+			data := headerData
+			data.Source = filepath.ToSlash(pf.pf.rel)
+			data.Hash = writer.ContentHash(content)
+			var text strings.Builder
+			if err = header.Execute(&text, data); err == nil {
+				content = writer.AddHeader(content, text.String())
+			}
+		}
+		if err != nil {
+			diags.Report(diag.Errorf(diag.RuleIO, "rendering %s: %v", pf.pf.rel, err).At(filepath.Join(*inputDir, pf.pf.rel), 0, 0))
+			return 1
+		}
+		outputs = append(outputs, outputFile{pf.pf.rel, content})
+		if !*check {
+			runReport.Written(pf.pf.rel)
+		}
+	}
+	if substitutionFailed {
+		return 2
+	}
+
+	// Write the output, or with --check compare it with what is there
+	status := 0
+	var removed []string
+	if *check {
+		w := os.Stdout
+		if diags.Structured() {
+			w = os.Stderr
+		}
+		differences, err := checkOutput(w, absOutput, *outputDir, outputs, *clean)
+		if err != nil {
+			diags.Report(diag.Errorf(diag.RuleIO, "checking output: %v", err).At(*outputDir, 0, 0))
+			return 1
+		}
+		if differences > 0 {
+			verb := "differ"
+			if differences == 1 {
+				verb = "differs"
+			}
+			diags.Report(diag.Errorf(diag.RuleOutdatedOutput, "%s is out of date: %s %s from this run's output", *outputDir, plural(differences, "file"), verb).At(*outputDir, 0, 0))
+			status = 3
+		}
+		runReport.Phase("check", start)
+	} else {
+		var ok bool
+		if removed, ok = writeOutput(diags, absOutput, *outputDir, outputs, *clean); !ok {
+			return 1
+		}
+		runReport.Phase("write", start)
+	}
+
+	if *reportFile != "" {
+		runReport.Options = optionCount
+		if cfg != nil {
+			var err error
+			if runReport.Config, err = cfg.Map(); err != nil {
+				diags.Report(diag.Errorf(diag.RuleIO, "writing report: %v", err).At(*reportFile, 0, 0))
+				return 1
+			}
+		}
+		if err := runReport.Write(*reportFile); err != nil {
+			diags.Report(diag.Errorf(diag.RuleIO, "writing report: %v", err).At(*reportFile, 0, 0))
 			return 1
 		}
-		writtenCount++
 	}
 
 	if *verbose {
 		fmt.Fprintf(os.Stderr, "proto-filter: processed %d files, %d definitions\n", len(files), totalDefs)
 		fmt.Fprintf(os.Stderr, "proto-filter: included %d definitions, excluded %d\n", includedCount, excludedCount)
 		if cfg != nil && cfg.HasAnnotations() {
-			fmt.Fprintf(os.Stderr, "proto-filter: removed %d services by annotation, %d messages by annotation, %d methods by annotation, %d fields by annotation, %d orphaned definitions\n", servicesRemoved, messagesRemoved, methodsRemoved, fieldsRemoved, orphansRemoved)
+			fmt.Fprintf(os.Stderr, "proto-filter: removed %d services by annotation, %d messages by annotation, %d methods by annotation, %d fields by annotation, %d enum values by annotation, %d orphaned definitions\n",
+				annotationStats.Services, annotationStats.Messages, annotationStats.Methods, annotationStats.Fields, annotationStats.EnumValues, annotationStats.Orphans)
+		}
+		if cfg != nil && cfg.HasAPIVersion() {
+			fmt.Fprintf(os.Stderr, "proto-filter: removed %d services, %d methods, %d messages, %d fields, %d enum values outside API version %s, %d orphaned definitions\n",
+				versionStats.Services, versionStats.Methods, versionStats.Messages, versionStats.Fields, versionStats.EnumValues, cfg.APIVersion, versionStats.Orphans)
+		}
+		if cfg != nil && cfg.HasFeatures() {
+			fmt.Fprintf(os.Stderr, "proto-filter: removed %d services, %d methods, %d messages, %d fields, %d enum values behind disabled features, %d orphaned definitions\n",
+				featureStats.Services, featureStats.Methods, featureStats.Messages, featureStats.Fields, featureStats.EnumValues, featureStats.Orphans)
+		}
+		if cfg != nil && cfg.HasTiers() {
+			fmt.Fprintf(os.Stderr, "proto-filter: removed %d services, %d methods, %d messages, %d fields, %d enum values above tier %s, %d orphaned definitions\n",
+				tierStats.Services, tierStats.Methods, tierStats.Messages, tierStats.Fields, tierStats.EnumValues, cfg.Tiers.Select, tierStats.Orphans)
+		}
+		if len(aliasUses) > 0 {
+			aliases := make([]string, 0, len(aliasUses))
+			for alias, canonical := range aliasUses {
+				aliases = append(aliases, alias+" -> "+canonical)
+			}
+			sort.Strings(aliases)
+			fmt.Fprintf(os.Stderr, "proto-filter: resolved annotation aliases: %s\n", joinNames(aliases))
+		}
+		if cfg != nil && cfg.HasAnnotationOptions() {
+			fmt.Fprintf(os.Stderr, "proto-filter: converted annotations into %d options\n", optionCount)
 		}
 		if cfg != nil && cfg.HasSubstitutions() {
 			fmt.Fprintf(os.Stderr, "proto-filter: substituted %d annotations\n", substitutionCount)
 		}
-		fmt.Fprintf(os.Stderr, "proto-filter: wrote %d files to %s\n", writtenCount, *outputDir)
+		if *check {
+			fmt.Fprintf(os.Stderr, "proto-filter: checked %d files against %s\n", len(outputs), *outputDir)
+		} else {
+			fmt.Fprintf(os.Stderr, "proto-filter: wrote %d files to %s\n", len(outputs), *outputDir)
+		}
+		if *clean && !*check {
+			fmt.Fprintf(os.Stderr, "proto-filter: removed %d stale files\n", len(removed))
+		}
+	}
+
+	return status
+}
+
+// reportParseErrors reports every file that failed to parse, with the
+// offending source line. File paths are relative to inputDir.
+func reportParseErrors(diags *diag.Reporter, inputDir string, errs []*parser.Error) {
+	for _, e := range errs {
+		diags.Report(diag.Errorf(diag.RuleParse, "parsing %v", e).
+			At(filepath.Join(inputDir, e.File), e.Line, e.Column).
+			WithSource(e.Source))
 	}
+}
 
-	return 0
+// gitCommit returns the abbreviated commit checked out in the repository
+// containing dir, or "" if dir is not in a git repository.
+func gitCommit(dir string) string {
+	out, err := exec.Command("git", "-C", dir, "rev-parse", "--short", "HEAD").Output()
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(out))
+}
+
+// removedDefinitions counts the top-level definitions of a file that are
+// not in keep.
+func removedDefinitions(defs []parser.DefinitionInfo, keep map[string]bool) filter.PruneStats {
+	var stats filter.PruneStats
+	for _, d := range defs {
+		if keep[d.FQN] {
+			continue
+		}
+		if d.Kind == "service" {
+			stats.Services++
+		} else {
+			stats.Messages++
+		}
+	}
+	return stats
 }
 
 func joinNames(names []string) string {
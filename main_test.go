@@ -1,21 +1,52 @@
 package main
 
 import (
+	"encoding/json"
+	"fmt"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"reflect"
 	"strings"
+	"sync"
 	"testing"
+
+	"github.com/unitedtraders/proto-filter/internal/config"
+	"github.com/unitedtraders/proto-filter/internal/diag"
+	"github.com/unitedtraders/proto-filter/internal/filter"
+	"github.com/unitedtraders/proto-filter/internal/report"
+	"github.com/unitedtraders/proto-filter/internal/writer"
+)
+
+// binDir holds the binary the CLI tests share; buildBinary builds it on
+// first use.
+var (
+	binDir    string
+	buildOnce sync.Once
+	buildErr  error
 )
 
+func TestMain(m *testing.M) {
+	var err error
+	if binDir, err = os.MkdirTemp("", "proto-filter-test-"); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	code := m.Run()
+	os.RemoveAll(binDir)
+	os.Exit(code)
+}
+
 func buildBinary(t *testing.T) string {
 	t.Helper()
-	tmp := t.TempDir()
-	bin := filepath.Join(tmp, "proto-filter")
-	cmd := exec.Command("go", "build", "-o", bin, ".")
-	cmd.Stderr = os.Stderr
-	if err := cmd.Run(); err != nil {
-		t.Fatalf("build: %v", err)
+	bin := filepath.Join(binDir, "proto-filter")
+	buildOnce.Do(func() {
+		cmd := exec.Command("go", "build", "-o", bin, ".")
+		cmd.Stderr = os.Stderr
+		buildErr = cmd.Run()
+	})
+	if buildErr != nil {
+		t.Fatalf("build: %v", buildErr)
 	}
 	return bin
 }
@@ -38,6 +69,26 @@ func runBinary(t *testing.T, bin string, args ...string) (stderr string, exitCod
 	return stderr, 0
 }
 
+// runBinaryOutput is like runBinary but also returns stdout.
+func runBinaryOutput(t *testing.T, bin string, args ...string) (stdout, stderr string, exitCode int) {
+	t.Helper()
+	cmd := exec.Command(bin, args...)
+	var stdoutBuf, stderrBuf strings.Builder
+	cmd.Stdout = &stdoutBuf
+	cmd.Stderr = &stderrBuf
+
+	err := cmd.Run()
+	stdout, stderr = stdoutBuf.String(), stderrBuf.String()
+
+	if err != nil {
+		if exitErr, ok := err.(*exec.ExitError); ok {
+			return stdout, stderr, exitErr.ExitCode()
+		}
+		t.Fatalf("run: %v", err)
+	}
+	return stdout, stderr, 0
+}
+
 func testdataDir(t *testing.T, sub string) string {
 	t.Helper()
 	dir, err := filepath.Abs(filepath.Join("testdata", sub))
@@ -2088,3 +2139,1510 @@ message Parameters {
 		t.Errorf("output should NOT contain PublishedApi annotation (should be stripped), got:\n%s", output)
 	}
 }
+
+// --- Annotation Argument Rules ---
+
+func TestAnnotationArgumentRuleCLI(t *testing.T) {
+	bin := buildBinary(t)
+	inputDir := t.TempDir()
+	outDir := t.TempDir()
+	cfgDir := t.TempDir()
+
+	os.WriteFile(filepath.Join(inputDir, "roles.proto"), []byte(`syntax = "proto3";
+package roles;
+
+service OrderService {
+  // @HasAnyRole({"ADMIN"})
+  rpc DeleteOrder(Req) returns (Resp);
+  // @HasAnyRole({"ADMIN", "PARTNER"})
+  rpc GetOrder(Req) returns (Resp);
+}
+
+message Req {}
+message Resp {}
+`), 0o644)
+
+	cfgPath := filepath.Join(cfgDir, "filter.yaml")
+	os.WriteFile(cfgPath, []byte("annotations:\n  exclude:\n    - 'HasAnyRole contains \"PARTNER\"'\n"), 0o644)
+
+	stderr, code := runBinary(t, bin,
+		"--input", inputDir,
+		"--output", outDir,
+		"--config", cfgPath,
+	)
+	if code != 0 {
+		t.Fatalf("expected exit code 0, got %d; stderr: %s", code, stderr)
+	}
+	content, err := os.ReadFile(filepath.Join(outDir, "roles.proto"))
+	if err != nil {
+		t.Fatalf("reading output: %v", err)
+	}
+	if !strings.Contains(string(content), "DeleteOrder") {
+		t.Error("DeleteOrder should be kept")
+	}
+	if strings.Contains(string(content), "GetOrder") {
+		t.Error("GetOrder should be removed by the argument rule")
+	}
+}
+
+func TestInvalidAnnotationRuleCLI(t *testing.T) {
+	bin := buildBinary(t)
+	cfgPath := filepath.Join(t.TempDir(), "filter.yaml")
+	os.WriteFile(cfgPath, []byte("annotations:\n  exclude:\n    - \"Visibility ==\"\n"), 0o644)
+
+	stderr, code := runBinary(t, bin,
+		"--input", testdataDir(t, "simple"),
+		"--output", t.TempDir(),
+		"--config", cfgPath,
+	)
+	if code != 2 {
+		t.Errorf("expected exit code 2, got %d; stderr: %s", code, stderr)
+	}
+	if !strings.Contains(stderr, "invalid annotation filter") {
+		t.Errorf("stderr should describe the invalid rule, got: %s", stderr)
+	}
+}
+
+// --- Annotation Expressions ---
+
+func TestAnnotationExprCLI(t *testing.T) {
+	bin := buildBinary(t)
+	outDir := t.TempDir()
+	cfgPath := filepath.Join(t.TempDir(), "filter.yaml")
+	os.WriteFile(cfgPath, []byte("annotations:\n  expr: \"Public && !Deprecated || Partner\"\n"), 0o644)
+
+	stderr, code := runBinary(t, bin,
+		"--input", testdataDir(t, "expr"),
+		"--output", outDir,
+		"--config", cfgPath,
+		"--verbose",
+	)
+	if code != 0 {
+		t.Fatalf("expected exit code 0, got %d; stderr: %s", code, stderr)
+	}
+	content, err := os.ReadFile(filepath.Join(outDir, "expr_service.proto"))
+	if err != nil {
+		t.Fatalf("reading output: %v", err)
+	}
+	out := string(content)
+	for _, want := range []string{"rpc Get (", "rpc Share (", "message Order", "STATUS_OK"} {
+		if !strings.Contains(out, want) {
+			t.Errorf("output should contain %q:\n%s", want, out)
+		}
+	}
+	for _, gone := range []string{"Legacy", "Hidden", "InternalService", "old_id", "STATUS_OLD"} {
+		if strings.Contains(out, gone) {
+			t.Errorf("output should not contain %q:\n%s", gone, out)
+		}
+	}
+	if !strings.Contains(stderr, "1 enum values by annotation") {
+		t.Errorf("verbose output should count removed enum values, got: %s", stderr)
+	}
+}
+
+func TestAnnotationExprParseErrorCLI(t *testing.T) {
+	bin := buildBinary(t)
+	cfgPath := filepath.Join(t.TempDir(), "filter.yaml")
+	os.WriteFile(cfgPath, []byte("annotations:\n  expr: \"Public && (Partner\"\n"), 0o644)
+
+	stderr, code := runBinary(t, bin,
+		"--input", testdataDir(t, "expr"),
+		"--output", t.TempDir(),
+		"--config", cfgPath,
+	)
+	if code != 2 {
+		t.Errorf("expected exit code 2, got %d; stderr: %s", code, stderr)
+	}
+	if !strings.Contains(stderr, "annotation expression") {
+		t.Errorf("stderr should report the expression error, got: %s", stderr)
+	}
+}
+
+// --- API Versions ---
+
+func TestAPIVersionFlagCLI(t *testing.T) {
+	bin := buildBinary(t)
+	outDir := t.TempDir()
+
+	stderr, code := runBinary(t, bin,
+		"--input", testdataDir(t, "versions"),
+		"--output", outDir,
+		"--api-version", "2.5",
+		"--verbose",
+	)
+	if code != 0 {
+		t.Fatalf("expected exit code 0, got %d; stderr: %s", code, stderr)
+	}
+	content, err := os.ReadFile(filepath.Join(outDir, "orders.proto"))
+	if err != nil {
+		t.Fatalf("reading output: %v", err)
+	}
+	out := string(content)
+	if !strings.Contains(out, "ListOrders") {
+		t.Errorf("ListOrders (since 2.3) should be kept at 2.5:\n%s", out)
+	}
+	if strings.Contains(out, "GetOrderV1") {
+		t.Errorf("GetOrderV1 (removed in 2.0) should be dropped at 2.5:\n%s", out)
+	}
+	if strings.Contains(out, "tags") {
+		t.Errorf("tags (since 3.0.0-beta) should be dropped at 2.5:\n%s", out)
+	}
+	if !strings.Contains(stderr, "outside API version 2.5") {
+		t.Errorf("verbose output should report version filtering, got: %s", stderr)
+	}
+}
+
+func TestAPIVersionConfigCLI(t *testing.T) {
+	bin := buildBinary(t)
+	outDir := t.TempDir()
+	cfgPath := filepath.Join(t.TempDir(), "filter.yaml")
+	os.WriteFile(cfgPath, []byte("api_version: \"1.0\"\n"), 0o644)
+
+	stderr, code := runBinary(t, bin,
+		"--input", testdataDir(t, "versions"),
+		"--output", outDir,
+		"--config", cfgPath,
+	)
+	if code != 0 {
+		t.Fatalf("expected exit code 0, got %d; stderr: %s", code, stderr)
+	}
+	content, _ := os.ReadFile(filepath.Join(outDir, "orders.proto"))
+	out := string(content)
+	if !strings.Contains(out, "GetOrderV1") {
+		t.Errorf("GetOrderV1 should exist at 1.0:\n%s", out)
+	}
+	if strings.Contains(out, "ListOrders") {
+		t.Errorf("ListOrders and its messages should not exist at 1.0:\n%s", out)
+	}
+}
+
+func TestAPIVersionInvalidCLI(t *testing.T) {
+	bin := buildBinary(t)
+	stderr, code := runBinary(t, bin,
+		"--input", testdataDir(t, "versions"),
+		"--output", t.TempDir(),
+		"--api-version", "latest",
+	)
+	if code != 2 {
+		t.Errorf("expected exit code 2, got %d; stderr: %s", code, stderr)
+	}
+	if !strings.Contains(stderr, "invalid api_version") {
+		t.Errorf("stderr should report the invalid version, got: %s", stderr)
+	}
+}
+
+// --- Feature Flags ---
+
+func TestFeatureFlagsCLI(t *testing.T) {
+	bin := buildBinary(t)
+	outDir := t.TempDir()
+	cfgPath := filepath.Join(t.TempDir(), "filter.yaml")
+	os.WriteFile(cfgPath, []byte("features:\n  enabled:\n    - instant-payouts\n"), 0o644)
+
+	stderr, code := runBinary(t, bin,
+		"--input", testdataDir(t, "features"),
+		"--output", outDir,
+		"--config", cfgPath,
+		"--verbose",
+	)
+	if code != 0 {
+		t.Fatalf("expected exit code 0, got %d; stderr: %s", code, stderr)
+	}
+	content, err := os.ReadFile(filepath.Join(outDir, "payouts.proto"))
+	if err != nil {
+		t.Fatalf("reading output: %v", err)
+	}
+	out := string(content)
+	if !strings.Contains(out, "rpc InstantPayout (") {
+		t.Errorf("enabled feature should be kept:\n%s", out)
+	}
+	if strings.Contains(out, "@Feature") {
+		t.Errorf("feature markers should be stripped:\n%s", out)
+	}
+	if !strings.Contains(out, "Pays out immediately") {
+		t.Errorf("remaining comment text should be preserved:\n%s", out)
+	}
+	if strings.Contains(out, "FxQuote") || strings.Contains(out, "quote") {
+		t.Errorf("disabled feature type and the field using it should be removed:\n%s", out)
+	}
+	if !strings.Contains(stderr, "behind disabled features") {
+		t.Errorf("verbose output should report feature filtering, got: %s", stderr)
+	}
+}
+
+func TestFeatureFlagsNoneEnabledCLI(t *testing.T) {
+	bin := buildBinary(t)
+	outDir := t.TempDir()
+	cfgPath := filepath.Join(t.TempDir(), "filter.yaml")
+	os.WriteFile(cfgPath, []byte("features:\n  enabled: []\n"), 0o644)
+
+	stderr, code := runBinary(t, bin,
+		"--input", testdataDir(t, "features"),
+		"--output", outDir,
+		"--config", cfgPath,
+	)
+	if code != 0 {
+		t.Fatalf("expected exit code 0, got %d; stderr: %s", code, stderr)
+	}
+	content, _ := os.ReadFile(filepath.Join(outDir, "payouts.proto"))
+	out := string(content)
+	if strings.Contains(out, "InstantPayout") {
+		t.Errorf("disabled feature RPC and its request should be removed:\n%s", out)
+	}
+	if !strings.Contains(out, "rpc Payout (") {
+		t.Errorf("untagged RPC should be kept:\n%s", out)
+	}
+}
+
+// --- Visibility Tiers ---
+
+func TestTierSelectionCLI(t *testing.T) {
+	bin := buildBinary(t)
+
+	tests := []struct {
+		tier    string
+		present []string
+		absent  []string
+	}{
+		{"", []string{"rpc Ping ("}, []string{"rpc Get (", "rpc Audit (", "risk_score", "CreditLine"}},
+		{"partner", []string{"rpc Ping (", "rpc Get (", "CreditLine"}, []string{"rpc Audit (", "risk_score"}},
+		{"internal", []string{"rpc Ping (", "rpc Get (", "risk_score"}, []string{"rpc Audit ("}},
+		{"restricted", []string{"rpc Ping (", "rpc Get (", "risk_score", "rpc Audit ("}, nil},
+	}
+	for _, tc := range tests {
+		t.Run("tier="+tc.tier, func(t *testing.T) {
+			outDir := t.TempDir()
+			args := []string{
+				"--input", testdataDir(t, "tiers"),
+				"--output", outDir,
+				"--config", filepath.Join(testdataDir(t, "tiers"), "tiers.yaml"),
+			}
+			if tc.tier != "" {
+				args = append(args, "--tier", tc.tier)
+			}
+			stderr, code := runBinary(t, bin, args...)
+			if code != 0 {
+				t.Fatalf("expected exit code 0, got %d; stderr: %s", code, stderr)
+			}
+			content, err := os.ReadFile(filepath.Join(outDir, "accounts.proto"))
+			if err != nil {
+				t.Fatalf("reading output: %v", err)
+			}
+			out := string(content)
+			for _, want := range tc.present {
+				if !strings.Contains(out, want) {
+					t.Errorf("output should contain %s:\n%s", want, out)
+				}
+			}
+			for _, gone := range tc.absent {
+				if strings.Contains(out, gone) {
+					t.Errorf("output should not contain %s:\n%s", gone, out)
+				}
+			}
+		})
+	}
+}
+
+func TestTierUnknownCLI(t *testing.T) {
+	bin := buildBinary(t)
+	stderr, code := runBinary(t, bin,
+		"--input", testdataDir(t, "tiers"),
+		"--output", t.TempDir(),
+		"--config", filepath.Join(testdataDir(t, "tiers"), "tiers.yaml"),
+		"--tier", "vip",
+	)
+	if code != 2 {
+		t.Errorf("expected exit code 2, got %d; stderr: %s", code, stderr)
+	}
+	if !strings.Contains(stderr, `unknown tier "vip"`) {
+		t.Errorf("stderr should name the unknown tier, got: %s", stderr)
+	}
+}
+
+// --- Annotation Syntax ---
+
+func TestAnnotationSyntaxCLI(t *testing.T) {
+	bin := buildBinary(t)
+	outDir := t.TempDir()
+
+	stderr, code := runBinary(t, bin,
+		"--input", testdataDir(t, "syntax"),
+		"--output", outDir,
+		"--config", filepath.Join(testdataDir(t, "syntax"), "syntax.yaml"),
+	)
+	if code != 0 {
+		t.Fatalf("expected exit code 0, got %d; stderr: %s", code, stderr)
+	}
+	content, err := os.ReadFile(filepath.Join(outDir, "catalog.proto"))
+	if err != nil {
+		t.Fatalf("reading output: %v", err)
+	}
+	out := string(content)
+	for _, gone := range []string{"ReindexCatalog", "RebuildIndex", "ReindexRequest", ":stable:", "#owner"} {
+		if strings.Contains(out, gone) {
+			t.Errorf("output should not contain %s:\n%s", gone, out)
+		}
+	}
+	for _, want := range []string{"Stable API.", "Owned by catalog-team.", "support@example.com"} {
+		if !strings.Contains(out, want) {
+			t.Errorf("output should contain %q:\n%s", want, out)
+		}
+	}
+}
+
+func TestAnnotationSyntaxStrictLocationCLI(t *testing.T) {
+	bin := buildBinary(t)
+	tmp := t.TempDir()
+	cfgPath := filepath.Join(tmp, "syntax.yaml")
+	os.WriteFile(cfgPath, []byte(`annotation_syntax:
+  - prefix: "#"
+substitutions:
+  internal: ""
+strict_substitutions: true
+`), 0o644)
+
+	stderr, code := runBinary(t, bin,
+		"--input", testdataDir(t, "syntax"),
+		"--output", t.TempDir(),
+		"--config", cfgPath,
+	)
+	if code != 2 {
+		t.Fatalf("expected exit code 2, got %d; stderr: %s", code, stderr)
+	}
+	if !strings.Contains(stderr, "unsubstituted annotations found: owner") {
+		t.Errorf("expected only owner to be reported, got: %s", stderr)
+	}
+	if !strings.Contains(stderr, "catalog.proto:21: #owner(catalog-team)") {
+		t.Errorf("expected location of #owner, got: %s", stderr)
+	}
+}
+
+// --- Annotation Wildcards ---
+
+func TestAnnotationWildcardsCLI(t *testing.T) {
+	bin := buildBinary(t)
+	outDir := t.TempDir()
+
+	stderr, code := runBinary(t, bin,
+		"--input", testdataDir(t, "wildcards"),
+		"--output", outDir,
+		"--config", filepath.Join(testdataDir(t, "wildcards"), "wildcards.yaml"),
+	)
+	if code != 0 {
+		t.Fatalf("expected exit code 0 with wildcard coverage in strict mode, got %d; stderr: %s", code, stderr)
+	}
+	content, err := os.ReadFile(filepath.Join(outDir, "billing.proto"))
+	if err != nil {
+		t.Fatalf("reading output: %v", err)
+	}
+	out := string(content)
+	for _, gone := range []string{"RecalculateTaxes", "@auth.Public", "@ops.Deprecated"} {
+		if strings.Contains(out, gone) {
+			t.Errorf("output should not contain %s:\n%s", gone, out)
+		}
+	}
+	for _, want := range []string{`Requires one of the roles: {"ADMIN", "BILLING"}.`, "Deprecated."} {
+		if !strings.Contains(out, want) {
+			t.Errorf("output should contain %q:\n%s", want, out)
+		}
+	}
+}
+
+func TestAnnotationWildcardsStrictCLI(t *testing.T) {
+	bin := buildBinary(t)
+	tmp := t.TempDir()
+	cfgPath := filepath.Join(tmp, "wildcards.yaml")
+	os.WriteFile(cfgPath, []byte(`substitutions:
+  "auth.*": ""
+  "Internal*": ""
+strict_substitutions: true
+`), 0o644)
+
+	stderr, code := runBinary(t, bin,
+		"--input", testdataDir(t, "wildcards"),
+		"--output", t.TempDir(),
+		"--config", cfgPath,
+	)
+	if code != 2 {
+		t.Fatalf("expected exit code 2, got %d; stderr: %s", code, stderr)
+	}
+	if !strings.Contains(stderr, "unsubstituted annotations found: ops.Deprecated\n") {
+		t.Errorf("only ops.Deprecated should be reported, got: %s", stderr)
+	}
+}
+
+// --- Annotation Aliases ---
+
+func TestAnnotationAliasesCLI(t *testing.T) {
+	bin := buildBinary(t)
+	outDir := t.TempDir()
+
+	stderr, code := runBinary(t, bin,
+		"--input", testdataDir(t, "aliases"),
+		"--output", outDir,
+		"--config", filepath.Join(testdataDir(t, "aliases"), "aliases.yaml"),
+		"--verbose",
+	)
+	if code != 0 {
+		t.Fatalf("expected exit code 0, got %d; stderr: %s", code, stderr)
+	}
+	content, err := os.ReadFile(filepath.Join(outDir, "users.proto"))
+	if err != nil {
+		t.Fatalf("reading output: %v", err)
+	}
+	out := string(content)
+	for _, gone := range []string{"DeleteUser", "ResetPassword", "MergeAccounts", "ImpersonateUser", "@pii"} {
+		if strings.Contains(out, gone) {
+			t.Errorf("output should not contain %s:\n%s", gone, out)
+		}
+	}
+	for _, kept := range []string{"rpc GetUser", "rpc ListUsers"} {
+		if !strings.Contains(out, kept) {
+			t.Errorf("output should contain %s:\n%s", kept, out)
+		}
+	}
+	if !strings.Contains(out, "Contains personal data.") {
+		t.Errorf("@pii should be substituted case-insensitively:\n%s", out)
+	}
+	if !strings.Contains(stderr, "resolved annotation aliases: Private -> internal") {
+		t.Errorf("verbose output should report used aliases, got: %s", stderr)
+	}
+}
+
+// --- Options as Annotations ---
+
+func TestOptionAnnotationsCLI(t *testing.T) {
+	bin := buildBinary(t)
+	outDir := t.TempDir()
+
+	stderr, code := runBinary(t, bin,
+		"--input", testdataDir(t, "options"),
+		"--output", outDir,
+		"--config", filepath.Join(testdataDir(t, "options"), "options.yaml"),
+	)
+	if code != 0 {
+		t.Fatalf("expected exit code 0, got %d; stderr: %s", code, stderr)
+	}
+	content, err := os.ReadFile(filepath.Join(outDir, "orders.proto"))
+	if err != nil {
+		t.Fatalf("reading output: %v", err)
+	}
+	out := string(content)
+	for _, gone := range []string{"GetOrderLegacy", "Debug", "AdminService", "PurgeRequest", "legacy_code"} {
+		if strings.Contains(out, gone) {
+			t.Errorf("output should not contain %s:\n%s", gone, out)
+		}
+	}
+	for _, want := range []string{"rpc GetOrder", "status"} {
+		if !strings.Contains(out, want) {
+			t.Errorf("output should contain %s:\n%s", want, out)
+		}
+	}
+}
+
+// --- Annotations to Options ---
+
+func TestAnnotationOptionsCLI(t *testing.T) {
+	bin := buildBinary(t)
+	outDir := t.TempDir()
+
+	stderr, code := runBinary(t, bin,
+		"--input", testdataDir(t, "annotation-options"),
+		"--output", outDir,
+		"--config", filepath.Join(testdataDir(t, "annotation-options"), "options.yaml"),
+		"--verbose",
+	)
+	if code != 0 {
+		t.Fatalf("expected exit code 0, got %d; stderr: %s", code, stderr)
+	}
+	content, err := os.ReadFile(filepath.Join(outDir, "payments.proto"))
+	if err != nil {
+		t.Fatalf("reading output: %v", err)
+	}
+	out := string(content)
+	for _, want := range []string{
+		`option (myapp.auth).roles = "ADMIN";`,
+		`option (myapp.auth).roles = "CASHIER";`,
+		"option deprecated = true;",
+		"[deprecated = true]",
+		"// Refunds a payment.",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("output should contain %q:\n%s", want, out)
+		}
+	}
+	if strings.Contains(out, "@") {
+		t.Errorf("converted annotations should be removed:\n%s", out)
+	}
+	if !strings.Contains(stderr, "converted annotations into 4 options") {
+		t.Errorf("verbose output should count options, got: %s", stderr)
+	}
+}
+
+// --- Template Substitutions ---
+
+func TestTemplateSubstitutionCLI(t *testing.T) {
+	bin := buildBinary(t)
+	outDir := t.TempDir()
+
+	stderr, code := runBinary(t, bin,
+		"--input", testdataDir(t, "templates"),
+		"--output", outDir,
+		"--config", filepath.Join(testdataDir(t, "templates"), "templates.yaml"),
+	)
+	if code != 0 {
+		t.Fatalf("expected exit code 0, got %d; stderr: %s", code, stderr)
+	}
+	content, err := os.ReadFile(filepath.Join(outDir, "orders.proto"))
+	if err != nil {
+		t.Fatalf("reading output: %v", err)
+	}
+	out := string(content)
+	for _, want := range []string{
+		"// Requires one of: ADMIN, MANAGER",
+		"// Requires authentication",
+		"// Available since 2.1 (templates.Order.channel).",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("output should contain %q:\n%s", want, out)
+		}
+	}
+}
+
+func TestTemplateSubstitutionParseErrorCLI(t *testing.T) {
+	bin := buildBinary(t)
+	cfgPath := filepath.Join(t.TempDir(), "broken.yaml")
+	os.WriteFile(cfgPath, []byte("substitutions:\n  HasAnyRole: '{{.Args | join'\n"), 0o644)
+
+	stderr, code := runBinary(t, bin,
+		"--input", testdataDir(t, "templates"),
+		"--output", t.TempDir(),
+		"--config", cfgPath,
+	)
+	if code != 2 {
+		t.Errorf("expected exit code 2, got %d; stderr: %s", code, stderr)
+	}
+	if !strings.Contains(stderr, `substitutions: "HasAnyRole"`) {
+		t.Errorf("stderr should name the broken substitution, got: %s", stderr)
+	}
+}
+
+func TestTemplateSubstitutionRenderErrorCLI(t *testing.T) {
+	bin := buildBinary(t)
+	outDir := t.TempDir()
+	cfgPath := filepath.Join(t.TempDir(), "render.yaml")
+	os.WriteFile(cfgPath, []byte("substitutions:\n  HasAnyRole: 'Requires {{index .Args 0}}.'\n"), 0o644)
+
+	stderr, code := runBinary(t, bin,
+		"--input", testdataDir(t, "templates"),
+		"--output", outDir,
+		"--config", cfgPath,
+	)
+	if code != 2 {
+		t.Errorf("expected exit code 2, got %d; stderr: %s", code, stderr)
+	}
+	if !strings.Contains(stderr, "orders.proto:11:6") || !strings.Contains(stderr, "substituting @HasAnyRole on templates.OrderService.ListOrders") {
+		t.Errorf("stderr should locate the failing annotation, got: %s", stderr)
+	}
+	if _, err := os.Stat(filepath.Join(outDir, "orders.proto")); err == nil {
+		t.Error("no output should be written when a template fails")
+	}
+}
+
+func TestKindSubstitutionCLI(t *testing.T) {
+	bin := buildBinary(t)
+	outDir := t.TempDir()
+	cfgPath := filepath.Join(testdataDir(t, "kind-substitutions"), "kinds.yaml")
+
+	stderr, code := runBinary(t, bin,
+		"--input", testdataDir(t, "kind-substitutions"),
+		"--output", outDir,
+		"--config", cfgPath,
+	)
+	if code != 0 {
+		t.Fatalf("expected exit code 0, got %d; stderr: %s", code, stderr)
+	}
+	content, err := os.ReadFile(filepath.Join(outDir, "admin.proto"))
+	if err != nil {
+		t.Fatalf("reading output: %v", err)
+	}
+	out := string(content)
+	for _, want := range []string{
+		"// Internal service - not available externally",
+		"// For internal use only",
+		"// Reserved for internal use",
+		"// @Beta",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("output should contain %q:\n%s", want, out)
+		}
+	}
+
+	// @Beta on a field has no field or default text.
+	base, err := os.ReadFile(cfgPath)
+	if err != nil {
+		t.Fatalf("reading config: %v", err)
+	}
+	strictPath := filepath.Join(t.TempDir(), "strict.yaml")
+	os.WriteFile(strictPath, append(base, "strict_substitutions: true\n"...), 0o644)
+	stderr, code = runBinary(t, bin,
+		"--input", testdataDir(t, "kind-substitutions"),
+		"--output", t.TempDir(),
+		"--config", strictPath,
+	)
+	if code != 2 {
+		t.Errorf("expected exit code 2 in strict mode, got %d; stderr: %s", code, stderr)
+	}
+	if !strings.Contains(stderr, "Beta") {
+		t.Errorf("stderr should name the unmapped annotation, got: %s", stderr)
+	}
+}
+
+func TestMultiLineSubstitutionCLI(t *testing.T) {
+	bin := buildBinary(t)
+	outDir := t.TempDir()
+
+	stderr, code := runBinary(t, bin,
+		"--input", testdataDir(t, "multiline"),
+		"--output", outDir,
+		"--config", filepath.Join(testdataDir(t, "multiline"), "multiline.yaml"),
+	)
+	if code != 0 {
+		t.Fatalf("expected exit code 0, got %d; stderr: %s", code, stderr)
+	}
+	content, err := os.ReadFile(filepath.Join(outDir, "payments.proto"))
+	if err != nil {
+		t.Fatalf("reading output: %v", err)
+	}
+	out := string(content)
+	for _, want := range []string{
+		"  // Refunds a payment.\n  // Audited operation.\n  //\n  // Recorded fields:\n  // - caller identity\n  // - payment id and refund amount\n  rpc Refund",
+		"  // Kept for 90 days after the payment is settled, then deleted\n  // together with the remaining payment history.\n",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("output should contain %q:\n%s", want, out)
+		}
+	}
+	for _, line := range strings.Split(out, "\n") {
+		if strings.TrimRight(line, " \t") != line {
+			t.Errorf("output line %q should not end in whitespace", line)
+		}
+	}
+}
+
+func TestAnnotationInventoryCLI(t *testing.T) {
+	bin := buildBinary(t)
+
+	stdout, stderr, code := runBinaryOutput(t, bin, "annotations", "--input", testdataDir(t, "templates"))
+	if code != 0 {
+		t.Fatalf("expected exit code 0, got %d; stderr: %s", code, stderr)
+	}
+	for _, want := range []string{
+		"HasAnyRole  2 occurrences on rpc\n  example: ({\"ADMIN\", \"MANAGER\"})\n  orders.proto:7\n  orders.proto:11\n",
+		"Since  1 occurrence on field\n  example: (2.1)\n  orders.proto:27\n",
+		"2 annotation names, 3 occurrences in 1 file\n",
+	} {
+		if !strings.Contains(stdout, want) {
+			t.Errorf("stdout should contain %q:\n%s", want, stdout)
+		}
+	}
+
+	stdout, stderr, code = runBinaryOutput(t, bin, "annotations", "--input", testdataDir(t, "templates"), "--format", "json")
+	if code != 0 {
+		t.Fatalf("expected exit code 0, got %d; stderr: %s", code, stderr)
+	}
+	var inventory struct {
+		Files       int
+		Occurrences int
+		Annotations []filter.AnnotationSummary
+	}
+	if err := json.Unmarshal([]byte(stdout), &inventory); err != nil {
+		t.Fatalf("invalid JSON: %v\n%s", err, stdout)
+	}
+	if inventory.Files != 1 || inventory.Occurrences != 3 || len(inventory.Annotations) != 2 {
+		t.Fatalf("unexpected inventory: %+v", inventory)
+	}
+	if a := inventory.Annotations[1]; a.Name != "Since" || a.Locations[0].Kind != "field" || a.Locations[0].Line != 27 {
+		t.Errorf("unexpected Since summary: %+v", a)
+	}
+}
+
+func TestAnnotationInventorySkeletonCLI(t *testing.T) {
+	bin := buildBinary(t)
+	cfgPath := filepath.Join(t.TempDir(), "partial.yaml")
+	os.WriteFile(cfgPath, []byte("substitutions:\n  Since: \"Since %s\"\n"), 0o644)
+
+	stdout, stderr, code := runBinaryOutput(t, bin, "annotations",
+		"--input", testdataDir(t, "templates"),
+		"--config", cfgPath,
+		"--skeleton",
+	)
+	if code != 0 {
+		t.Fatalf("expected exit code 0, got %d; stderr: %s", code, stderr)
+	}
+	want := "substitutions:\n  HasAnyRole: \"\" # 2 occurrences on rpc, e.g. ({\"ADMIN\", \"MANAGER\"})\n"
+	if stdout != want {
+		t.Errorf("skeleton:\n got %q\nwant %q", stdout, want)
+	}
+
+	// The skeleton is valid config once the texts are filled in.
+	skeletonPath := filepath.Join(t.TempDir(), "skeleton.yaml")
+	os.WriteFile(skeletonPath, []byte(stdout), 0o644)
+	if _, err := config.LoadConfig(skeletonPath); err != nil {
+		t.Errorf("skeleton should load as config: %v", err)
+	}
+}
+
+func TestAnnotationInventoryFormatErrorCLI(t *testing.T) {
+	bin := buildBinary(t)
+	_, stderr, code := runBinaryOutput(t, bin, "annotations", "--input", testdataDir(t, "templates"), "--format", "xml")
+	if code != 1 {
+		t.Errorf("expected exit code 1, got %d", code)
+	}
+	if !strings.Contains(stderr, "unknown format") {
+		t.Errorf("stderr should report the format, got: %s", stderr)
+	}
+}
+
+func TestUnusedConfigNamesCLI(t *testing.T) {
+	bin := buildBinary(t)
+	cfg := `annotations:
+  exclude:
+    - Internal
+substitutions:
+  Since: "Since %s"
+  Deprecated: ""
+  "legacy.*": ""
+`
+	cfgPath := filepath.Join(t.TempDir(), "stale.yaml")
+	os.WriteFile(cfgPath, []byte(cfg), 0o644)
+
+	outDir := t.TempDir()
+	stderr, code := runBinary(t, bin,
+		"--input", testdataDir(t, "templates"),
+		"--output", outDir,
+		"--config", cfgPath,
+	)
+	if code != 0 {
+		t.Fatalf("expected exit code 0, got %d; stderr: %s", code, stderr)
+	}
+	for _, want := range []string{
+		"proto-filter: warning: unused substitution keys: Deprecated, legacy.*\n",
+		"proto-filter: warning: unused annotation filter names: Internal\n",
+	} {
+		if !strings.Contains(stderr, want) {
+			t.Errorf("stderr should contain %q, got: %s", want, stderr)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(outDir, "orders.proto")); err != nil {
+		t.Errorf("output should be written despite warnings: %v", err)
+	}
+
+	os.WriteFile(cfgPath, []byte(cfg+"strict_unused: true\n"), 0o644)
+	outDir = t.TempDir()
+	stderr, code = runBinary(t, bin,
+		"--input", testdataDir(t, "templates"),
+		"--output", outDir,
+		"--config", cfgPath,
+	)
+	if code != 2 {
+		t.Errorf("expected exit code 2 in strict mode, got %d; stderr: %s", code, stderr)
+	}
+	if !strings.Contains(stderr, "proto-filter: error: unused substitution keys: Deprecated, legacy.*") {
+		t.Errorf("stderr should report unused keys as an error, got: %s", stderr)
+	}
+	entries, _ := os.ReadDir(outDir)
+	if len(entries) != 0 {
+		t.Error("no output files should be written on strict failure")
+	}
+}
+
+func TestUnmatchedPatternsCLI(t *testing.T) {
+	bin := buildBinary(t)
+	cfg := `include:
+  - "templates.*"
+  - "billing.*"
+exclude:
+  - "templates.Legacy*"
+`
+	cfgPath := filepath.Join(t.TempDir(), "patterns.yaml")
+	os.WriteFile(cfgPath, []byte(cfg), 0o644)
+
+	stderr, code := runBinary(t, bin,
+		"--input", testdataDir(t, "templates"),
+		"--output", t.TempDir(),
+		"--config", cfgPath,
+	)
+	if code != 0 {
+		t.Fatalf("expected exit code 0, got %d; stderr: %s", code, stderr)
+	}
+	if want := "proto-filter: warning: unmatched patterns: billing.*, templates.Legacy*\n"; !strings.Contains(stderr, want) {
+		t.Errorf("stderr should contain %q, got: %s", want, stderr)
+	}
+
+	os.WriteFile(cfgPath, []byte(cfg+"strict_unused: true\n"), 0o644)
+	stdout, stderr, code := runBinaryOutput(t, bin,
+		"--input", testdataDir(t, "templates"),
+		"--output", t.TempDir(),
+		"--config", cfgPath,
+		"--diagnostics-format", "json",
+	)
+	if code != 2 {
+		t.Fatalf("expected exit code 2 in strict mode, got %d; stderr: %s", code, stderr)
+	}
+	var report struct {
+		Diagnostics []diag.Diagnostic
+	}
+	if err := json.Unmarshal([]byte(stdout), &report); err != nil {
+		t.Fatalf("invalid JSON: %v\n%s", err, stdout)
+	}
+	want := []diag.Diagnostic{
+		{Rule: diag.RuleUnmatchedPattern, Severity: diag.SeverityError, Message: "unmatched pattern: billing.*", File: cfgPath},
+		{Rule: diag.RuleUnmatchedPattern, Severity: diag.SeverityError, Message: "unmatched pattern: templates.Legacy*", File: cfgPath},
+	}
+	if !reflect.DeepEqual(report.Diagnostics, want) {
+		t.Errorf("diagnostics:\n got %+v\nwant %+v", report.Diagnostics, want)
+	}
+}
+
+func TestDiagnosticsJSONCLI(t *testing.T) {
+	bin := buildBinary(t)
+	cfgPath := filepath.Join(t.TempDir(), "strict.yaml")
+	os.WriteFile(cfgPath, []byte("substitutions:\n  Since: \"Since %s\"\n  Legacy: \"\"\nstrict_substitutions: true\n"), 0o644)
+
+	stdout, stderr, code := runBinaryOutput(t, bin,
+		"--input", testdataDir(t, "templates"),
+		"--output", t.TempDir(),
+		"--config", cfgPath,
+		"--diagnostics-format", "json",
+	)
+	if code != 2 {
+		t.Fatalf("expected exit code 2, got %d; stderr: %s", code, stderr)
+	}
+	if stderr != "" {
+		t.Errorf("diagnostics should not be printed as text, got: %s", stderr)
+	}
+	var report struct {
+		Diagnostics []diag.Diagnostic
+	}
+	if err := json.Unmarshal([]byte(stdout), &report); err != nil {
+		t.Fatalf("invalid JSON: %v\n%s", err, stdout)
+	}
+	want := []diag.Diagnostic{
+		{Rule: diag.RuleUnusedSubstitution, Severity: diag.SeverityWarning, Message: "unused substitution key: Legacy", File: cfgPath},
+		{Rule: diag.RuleUnsubstitutedAnnotation, Severity: diag.SeverityError, Message: `unsubstituted annotation @HasAnyRole({"ADMIN", "MANAGER"})`,
+			File: filepath.Join(testdataDir(t, "templates"), "orders.proto"), Line: 7, Column: 6},
+		{Rule: diag.RuleUnsubstitutedAnnotation, Severity: diag.SeverityError, Message: "unsubstituted annotation @HasAnyRole",
+			File: filepath.Join(testdataDir(t, "templates"), "orders.proto"), Line: 11, Column: 6},
+	}
+	if !reflect.DeepEqual(report.Diagnostics, want) {
+		t.Errorf("diagnostics:\n got %+v\nwant %+v", report.Diagnostics, want)
+	}
+}
+
+func TestDiagnosticsSARIFCLI(t *testing.T) {
+	bin := buildBinary(t)
+	inDir := t.TempDir()
+	os.WriteFile(filepath.Join(inDir, "broken.proto"), []byte("syntax = \"proto3\";\nmessage A {\n  string id = ;\n}\n"), 0o644)
+
+	stdout, _, code := runBinaryOutput(t, bin,
+		"--input", inDir,
+		"--output", t.TempDir(),
+		"--diagnostics-format", "sarif",
+	)
+	if code != 1 {
+		t.Fatalf("expected exit code 1, got %d", code)
+	}
+	var log struct {
+		Version string
+		Runs    []struct {
+			Results []struct {
+				RuleID    string
+				Level     string
+				Locations []struct {
+					PhysicalLocation struct {
+						ArtifactLocation struct{ URI string }
+						Region           struct{ StartLine, StartColumn int }
+					}
+				}
+			}
+		}
+	}
+	if err := json.Unmarshal([]byte(stdout), &log); err != nil {
+		t.Fatalf("invalid SARIF: %v\n%s", err, stdout)
+	}
+	if log.Version != "2.1.0" || len(log.Runs) != 1 || len(log.Runs[0].Results) != 1 {
+		t.Fatalf("unexpected SARIF log:\n%s", stdout)
+	}
+	result := log.Runs[0].Results[0]
+	loc := result.Locations[0].PhysicalLocation
+	if result.RuleID != "parse" || result.Level != "error" || loc.Region.StartLine != 3 || loc.Region.StartColumn != 15 {
+		t.Errorf("unexpected result: %+v", result)
+	}
+	if !strings.HasSuffix(loc.ArtifactLocation.URI, "/broken.proto") {
+		t.Errorf("uri = %q", loc.ArtifactLocation.URI)
+	}
+}
+
+func TestDiagnosticsFormatErrorCLI(t *testing.T) {
+	bin := buildBinary(t)
+	stderr, code := runBinary(t, bin,
+		"--input", testdataDir(t, "templates"),
+		"--output", t.TempDir(),
+		"--diagnostics-format", "xml",
+	)
+	if code != 1 {
+		t.Errorf("expected exit code 1, got %d", code)
+	}
+	if !strings.Contains(stderr, `unknown diagnostics format "xml"`) {
+		t.Errorf("stderr should report the format, got: %s", stderr)
+	}
+}
+
+func TestParseErrorsCLI(t *testing.T) {
+	bin := buildBinary(t)
+	outDir := filepath.Join(t.TempDir(), "out")
+	stderr, code := runBinary(t, bin,
+		"--input", testdataDir(t, "parse-errors"),
+		"--output", outDir,
+	)
+	if code != 1 {
+		t.Errorf("expected exit code 1, got %d", code)
+	}
+	for _, want := range []string{
+		"proto-filter: error: parsing orders.proto:6:15: found \";\" but expected [field sequence number]\n" +
+			"    string id = ;\n" +
+			"                ^\n",
+		"proto-filter: error: parsing payments.proto:6:24: found \"Item\" but expected [rpc type opening (]\n" +
+			"  \trpc Pay(Item) returns Item;\n" +
+			"  \t                      ^\n",
+	} {
+		if !strings.Contains(stderr, want) {
+			t.Errorf("stderr should contain %q, got:\n%s", want, stderr)
+		}
+	}
+	if strings.Contains(stderr, "items.proto") {
+		t.Errorf("stderr should not mention the valid file, got:\n%s", stderr)
+	}
+	if _, err := os.Stat(outDir); !os.IsNotExist(err) {
+		t.Errorf("no output should be written when parsing fails")
+	}
+}
+
+func TestPreserveFormattingCLI(t *testing.T) {
+	bin := buildBinary(t)
+	inDir := testdataDir(t, "preserve")
+	outDir := t.TempDir()
+
+	stderr, code := runBinary(t, bin,
+		"--input", inDir,
+		"--output", outDir,
+		"--config", filepath.Join(inDir, "preserve.yaml"),
+		"--preserve-formatting",
+	)
+	if code != 0 {
+		t.Fatalf("expected exit code 0, got %d; stderr: %s", code, stderr)
+	}
+	content, err := os.ReadFile(filepath.Join(outDir, "inventory.proto"))
+	if err != nil {
+		t.Fatalf("reading output: %v", err)
+	}
+	want := `syntax = "proto3";
+
+package inventory.v1;
+
+option go_package = "example.com/inventory/v1;inventoryv1";
+
+/*
+ * Manages stock levels.
+ */
+service InventoryService {
+  rpc GetStock   (GetStockRequest)   returns (Stock);
+}
+
+message GetStockRequest {
+  string sku       = 1;
+  string warehouse = 2; // optional filter
+}
+
+message Stock {
+  string sku      = 1;
+  int32  quantity = 2;
+  // Measured in "pieces".
+  int32  reserved_quantity = 4;
+}
+`
+	if string(content) != want {
+		t.Errorf("output:\n%s\nwant:\n%s", content, want)
+	}
+}
+
+func TestPreserveFormattingPassThroughCLI(t *testing.T) {
+	bin := buildBinary(t)
+	inDir := testdataDir(t, "preserve")
+	outDir := t.TempDir()
+
+	stderr, code := runBinary(t, bin, "--input", inDir, "--output", outDir, "--preserve-formatting")
+	if code != 0 {
+		t.Fatalf("expected exit code 0, got %d; stderr: %s", code, stderr)
+	}
+	source, _ := os.ReadFile(filepath.Join(inDir, "inventory.proto"))
+	content, err := os.ReadFile(filepath.Join(outDir, "inventory.proto"))
+	if err != nil {
+		t.Fatalf("reading output: %v", err)
+	}
+	if string(content) != string(source) {
+		t.Errorf("pass-through output should equal the source, got:\n%s", content)
+	}
+}
+
+func TestFormatConfigCLI(t *testing.T) {
+	bin := buildBinary(t)
+	inDir := testdataDir(t, "format")
+	outDir := t.TempDir()
+
+	stderr, code := runBinary(t, bin,
+		"--input", inDir,
+		"--output", outDir,
+		"--config", filepath.Join(inDir, "format.yaml"),
+	)
+	if code != 0 {
+		t.Fatalf("expected exit code 0, got %d; stderr: %s", code, stderr)
+	}
+	content, err := os.ReadFile(filepath.Join(outDir, "shipping.proto"))
+	if err != nil {
+		t.Fatalf("reading output: %v", err)
+	}
+	want := `syntax = "proto3";
+
+package shipping;
+
+import "common/address.proto";
+import "google/protobuf/timestamp.proto";
+
+
+/*
+ * Ships parcels.
+ */
+service ShippingService {
+
+    // Creates a shipment for an order. The shipment is created in
+    // the pending state and is dispatched by the warehouse.
+    rpc CreateShipment (CreateShipmentRequest) returns (Shipment);
+    rpc Track (TrackRequest) returns (Shipment);
+}
+
+
+message CreateShipmentRequest {
+    string order_id = 1;
+    common.Address destination = 2;
+}
+
+
+message TrackRequest {
+    string shipment_id = 1;
+}
+
+
+message Shipment {
+    string id = 1;
+    google.protobuf.Timestamp created_at = 2;
+    repeated string parcel_ids = 3;
+}
+`
+	if string(content) != want {
+		t.Errorf("output:\n%s\nwant:\n%s", content, want)
+	}
+}
+
+func TestRunReportCLI(t *testing.T) {
+	bin := buildBinary(t)
+	inDir := t.TempDir()
+	for _, name := range []string{"common.proto", "orders.proto", "payments.proto"} {
+		data, err := os.ReadFile(filepath.Join(testdataDir(t, "crossfile"), name))
+		if err != nil {
+			t.Fatalf("reading testdata: %v", err)
+		}
+		os.WriteFile(filepath.Join(inDir, name), data, 0o644)
+	}
+	cfgPath := filepath.Join(t.TempDir(), "report.yaml")
+	os.WriteFile(cfgPath, []byte(`include:
+  - "crossfile.OrderService"
+annotations:
+  exclude:
+    - HasAnyRole
+substitutions:
+  Internal: ""
+`), 0o644)
+	reportPath := filepath.Join(t.TempDir(), "reports", "run.json")
+	outDir := t.TempDir()
+
+	stderr, code := runBinary(t, bin,
+		"--input", inDir,
+		"--output", outDir,
+		"--config", cfgPath,
+		"--report", reportPath,
+	)
+	if code != 0 {
+		t.Fatalf("expected exit code 0, got %d; stderr: %s", code, stderr)
+	}
+	data, err := os.ReadFile(reportPath)
+	if err != nil {
+		t.Fatalf("reading report: %v", err)
+	}
+	var r report.Report
+	if err := json.Unmarshal(data, &r); err != nil {
+		t.Fatalf("invalid report: %v\n%s", err, data)
+	}
+
+	if !reflect.DeepEqual(r.InputFiles, []string{"common.proto", "orders.proto", "payments.proto"}) {
+		t.Errorf("input files = %v", r.InputFiles)
+	}
+	if !reflect.DeepEqual(r.OutputFiles, []string{"common.proto", "orders.proto"}) {
+		t.Errorf("output files = %v", r.OutputFiles)
+	}
+	files := make(map[string]*report.File)
+	for _, f := range r.Files {
+		files[f.Path] = f
+	}
+	if got := files["payments.proto"]; got.Written || got.Removed[report.ReasonIncludeExclude] != (filter.PruneStats{Services: 1, Messages: 2}) {
+		t.Errorf("payments.proto: %+v", got)
+	}
+	if got := files["common.proto"].Removed[report.ReasonIncludeExclude]; got != (filter.PruneStats{Messages: 1}) {
+		t.Errorf("common.proto removals = %+v", got)
+	}
+	if got := files["orders.proto"].Removed[report.ReasonAnnotations]; got != (filter.PruneStats{Methods: 1, Orphans: 2}) {
+		t.Errorf("orders.proto removals = %+v", got)
+	}
+	if got := r.Removed[report.ReasonIncludeExclude]; got != (filter.PruneStats{Services: 1, Messages: 3}) {
+		t.Errorf("total include/exclude removals = %+v", got)
+	}
+	var phases []string
+	for _, timing := range r.Timings {
+		phases = append(phases, timing.Phase)
+	}
+	if !reflect.DeepEqual(phases, []string{"parse", "resolve", "filter", "write"}) {
+		t.Errorf("phases = %v", phases)
+	}
+	if r.Config["include"] == nil || r.ConfigFile != cfgPath {
+		t.Errorf("report should contain the effective config, got %v from %q", r.Config, r.ConfigFile)
+	}
+
+	// --check writes nothing, so the report lists no written files
+	stderr, code = runBinary(t, bin,
+		"--input", inDir,
+		"--output", outDir,
+		"--config", cfgPath,
+		"--report", reportPath,
+		"--check",
+	)
+	if code != 0 {
+		t.Fatalf("expected exit code 0 with --check, got %d; stderr: %s", code, stderr)
+	}
+	data, err = os.ReadFile(reportPath)
+	if err != nil {
+		t.Fatalf("reading report: %v", err)
+	}
+	var checked report.Report
+	if err := json.Unmarshal(data, &checked); err != nil {
+		t.Fatalf("invalid report: %v\n%s", err, data)
+	}
+	if len(checked.OutputFiles) != 0 {
+		t.Errorf("--check should report no output files, got %v", checked.OutputFiles)
+	}
+	for _, f := range checked.Files {
+		if f.Written {
+			t.Errorf("--check should not mark %s as written", f.Path)
+		}
+	}
+}
+
+func TestHeaderCLI(t *testing.T) {
+	bin := buildBinary(t)
+	inDir := testdataDir(t, "header")
+	cfgPath := filepath.Join(inDir, "header.yaml")
+
+	for _, mode := range []string{"formatted", "preserve"} {
+		t.Run(mode, func(t *testing.T) {
+			outDir := t.TempDir()
+			args := []string{"--input", inDir, "--output", outDir, "--config", cfgPath}
+			if mode == "preserve" {
+				args = append(args, "--preserve-formatting")
+			}
+			stderr, code := runBinary(t, bin, args...)
+			if code != 0 {
+				t.Fatalf("expected exit code 0, got %d; stderr: %s", code, stderr)
+			}
+
+			for _, name := range []string{"billing.proto", "refunds.proto"} {
+				content, err := os.ReadFile(filepath.Join(outDir, name))
+				if err != nil {
+					t.Fatalf("reading output: %v", err)
+				}
+				header, body, ok := strings.Cut(string(content), "\n\n")
+				if !ok {
+					t.Fatalf("%s: no blank line after the header:\n%s", name, content)
+				}
+				want := "// Code generated by proto-filter from " + name + ". DO NOT EDIT.\n" +
+					"// Config: " + filepath.ToSlash(cfgPath) + " (profile public), content " + writer.ContentHash([]byte(body))[:12] + "."
+				if header != want {
+					t.Errorf("%s: header =\n%s\nwant:\n%s", name, header, want)
+				}
+			}
+
+			content, _ := os.ReadFile(filepath.Join(outDir, "billing.proto"))
+			if !strings.Contains(string(content), "DO NOT EDIT.\n// Config: ") ||
+				!strings.Contains(string(content), "\n\n// Copyright 2024 United Traders.\n// Licensed under the Apache License, Version 2.0.\n\nsyntax = \"proto3\";") {
+				t.Errorf("expected the license header between the generated header and the syntax statement:\n%s", content)
+			}
+			if strings.Contains(string(content), "ledger_code") {
+				t.Errorf("expected @Internal field to be removed:\n%s", content)
+			}
+		})
+	}
+}
+
+func TestGitCommit(t *testing.T) {
+	if got := gitCommit(t.TempDir()); got != "" {
+		t.Errorf("gitCommit() outside a repository = %q, want empty", got)
+	}
+}
+
+func TestCleanCLI(t *testing.T) {
+	bin := buildBinary(t)
+	inDir := t.TempDir()
+	outDir := t.TempDir()
+	for _, name := range []string{"orders.proto", filepath.Join("legacy", "v1.proto"), filepath.Join("legacy", "edited.proto")} {
+		os.MkdirAll(filepath.Dir(filepath.Join(inDir, name)), 0o755)
+		os.WriteFile(filepath.Join(inDir, name), []byte("syntax = \"proto3\";\n\npackage orders;\n"), 0o644)
+	}
+	os.WriteFile(filepath.Join(outDir, "README.md"), []byte("not generated\n"), 0o644)
+
+	if stderr, code := runBinary(t, bin, "--input", inDir, "--output", outDir); code != 0 {
+		t.Fatalf("expected exit code 0, got %d; stderr: %s", code, stderr)
+	}
+	manifest, err := os.ReadFile(filepath.Join(outDir, ".proto-filter-manifest.json"))
+	if err != nil {
+		t.Fatalf("reading manifest: %v", err)
+	}
+	for _, path := range []string{`"legacy/edited.proto"`, `"legacy/v1.proto"`, `"orders.proto"`} {
+		if !strings.Contains(string(manifest), path) {
+			t.Errorf("manifest should list %s:\n%s", path, manifest)
+		}
+	}
+
+	// Without --clean stale files stay, and stay in the manifest
+	os.RemoveAll(filepath.Join(inDir, "legacy"))
+	if stderr, code := runBinary(t, bin, "--input", inDir, "--output", outDir); code != 0 {
+		t.Fatalf("expected exit code 0, got %d; stderr: %s", code, stderr)
+	}
+	if _, err := os.Stat(filepath.Join(outDir, "legacy", "v1.proto")); err != nil {
+		t.Errorf("stale file should be kept without --clean: %v", err)
+	}
+
+	os.WriteFile(filepath.Join(outDir, "legacy", "edited.proto"), []byte("// edited by hand\n"), 0o644)
+	stderr, code := runBinary(t, bin, "--input", inDir, "--output", outDir, "--clean", "--verbose")
+	if code != 0 {
+		t.Fatalf("expected exit code 0, got %d; stderr: %s", code, stderr)
+	}
+	if _, err := os.Stat(filepath.Join(outDir, "legacy", "v1.proto")); !os.IsNotExist(err) {
+		t.Errorf("stale file should be removed with --clean: %v", err)
+	}
+	for _, path := range []string{"orders.proto", "README.md", filepath.Join("legacy", "edited.proto")} {
+		if _, err := os.Stat(filepath.Join(outDir, path)); err != nil {
+			t.Errorf("%s should be kept: %v", path, err)
+		}
+	}
+	if !strings.Contains(stderr, "proto-filter: warning: not removing legacy/edited.proto: modified since it was written") {
+		t.Errorf("expected a warning for the edited file, got: %s", stderr)
+	}
+	if !strings.Contains(stderr, "proto-filter: removed 1 stale files") {
+		t.Errorf("expected verbose count of removed files, got: %s", stderr)
+	}
+	manifest, _ = os.ReadFile(filepath.Join(outDir, ".proto-filter-manifest.json"))
+	if strings.Contains(string(manifest), "legacy/") {
+		t.Errorf("manifest should only list this run's files:\n%s", manifest)
+	}
+}
+
+func TestAtomicOutputCLI(t *testing.T) {
+	bin := buildBinary(t)
+	inDir := t.TempDir()
+	parent := t.TempDir()
+	outDir := filepath.Join(parent, "out")
+	os.WriteFile(filepath.Join(inDir, "a.proto"), []byte("syntax = \"proto3\";\n\npackage a;\n\nmessage A {}\n"), 0o644)
+	os.WriteFile(filepath.Join(inDir, "b.proto"), []byte("syntax = \"proto3\";\n\npackage b;\n"), 0o644)
+
+	if stderr, code := runBinary(t, bin, "--input", inDir, "--output", outDir); code != 0 {
+		t.Fatalf("expected exit code 0, got %d; stderr: %s", code, stderr)
+	}
+	before := make(map[string][]byte)
+	for _, name := range []string{"a.proto", "b.proto", writer.ManifestName} {
+		content, err := os.ReadFile(filepath.Join(outDir, name))
+		if err != nil {
+			t.Fatalf("reading output: %v", err)
+		}
+		before[name] = content
+	}
+
+	// a.proto changes and b.proto is stale, but c.proto cannot be written
+	os.WriteFile(filepath.Join(inDir, "a.proto"), []byte("syntax = \"proto3\";\n\npackage a;\n\nmessage A {}\n\nmessage B {}\n"), 0o644)
+	os.Remove(filepath.Join(inDir, "b.proto"))
+	os.WriteFile(filepath.Join(inDir, "c.proto"), []byte("syntax = \"proto3\";\n\npackage c;\n"), 0o644)
+	os.MkdirAll(filepath.Join(outDir, "c.proto", "blocker"), 0o755)
+
+	stderr, code := runBinary(t, bin, "--input", inDir, "--output", outDir, "--clean")
+	if code != 1 {
+		t.Fatalf("expected exit code 1, got %d; stderr: %s", code, stderr)
+	}
+	if !strings.Contains(stderr, "writing c.proto") {
+		t.Errorf("expected an error for c.proto, got: %s", stderr)
+	}
+	for name, content := range before {
+		if after, err := os.ReadFile(filepath.Join(outDir, name)); err != nil || string(after) != string(content) {
+			t.Errorf("%s should keep its previous content after a failed run, got %v:\n%s", name, err, after)
+		}
+	}
+	if entries, _ := os.ReadDir(parent); len(entries) != 1 {
+		t.Errorf("the staging directory should be removed after a failed run, got %v", entries)
+	}
+
+	os.RemoveAll(filepath.Join(outDir, "c.proto"))
+	if stderr, code := runBinary(t, bin, "--input", inDir, "--output", outDir, "--clean"); code != 0 {
+		t.Fatalf("expected exit code 0, got %d; stderr: %s", code, stderr)
+	}
+	if after, _ := os.ReadFile(filepath.Join(outDir, "a.proto")); !strings.Contains(string(after), "message B") {
+		t.Errorf("output should be replaced after a successful run, got:\n%s", after)
+	}
+	if _, err := os.Stat(filepath.Join(outDir, "b.proto")); !os.IsNotExist(err) {
+		t.Errorf("stale b.proto should be removed after a successful run: %v", err)
+	}
+}
+
+func TestCheckStaleFilesCLI(t *testing.T) {
+	bin := buildBinary(t)
+	inDir := t.TempDir()
+	outDir := t.TempDir()
+	for _, name := range []string{"orders.proto", "legacy.proto"} {
+		os.WriteFile(filepath.Join(inDir, name), []byte("syntax = \"proto3\";\n\npackage orders;\n"), 0o644)
+	}
+	if stderr, code := runBinary(t, bin, "--input", inDir, "--output", outDir); code != 0 {
+		t.Fatalf("expected exit code 0, got %d; stderr: %s", code, stderr)
+	}
+	os.Remove(filepath.Join(inDir, "legacy.proto"))
+	if stderr, code := runBinary(t, bin, "--input", inDir, "--output", outDir); code != 0 {
+		t.Fatalf("expected exit code 0, got %d; stderr: %s", code, stderr)
+	}
+
+	// Without --clean a run keeps legacy.proto, so it is not a difference
+	stdout, stderr, code := runBinaryOutput(t, bin, "--input", inDir, "--output", outDir, "--check")
+	if code != 0 || stdout != "" {
+		t.Fatalf("carried-over stale files should pass --check, got exit code %d; stdout: %s; stderr: %s", code, stdout, stderr)
+	}
+
+	stdout, stderr, code = runBinaryOutput(t, bin, "--input", inDir, "--output", outDir, "--check", "--clean")
+	if code != 3 {
+		t.Fatalf("expected exit code 3 with --clean, got %d; stderr: %s", code, stderr)
+	}
+	if !strings.HasPrefix(stdout, "removed: legacy.proto\n") {
+		t.Errorf("expected legacy.proto to be removed, got:\n%s", stdout)
+	}
+	if !strings.Contains(stderr, "is out of date: 1 file differs from this run's output") {
+		t.Errorf("expected a singular out-of-date error, got: %s", stderr)
+	}
+}
+
+func TestSymlinkedOutputCLI(t *testing.T) {
+	bin := buildBinary(t)
+	inDir := testdataDir(t, "simple")
+	parent := t.TempDir()
+	linked := filepath.Join(parent, "generated")
+	os.Mkdir(linked, 0o755)
+	outDir := filepath.Join(parent, "out")
+	if err := os.Symlink(linked, outDir); err != nil {
+		t.Skipf("symlinks not supported: %v", err)
+	}
+
+	if stderr, code := runBinary(t, bin, "--input", inDir, "--output", outDir); code != 0 {
+		t.Fatalf("expected exit code 0, got %d; stderr: %s", code, stderr)
+	}
+	if info, err := os.Lstat(outDir); err != nil || info.Mode()&os.ModeSymlink == 0 {
+		t.Errorf("--output should stay a symbolic link, got %v, %v", info, err)
+	}
+	if _, err := os.Stat(filepath.Join(linked, writer.ManifestName)); err != nil {
+		t.Errorf("output should be written to the linked directory: %v", err)
+	}
+}
+
+func TestCheckCLI(t *testing.T) {
+	bin := buildBinary(t)
+	inDir := testdataDir(t, "header")
+	cfgPath := filepath.Join(inDir, "header.yaml")
+	outDir := filepath.Join(t.TempDir(), "out")
+
+	// Nothing generated yet: every file is added and nothing is written
+	stdout, stderr, code := runBinaryOutput(t, bin, "--input", inDir, "--output", outDir, "--config", cfgPath, "--check")
+	if code != 3 {
+		t.Fatalf("expected exit code 3, got %d; stderr: %s", code, stderr)
+	}
+	if !strings.HasPrefix(stdout, "added: billing.proto\nadded: refunds.proto\n--- /dev/null\n") {
+		t.Errorf("expected added files, got:\n%s", stdout)
+	}
+	if _, err := os.Stat(outDir); !os.IsNotExist(err) {
+		t.Errorf("--check should not create the output directory: %v", err)
+	}
+
+	if stderr, code := runBinary(t, bin, "--input", inDir, "--output", outDir, "--config", cfgPath); code != 0 {
+		t.Fatalf("expected exit code 0, got %d; stderr: %s", code, stderr)
+	}
+	stdout, stderr, code = runBinaryOutput(t, bin, "--input", inDir, "--output", outDir, "--config", cfgPath, "--check")
+	if code != 0 || stdout != "" {
+		t.Fatalf("expected an up-to-date output to pass, got exit code %d; stdout: %s; stderr: %s", code, stdout, stderr)
+	}
+
+	billing := filepath.Join(outDir, "billing.proto")
+	content, _ := os.ReadFile(billing)
+	edited := strings.Replace(string(content), "  int64  amount = 2;\n", "  int64  amount = 2;\n  string note   = 9;\n", 1)
+	os.WriteFile(billing, []byte(edited), 0o644)
+	os.Remove(filepath.Join(outDir, "refunds.proto"))
+	os.WriteFile(filepath.Join(outDir, "legacy.proto"), []byte("syntax = \"proto3\";\n"), 0o644)
+	os.WriteFile(filepath.Join(outDir, "NOTES.md"), []byte("not generated\n"), 0o644)
+	manifest, _ := os.ReadFile(filepath.Join(outDir, ".proto-filter-manifest.json"))
+
+	stdout, stderr, code = runBinaryOutput(t, bin, "--input", inDir, "--output", outDir, "--config", cfgPath, "--check")
+	if code != 3 {
+		t.Fatalf("expected exit code 3, got %d; stderr: %s", code, stderr)
+	}
+	display := filepath.ToSlash(outDir)
+	for _, want := range []string{
+		"added: refunds.proto\nremoved: legacy.proto\nchanged: billing.proto\n",
+		"--- /dev/null\n+++ " + display + "/refunds.proto\n@@ -0,0 +1,10 @@\n",
+		"--- " + display + "/legacy.proto\n+++ /dev/null\n@@ -1 +0,0 @@\n-syntax = \"proto3\";\n",
+		"--- " + display + "/billing.proto\n+++ " + display + "/billing.proto\n@@ -20,5 +20,4 @@\n message Invoice {\n   string id     = 1;\n   int64  amount = 2;\n-  string note   = 9;\n }\n",
+	} {
+		if !strings.Contains(stdout, want) {
+			t.Errorf("stdout should contain:\n%s\ngot:\n%s", want, stdout)
+		}
+	}
+	if strings.Contains(stdout, "NOTES.md") {
+		t.Errorf("files the tool does not write should not be reported:\n%s", stdout)
+	}
+	if !strings.Contains(stderr, "proto-filter: error: "+outDir+" is out of date: 3 files differ from this run's output") {
+		t.Errorf("expected an out-of-date error, got: %s", stderr)
+	}
+
+	if after, _ := os.ReadFile(billing); string(after) != edited {
+		t.Error("--check should not rewrite changed files")
+	}
+	if after, _ := os.ReadFile(filepath.Join(outDir, ".proto-filter-manifest.json")); string(after) != string(manifest) {
+		t.Error("--check should not rewrite the manifest")
+	}
+	for _, name := range []string{"legacy.proto", "NOTES.md"} {
+		if _, err := os.Stat(filepath.Join(outDir, name)); err != nil {
+			t.Errorf("--check should not remove %s: %v", name, err)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(outDir, "refunds.proto")); !os.IsNotExist(err) {
+		t.Error("--check should not write missing files")
+	}
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/unitedtraders/proto-filter/internal/diag"
+	"github.com/unitedtraders/proto-filter/internal/diff"
+	"github.com/unitedtraders/proto-filter/internal/writer"
+)
+
+// outputFile is a rendered output file, relative to the output directory.
+type outputFile struct {
+	rel     string
+	content []byte
+}
+
+// writeOutput builds the new output directory in a staging directory next
+// to it: a copy of the current output with outputs written over it, stale
+// files removed with clean, and the updated manifest. Once the outputs are
+// verified, the staging directory replaces the output directory, so a
+// failed run leaves the previous output untouched. outputDir is the
+// directory as given on the command line. It returns the stale files
+// removed, and false after reporting an error.
+func writeOutput(diags *diag.Reporter, absOutput, outputDir string, outputs []outputFile, clean bool) ([]string, bool) {
+	stage, err := writer.NewStage(absOutput)
+	if err != nil {
+		diags.Report(diag.Errorf(diag.RuleIO, "staging output: %v", err).At(outputDir, 0, 0))
+		return nil, false
+	}
+	defer stage.Discard()
+
+	written := writer.NewManifest()
+	for _, out := range outputs {
+		if err := writer.WriteFile(filepath.Join(stage.Dir, out.rel), out.content); err != nil {
+			diags.Report(diag.Errorf(diag.RuleIO, "writing %s: %v", out.rel, err).At(filepath.Join(outputDir, out.rel), 0, 0))
+			return nil, false
+		}
+		written.Add(out.rel, out.content)
+	}
+	if err := written.Verify(stage.Dir); err != nil {
+		diags.Report(diag.Errorf(diag.RuleIO, "verifying output: %v", err).At(outputDir, 0, 0))
+		return nil, false
+	}
+
+	// Record the written files in the manifest. Files an earlier run wrote
+	// are removed with --clean and carried over otherwise, so that a later
+	// --clean still knows about them.
+	manifestPath := filepath.Join(outputDir, writer.ManifestName)
+	previous, err := writer.LoadManifest(stage.Dir)
+	if err != nil {
+		if clean {
+			diags.Report(diag.Errorf(diag.RuleIO, "reading manifest: %v", err).At(manifestPath, 0, 0))
+			return nil, false
+		}
+		diags.Report(diag.Warningf(diag.RuleIO, "ignoring manifest: %v", err).At(manifestPath, 0, 0))
+		previous = writer.NewManifest()
+	}
+	var removed []string
+	if clean {
+		var modified []string
+		removed, modified, err = written.RemoveStale(stage.Dir, previous)
+		for _, path := range modified {
+			diags.Report(diag.Warningf(diag.RuleModifiedOutput, "not removing %s: modified since it was written", path).At(filepath.Join(outputDir, path), 0, 0))
+		}
+		if err != nil {
+			diags.Report(diag.Errorf(diag.RuleIO, "removing stale output: %v", err))
+			return nil, false
+		}
+	} else {
+		for _, path := range written.Stale(previous) {
+			written.Files[path] = previous.Files[path]
+		}
+	}
+	if err := written.Write(stage.Dir); err != nil {
+		diags.Report(diag.Errorf(diag.RuleIO, "writing manifest: %v", err).At(manifestPath, 0, 0))
+		return nil, false
+	}
+	if err := stage.Commit(); err != nil {
+		diags.Report(diag.Errorf(diag.RuleIO, "replacing output directory: %v", err).At(outputDir, 0, 0))
+		return nil, false
+	}
+	return removed, true
+}
+
+// checkOutput compares outputs with the output directory absOutput and
+// prints the added, removed and changed files to w, followed by a unified
+// diff of each. A file this run does not produce counts as removed if it
+// is a .proto file the manifest does not list, or, with clean, if the
+// manifest lists it; without clean a run carries listed files over, so
+// they are not a difference. outputDir names the directory in the diffs.
+// It returns the number of differing files.
+func checkOutput(w io.Writer, absOutput, outputDir string, outputs []outputFile, clean bool) (int, error) {
+	want := make(map[string][]byte, len(outputs))
+	for _, out := range outputs {
+		want[filepath.ToSlash(out.rel)] = out.content
+	}
+	have := make(map[string][]byte)
+	previous, err := writer.LoadManifest(absOutput)
+	if err != nil {
+		return 0, err
+	}
+	err = filepath.WalkDir(absOutput, func(file string, d fs.DirEntry, err error) error {
+		if errors.Is(err, fs.ErrNotExist) && file == absOutput {
+			return fs.SkipAll
+		}
+		if err != nil || d.IsDir() {
+			return err
+		}
+		rel, _ := filepath.Rel(absOutput, file)
+		rel = filepath.ToSlash(rel)
+		if _, produced := want[rel]; !produced {
+			if _, listed := previous.Files[rel]; listed && !clean || !listed && !strings.HasSuffix(rel, ".proto") {
+				return nil
+			}
+		}
+		content, err := os.ReadFile(file)
+		have[rel] = content
+		return err
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	var added, removed, changed []string
+	for rel, content := range want {
+		old, ok := have[rel]
+		switch {
+		case !ok:
+			added = append(added, rel)
+		case string(old) != string(content):
+			changed = append(changed, rel)
+		}
+	}
+	for rel := range have {
+		if _, ok := want[rel]; !ok {
+			removed = append(removed, rel)
+		}
+	}
+	sort.Strings(added)
+	sort.Strings(removed)
+	sort.Strings(changed)
+
+	for _, list := range []struct {
+		label string
+		paths []string
+	}{{"added", added}, {"removed", removed}, {"changed", changed}} {
+		for _, rel := range list.paths {
+			fmt.Fprintf(w, "%s: %s\n", list.label, rel)
+		}
+	}
+	display := func(rel string) string { return path.Join(filepath.ToSlash(outputDir), rel) }
+	for _, rel := range added {
+		fmt.Fprint(w, diff.Unified("/dev/null", display(rel), nil, want[rel]))
+	}
+	for _, rel := range removed {
+		fmt.Fprint(w, diff.Unified(display(rel), "/dev/null", have[rel], nil))
+	}
+	for _, rel := range changed {
+		fmt.Fprint(w, diff.Unified(display(rel), display(rel), have[rel], want[rel]))
+	}
+	return len(added) + len(removed) + len(changed), nil
+}